@@ -1,104 +1,128 @@
-package modecache
-
-import (
-	"context"
-	"math/rand"
-	"testing"
-	"time"
-
-	"github.com/spf13/cast"
-)
-
-type testSnakeCache struct {
-	mp map[string]any
-}
-
-func (s testSnakeCache) Get(ctx context.Context, key string) (any, error) {
-	if s.mp[key] == nil {
-		return nil, ErrKeyNonExistent
-	}
-	return s.mp[key], nil
-}
-
-func (s testSnakeCache) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
-	s.mp[key] = data
-	return nil
-}
-
-func (s testSnakeCache) Del(ctx context.Context, key string) error {
-	delete(s.mp, key)
-	return nil
-}
-
-func (s testSnakeCache) IsDirectStore() bool {
-	return true
-}
-
-func BenchmarkWrap(b *testing.B) {
-	store := testSnakeCache{mp: make(map[string]any)}
-
-	for i := 0; i < b.N; i++ {
-		key := cast.ToString(rand.Int63())
-		// 未命中缓存情况，包含读取和设置两级缓存
-		_, _ = Wrap(context.Background(), "test-name", store, key, func(ctx context.Context) (int64, error) {
-			return 0, nil
-		})
-	}
-}
-
-func BenchmarkWrapCtr(b *testing.B) {
-	store := testSnakeCache{mp: make(map[string]any)}
-	ctr := NewCacheController("test-name", store, WithPlugins[int64]())
-
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		key := cast.ToString(rand.Int63())
-		// 未命中缓存情况，包含读取和设置两级缓存
-		_, _ = ctr.Wrap(context.Background(), key, func(ctx context.Context) (int64, error) {
-			return 0, nil
-		})
-	}
-}
-
-func BenchmarkWrapReuseCtr(b *testing.B) {
-	store := testSnakeCache{mp: make(map[string]any)}
-	ctr := NewCacheController("test-name", store, WithPlugins[int64](), WithPolicy[int64](ReuseCachePloyIgnoreError(time.Minute)))
-
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		key := cast.ToString(rand.Int63())
-		// 未命中缓存情况，包含读取和设置两级缓存
-		_, _ = ctr.Wrap(context.Background(), key, func(ctx context.Context) (int64, error) {
-			return 0, nil
-		})
-	}
-}
-
-func BenchmarkWrapFirstCacheCtr(b *testing.B) {
-	store := testSnakeCache{mp: make(map[string]any)}
-	ctr := NewCacheController("test-name", store, WithPlugins[int64](), WithPolicy[int64](FirstCachePolyIgnoreError(time.Minute)))
-
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		key := cast.ToString(rand.Int63())
-		// 未命中缓存情况，包含读取和设置两级缓存
-		_, _ = ctr.Wrap(context.Background(), key, func(ctx context.Context) (int64, error) {
-			return 0, nil
-		})
-	}
-}
-
-func BenchmarkWrapRedisCtr(b *testing.B) {
-	store, cancel := getRedis()
-	defer cancel()
-	ctr := NewCacheController("test-name", store, WithPlugins[int64](), WithPolicy[int64](FirstCachePolyIgnoreError(time.Minute)))
-
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		key := cast.ToString(rand.Int63())
-		// 未命中缓存情况，包含读取和设置两级缓存
-		_, _ = ctr.Wrap(context.Background(), key, func(ctx context.Context) (int64, error) {
-			return 0, nil
-		})
-	}
-}
+package modecache
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/spf13/cast"
+)
+
+func BenchmarkWrap(b *testing.B) {
+	store := NewMemoryStore()
+
+	for i := 0; i < b.N; i++ {
+		key := cast.ToString(rand.Int63())
+		// 未命中缓存情况，包含读取和设置两级缓存
+		_, _ = Wrap(context.Background(), "test-name", store, key, func(ctx context.Context) (int64, error) {
+			return 0, nil
+		})
+	}
+}
+
+func BenchmarkWrapCtr(b *testing.B) {
+	store := NewMemoryStore()
+	ctr := NewCacheController("test-name", store, WithPlugins[int64]())
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := cast.ToString(rand.Int63())
+		// 未命中缓存情况，包含读取和设置两级缓存
+		_, _ = ctr.Wrap(context.Background(), key, func(ctx context.Context) (int64, error) {
+			return 0, nil
+		})
+	}
+}
+
+func BenchmarkWrapReuseCtr(b *testing.B) {
+	store := NewMemoryStore()
+	ctr := NewCacheController("test-name", store, WithPlugins[int64](), WithPolicy[int64](ReuseCachePloyIgnoreError(time.Minute)))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := cast.ToString(rand.Int63())
+		// 未命中缓存情况，包含读取和设置两级缓存
+		_, _ = ctr.Wrap(context.Background(), key, func(ctx context.Context) (int64, error) {
+			return 0, nil
+		})
+	}
+}
+
+func BenchmarkWrapFirstCacheCtr(b *testing.B) {
+	store := NewMemoryStore()
+	ctr := NewCacheController("test-name", store, WithPlugins[int64](), WithPolicy[int64](FirstCachePolyIgnoreError(time.Minute)))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := cast.ToString(rand.Int63())
+		// 未命中缓存情况，包含读取和设置两级缓存
+		_, _ = ctr.Wrap(context.Background(), key, func(ctx context.Context) (int64, error) {
+			return 0, nil
+		})
+	}
+}
+
+func BenchmarkWrapRedisCtr(b *testing.B) {
+	store, cancel := getRedis()
+	defer cancel()
+	ctr := NewCacheController("test-name", store, WithPlugins[int64](), WithPolicy[int64](FirstCachePolyIgnoreError(time.Minute)))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := cast.ToString(rand.Int63())
+		// 未命中缓存情况，包含读取和设置两级缓存
+		_, _ = ctr.Wrap(context.Background(), key, func(ctx context.Context) (int64, error) {
+			return 0, nil
+		})
+	}
+}
+
+func BenchmarkBoxBytes10KB(b *testing.B) {
+	blob := make([]byte, 10*1024)
+	for i := range blob {
+		blob[i] = byte(rand.Intn(256))
+	}
+	box := &AbcBox[[]byte]{T: blob, Timestamp: int(time.Now().Unix())}
+
+	b.Run("sonic", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			data, _ := sonic.MarshalString(box)
+			out := new(AbcBox[[]byte])
+			_ = sonic.Unmarshal([]byte(data), out)
+		}
+	})
+
+	b.Run("raw", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			data, _ := encodeRawBox(box)
+			out := new(AbcBox[[]byte])
+			_ = decodeRawBox(data, out)
+		}
+	})
+}
+
+// isNilReflect 只使用反射的 isNil, 用于对比标量类型的快速路径
+func isNilReflect(v any) bool {
+	return v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil())
+}
+
+func BenchmarkIsNil(b *testing.B) {
+	var value any = int64(1024)
+	b.Run("fast-path", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = isNil(value)
+		}
+	})
+	b.Run("reflect", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = isNilReflect(value)
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package modecache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock 时钟, 策略判断数据是否过期和写入缓存时记录数据创建时间使用, 测试中可以替换为手动推进的时钟
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// clock 当前使用的时钟, 为空时使用系统时钟
+var clock atomic.Pointer[Clock]
+
+// SetClock 设置全局时钟, 可以在运行时调用, c 为空时恢复系统时钟
+// # 注意时钟是全局的, 会影响所有控制器和策略, 通常只在测试中使用
+func SetClock(c Clock) {
+	if c == nil {
+		clock.Store(nil)
+		return
+	}
+	clock.Store(&c)
+}
+
+// now 获取当前时钟的时间
+func now() time.Time {
+	if c := clock.Load(); c != nil {
+		return (*c).Now()
+	}
+	return systemClock{}.Now()
+}
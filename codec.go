@@ -0,0 +1,155 @@
+package modecache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"unsafe"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// rawBoxMagic []byte/string 类型的原始编码标识, JSON 编码不会以 0x00 开头
+const rawBoxMagic = 0x00
+
+// protoBoxMagic proto.Message 类型的编码标识, 格式和原始编码相同, 数据部分为 proto 编码
+const protoBoxMagic = 0x01
+
+// protoMessageType proto.Message 接口的类型
+var protoMessageType = reflect.TypeFor[proto.Message]()
+
+// newBoxBuf 创建写入了编码头部的缓冲区, 并预留 payloadLen 的容量
+// 编码格式: magic(1) + 头部长度(1) + 头部(varint 时间戳, varint query 耗时, varint 毫秒时间戳) + 数据
+func newBoxBuf[T any](magic byte, box *AbcBox[T], payloadLen int) []byte {
+	var header [3 * binary.MaxVarintLen64]byte
+	n := binary.PutVarint(header[:], int64(box.Timestamp))
+	n += binary.PutVarint(header[n:], box.ComputeMs)
+	n += binary.PutVarint(header[n:], box.TimestampMs)
+
+	buf := make([]byte, 0, 2+n+payloadLen)
+	return append(append(buf, magic, byte(n)), header[:n]...)
+}
+
+// encodeRawBox 对 []byte/string 类型的数据使用原始编码, 避免 JSON 编码 (base64/转义) 带来的体积和 CPU 开销
+// 根据静态类型 T 选择编码, 和 decodeRawBox 一致, T 为接口类型 (例如 any) 时即使数据是 []byte/string 也不使用原始编码
+// return: 编码后的数据, 是否支持原始编码
+func encodeRawBox[T any](box *AbcBox[T]) (string, bool) {
+	var buf []byte
+	switch v := any(&box.T).(type) {
+	case *[]byte:
+		buf = append(newBoxBuf(rawBoxMagic, box, len(*v)), *v...)
+	case *string:
+		buf = append(newBoxBuf(rawBoxMagic, box, len(*v)), *v...)
+	default:
+		return "", false
+	}
+	// buf 不会再被修改, 直接转换为 string 避免复制
+	return unsafe.String(unsafe.SliceData(buf), len(buf)), true
+}
+
+// encodeProtoBox 对 proto.Message 类型的数据使用 proto 编码, 避免 JSON 编码丢失 well-known 类型的信息
+// 根据静态类型 T 是否实现 proto.Message 选择编码, T 为 any 等接口类型时使用 JSON 编码, 避免读取时无法确定 proto 类型
+// nil 指针不使用 proto 编码, 保持 JSON 编码的 null, 读取时依然视为空指针
+// return: 编码后的数据, 是否支持 proto 编码, 错误
+func encodeProtoBox[T any](box *AbcBox[T]) (string, bool, error) {
+	if !reflect.TypeFor[T]().Implements(protoMessageType) || isNil(box.T) {
+		return "", false, nil
+	}
+	msg := any(box.T).(proto.Message)
+	buf, err := proto.MarshalOptions{}.MarshalAppend(newBoxBuf(protoBoxMagic, box, proto.Size(msg)), msg)
+	if err != nil {
+		return "", true, err
+	}
+	return unsafe.String(unsafe.SliceData(buf), len(buf)), true, nil
+}
+
+// isRawBox 判断数据是否使用原始编码
+func isRawBox(data string) bool {
+	return len(data) >= 2 && data[0] == rawBoxMagic
+}
+
+// isProtoBox 判断数据是否使用 proto 编码
+func isProtoBox(data string) bool {
+	return len(data) >= 2 && data[0] == protoBoxMagic
+}
+
+// isLegacyValue 判断数据是否为未装箱的旧数据 (例如直接写入的数字或者字符串), 装箱数据总是 JSON 对象
+func isLegacyValue(data string) bool {
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '{':
+			return false
+		default:
+			return true
+		}
+	}
+	return true
+}
+
+// decodeBoxHeader 解码编码头部到 box, 返回数据部分
+func decodeBoxHeader[T any](data string, box *AbcBox[T]) (string, error) {
+	n := int(data[1])
+	if len(data) < 2+n {
+		return "", fmt.Errorf("%w: raw box header truncated", ErrUnpackingFailed)
+	}
+	header := []byte(data[2 : 2+n])
+	timestamp, tn := binary.Varint(header)
+	if tn <= 0 {
+		return "", fmt.Errorf("%w: raw box timestamp invalid", ErrUnpackingFailed)
+	}
+	// 头部可能包含更多字段, 只读取已知的字段
+	computeMs, cn := binary.Varint(header[tn:])
+	if cn <= 0 {
+		return "", fmt.Errorf("%w: raw box compute time invalid", ErrUnpackingFailed)
+	}
+	box.Timestamp = int(timestamp)
+	box.ComputeMs = computeMs
+	// 旧版本写入的头部没有毫秒时间戳
+	if rest := header[tn+cn:]; len(rest) > 0 {
+		timestampMs, mn := binary.Varint(rest)
+		if mn <= 0 {
+			return "", fmt.Errorf("%w: raw box millisecond timestamp invalid", ErrUnpackingFailed)
+		}
+		box.TimestampMs = timestampMs
+	}
+	return data[2+n:], nil
+}
+
+// decodeRawBox 解码原始编码的数据到 box, T 必须是 []byte 或 string
+func decodeRawBox[T any](data string, box *AbcBox[T]) error {
+	payload, err := decodeBoxHeader(data, box)
+	if err != nil {
+		return err
+	}
+	switch p := any(&box.T).(type) {
+	case *[]byte:
+		*p = []byte(payload)
+	case *string:
+		*p = payload
+	default:
+		return fmt.Errorf("%w: raw box need []byte or string but got %T", ErrUnpackingFailed, box.T)
+	}
+	return nil
+}
+
+// decodeProtoBox 解码 proto 编码的数据到 box, T 必须是 proto.Message 的指针类型
+// T 为接口类型时需要通过 WithValueFactory 预先设置 box.T 指定具体的 proto 类型
+func decodeProtoBox[T any](data string, box *AbcBox[T]) error {
+	payload, err := decodeBoxHeader(data, box)
+	if err != nil {
+		return err
+	}
+	msg, ok := any(box.T).(proto.Message)
+	if !ok {
+		return fmt.Errorf("%w: proto box need proto.Message but got %T", ErrUnpackingFailed, box.T)
+	}
+	// 生成的 proto 类型支持 nil 指针调用 ProtoReflect, 创建新的对象解码
+	msg = msg.ProtoReflect().Type().New().Interface()
+	if err = proto.Unmarshal([]byte(payload), msg); err != nil {
+		return fmt.Errorf("%w: proto box unmarshal fail, %w", ErrUnpackingFailed, err)
+	}
+	box.T = msg.(T)
+	return nil
+}
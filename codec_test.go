@@ -0,0 +1,205 @@
+package modecache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestRawBoxRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store, c := getRedis()
+	defer c()
+
+	// []byte 使用原始编码
+	blob := []byte{0x00, '{', 0xff, '"', 'a'}
+	require.NoError(t, SetStore(ctx, store, "bytes", blob, time.Minute))
+	raw, err := store.Get(ctx, "bytes")
+	require.NoError(t, err)
+	require.True(t, isRawBox(raw.(string)))
+	got, timestamp, err := GetStore[[]byte](ctx, store, "bytes")
+	require.NoError(t, err)
+	require.Equal(t, blob, got)
+	require.NotZero(t, timestamp)
+
+	// string 使用原始编码
+	require.NoError(t, SetStore(ctx, store, "string", "<p>hello</p>", time.Minute))
+	str, _, err := GetStore[string](ctx, store, "string")
+	require.NoError(t, err)
+	require.Equal(t, "<p>hello</p>", str)
+
+	// 兼容读取 JSON 编码的旧数据
+	legacy, err := sonic.MarshalString(&AbcBox[[]byte]{T: blob, Timestamp: 1})
+	require.NoError(t, err)
+	require.NoError(t, store.Set(ctx, "legacy", legacy, time.Minute))
+	got, timestamp, err = GetStore[[]byte](ctx, store, "legacy")
+	require.NoError(t, err)
+	require.Equal(t, blob, got)
+	require.Equal(t, 1, timestamp)
+
+	// 原始编码的数据不能读取为其他类型
+	_, _, err = GetStore[int](ctx, store, "bytes")
+	require.ErrorIs(t, err, ErrUnpackingFailed)
+}
+
+func TestLegacyValueMigrate(t *testing.T) {
+	ctx := context.Background()
+	store, c := getRedis()
+	defer c()
+
+	// 直接写入未装箱的数据
+	require.NoError(t, store.Set(ctx, "int", 123, time.Minute))
+	require.NoError(t, store.Set(ctx, "object", `{"Name":"wheat"}`, time.Minute))
+
+	// 读取视为未命中
+	_, _, err := GetStore[int](ctx, store, "int")
+	require.ErrorIs(t, err, ErrKeyNonExistent)
+	_, _, err = GetStore[map[string]string](ctx, store, "object")
+	require.ErrorIs(t, err, ErrKeyNonExistent)
+
+	// Wrap 重新查询并写入装箱后的数据
+	ctr := NewCacheController[int]("test-legacy", store)
+	res, err := ctr.Wrap(ctx, "int", func(ctx context.Context) (int, error) { return 456, nil })
+	require.NoError(t, err)
+	require.Equal(t, 456, res)
+	res, timestamp, err := ctr.GetStore(ctx, "int")
+	require.NoError(t, err)
+	require.Equal(t, 456, res)
+	require.NotZero(t, timestamp)
+}
+
+func TestProtoBoxRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store, c := getRedis()
+	defer c()
+
+	// proto.Message 使用 proto 编码, 保留 well-known 类型
+	msg, err := structpb.NewStruct(map[string]any{"name": "wheat", "tags": []any{"a", 1}})
+	require.NoError(t, err)
+	ctr := NewCacheController[*structpb.Struct]("test-proto", store)
+	res, err := ctr.Wrap(ctx, "struct", func(ctx context.Context) (*structpb.Struct, error) { return msg, nil })
+	require.NoError(t, err)
+	require.True(t, proto.Equal(msg, res))
+
+	raw, err := store.Get(ctx, "struct")
+	require.NoError(t, err)
+	require.True(t, isProtoBox(raw.(string)))
+	res, timestamp, err := ctr.GetStore(ctx, "struct")
+	require.NoError(t, err)
+	require.True(t, proto.Equal(msg, res))
+	require.NotZero(t, timestamp)
+
+	// 接口类型通过 WithValueFactory 指定具体的 proto 类型
+	ts := timestamppb.New(time.Unix(1700000000, 123))
+	anyCtr := NewCacheController("test-proto", store, WithValueFactory(func() proto.Message { return new(timestamppb.Timestamp) }))
+	require.NoError(t, anyCtr.SetStore(ctx, "timestamp", ts, time.Minute))
+	got, _, err := anyCtr.GetStore(ctx, "timestamp")
+	require.NoError(t, err)
+	require.True(t, proto.Equal(ts, got))
+
+	// nil 指针不使用 proto 编码, 读取时依然是空指针而不是空的 proto 对象
+	_, err = ctr.Wrap(ctx, "nil", func(ctx context.Context) (*structpb.Struct, error) { return nil, nil })
+	require.ErrorIs(t, err, ErrNil)
+	res, _, err = ctr.GetStore(ctx, "nil")
+	require.NoError(t, err)
+	require.Nil(t, res)
+}
+
+func TestUseStdlibJSON(t *testing.T) {
+	ctx := context.Background()
+	store, c := getRedis()
+	defer c()
+	type user struct {
+		Name string
+		Tags []string
+	}
+	value := user{Name: "<wheat>", Tags: []string{"a"}}
+
+	// sonic 写入, encoding/json 读取
+	require.NoError(t, SetStore(ctx, store, "sonic", value, time.Minute))
+	UseStdlibJSON(true)
+	defer UseStdlibJSON(false)
+	res, timestamp, err := GetStore[user](ctx, store, "sonic")
+	require.NoError(t, err)
+	require.Equal(t, value, res)
+	require.NotZero(t, timestamp)
+
+	// encoding/json 写入, sonic 读取
+	require.NoError(t, SetStore(ctx, store, "std", value, time.Minute))
+	UseStdlibJSON(false)
+	res, _, err = GetStore[user](ctx, store, "std")
+	require.NoError(t, err)
+	require.Equal(t, value, res)
+}
+
+func TestBoxHeaderTimestampMs(t *testing.T) {
+	box := &AbcBox[string]{T: "value", Timestamp: 1700000000, TimestampMs: 1700000000123, ComputeMs: 5}
+	data, ok := encodeRawBox(box)
+	require.True(t, ok)
+	got := &AbcBox[string]{}
+	require.NoError(t, decodeRawBox(data, got))
+	require.Equal(t, box, got)
+
+	// 旧版本的头部没有毫秒时间戳
+	old := string([]byte{rawBoxMagic, 2, 0x02, 0x04}) + "value"
+	got = &AbcBox[string]{}
+	require.NoError(t, decodeRawBox(old, got))
+	require.Equal(t, &AbcBox[string]{T: "value", Timestamp: 1, ComputeMs: 2}, got)
+}
+
+func TestEstimateSize(t *testing.T) {
+	ctx := context.Background()
+	store, c := getRedis()
+	defer c()
+
+	// 估算的大小和写入的数据一致
+	check := func(key string, size int, err error) {
+		require.NoError(t, err)
+		raw, err := store.Get(ctx, key)
+		require.NoError(t, err)
+		require.Equal(t, len(raw.(string)), size)
+	}
+	value := map[string]int{"a": 1}
+	size, err := EstimateSize(value)
+	require.NoError(t, SetStore(ctx, store, "json", value, time.Minute))
+	check("json", size, err)
+
+	size, err = EstimateSize([]byte("hello"))
+	require.NoError(t, SetStore(ctx, store, "raw", []byte("hello"), time.Minute))
+	check("raw", size, err)
+
+	_, err = EstimateSize(func() {})
+	require.Error(t, err)
+}
+
+func TestAnyBoxRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store, c := getRedis()
+	defer c()
+	ctr := NewCacheController[any]("test-any-box", store)
+
+	// T 为 any 时 []byte/string/proto 使用 JSON 编码, 可以读取
+	for key, value := range map[string]any{"string": "hello", "bytes": []byte("hello"), "proto": structpb.NewStringValue("hello")} {
+		calls := 0
+		for i := 0; i < 2; i++ {
+			_, err := ctr.Wrap(ctx, key, func(ctx context.Context) (any, error) {
+				calls++
+				return value, nil
+			})
+			require.NoError(t, err)
+		}
+		require.Equal(t, 1, calls, key)
+		raw, err := store.Get(ctx, key)
+		require.NoError(t, err)
+		require.False(t, isRawBox(raw.(string)) || isProtoBox(raw.(string)), key)
+	}
+	res, _, err := ctr.GetStore(ctx, "string")
+	require.NoError(t, err)
+	require.Equal(t, "hello", res)
+}
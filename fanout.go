@@ -0,0 +1,61 @@
+package modecache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// FanoutQuery 同时返回关联数据的 query, 返回: 数据, 需要同时缓存的关联数据 (key -> value), 错误
+type FanoutQuery[T any] func(ctx context.Context) (T, map[string]any, error)
+
+// FanoutValue 保留具体类型的关联数据, 使用 Fanout 创建
+type FanoutValue interface {
+	setStore(ctx context.Context, store Store, key string, ttl time.Duration) error
+}
+
+type fanoutValue[V any] struct {
+	value V
+}
+
+func (f fanoutValue[V]) setStore(ctx context.Context, store Store, key string, ttl time.Duration) error {
+	return SetStore(ctx, store, key, f.value, ttl)
+}
+
+// Fanout 包装关联数据, 使用数据的具体类型 V 装箱写入, 直接存储中的数据可以被 CacheCtr[V] 读取
+func Fanout[V any](value V) FanoutValue {
+	return fanoutValue[V]{value: value}
+}
+
+// WrapFanout 控制器的包装方法, query 执行成功时把返回的关联数据同时写入存储, 避免为了填充关联缓存重复执行 query
+// 关联数据使用 ttls 中对应 key 的过期时间, 没有设置时永久存储 (KeepTTL); 关联数据的 key 不经过控制器的 key 转换 (WithMaxKeyLength)
+// 关联数据写入失败不影响返回结果, 通过 OnError 回调通知
+// # 注意关联数据使用 Fanout 包装时按照具体类型装箱, 否则按照 any 装箱, 直接存储 (IsDirectStore) 中 any 装箱的数据
+// 无法被其他类型的控制器读取, 会被视为未命中
+func (c *CacheCtr[T]) WrapFanout(ctx context.Context, key string, query FanoutQuery[T], ttls map[string]time.Duration) (T, error) {
+	return c.Wrap(ctx, key, func(ctx context.Context) (T, error) {
+		value, related, err := query(ctx)
+		if err != nil {
+			return value, err
+		}
+		store := c.getStore(ctx)
+		var errs []error
+		for rKey, rValue := range related {
+			ttl, ok := ttls[rKey]
+			if !ok {
+				ttl = KeepTTL
+			}
+			fv, ok := rValue.(FanoutValue)
+			if !ok {
+				fv = Fanout(rValue)
+			}
+			if err := fv.setStore(ctx, store, rKey, ttl); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			c.callOnError(ctx, key, err)
+		}
+		return value, nil
+	})
+}
@@ -0,0 +1,54 @@
+package modecache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fanoutOrg struct {
+	ID   int
+	Name string
+}
+
+func TestWrapFanout(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	users := NewCacheController[string]("test-fanout-user", store)
+	orgs := NewCacheController[fanoutOrg]("test-fanout-org", store)
+
+	calls := 0
+	query := func(ctx context.Context) (string, map[string]any, error) {
+		calls++
+		return "alice", map[string]any{
+			"org:1":  Fanout(fanoutOrg{ID: 1, Name: "wheat"}),
+			"tags:1": []string{"a"},
+		}, nil
+	}
+	res, err := users.WrapFanout(ctx, "user:1", query, map[string]time.Duration{"org:1": time.Minute})
+	require.NoError(t, err)
+	require.Equal(t, "alice", res)
+
+	// 关联数据按照具体类型写入, 可以被其他控制器读取
+	org, err := orgs.Wrap(ctx, "org:1", func(ctx context.Context) (fanoutOrg, error) {
+		t.Fatal("org should be cached")
+		return fanoutOrg{}, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, fanoutOrg{ID: 1, Name: "wheat"}, org)
+	ttl, err := orgs.RemainingTTL(ctx, "org:1")
+	require.NoError(t, err)
+	require.LessOrEqual(t, ttl, time.Minute)
+
+	// 没有包装的数据按照 any 装箱, 没有设置过期时间时永久存储
+	tags, _, err := GetStore[any](ctx, store, "tags:1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, tags)
+
+	// 命中缓存时不执行 query
+	_, err = users.WrapFanout(ctx, "user:1", query, nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, calls)
+}
@@ -0,0 +1,88 @@
+// Package httpcache 使用 modecache 控制器缓存 http 请求的响应
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/wheat-os/modecache"
+)
+
+// cachedResponse 缓存的响应, 编码后保存在控制器中
+type cachedResponse struct {
+	StatusCode int         `json:"StatusCode"`
+	Header     http.Header `json:"Header"`
+	Body       []byte      `json:"Body"`
+}
+
+// cachingTransport 使用控制器缓存响应的 http.RoundTripper
+type cachingTransport struct {
+	ctr     *modecache.CacheCtr[[]byte]
+	keyFunc func(*http.Request) string
+	base    http.RoundTripper
+}
+
+// RoundTrip 缓存 GET 请求的响应, 其他请求或者 keyFunc 返回空字符串时直接使用 base 发送请求
+// 只有 2xx 响应会写入缓存, 其他响应直接返回, 不影响控制器中已有的缓存
+func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.base.RoundTrip(req)
+	}
+	key := t.keyFunc(req)
+	if key == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	data, err := t.ctr.WrapCacheable(req.Context(), key, func(ctx context.Context) ([]byte, bool, error) {
+		resp, err := t.base.RoundTrip(req.WithContext(ctx))
+		if err != nil {
+			return nil, false, err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, false, err
+		}
+		data, err := json.Marshal(cachedResponse{StatusCode: resp.StatusCode, Header: resp.Header, Body: body})
+		if err != nil {
+			return nil, false, err
+		}
+		return data, resp.StatusCode >= 200 && resp.StatusCode < 300, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var cached cachedResponse
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, fmt.Errorf("%w: decode cached response, %w", modecache.ErrUnpackingFailed, err)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", cached.StatusCode, http.StatusText(cached.StatusCode)),
+		StatusCode:    cached.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        cached.Header,
+		Body:          io.NopCloser(bytes.NewReader(cached.Body)),
+		ContentLength: int64(len(cached.Body)),
+		Request:       req,
+	}, nil
+}
+
+// NewCachingTransport 创建使用控制器缓存响应的 http.RoundTripper, 使用 http.DefaultTransport 发送请求
+// GET 请求使用 keyFunc 生成缓存 key, 通过控制器的 Wrap 按照控制器的策略读取或者写入响应的状态码, header 和 body
+// keyFunc 返回空字符串时不缓存本次请求
+// # 注意响应 body 会被完整读取到内存中, 不适用于大文件或者流式响应
+func NewCachingTransport(ctr *modecache.CacheCtr[[]byte], keyFunc func(*http.Request) string) http.RoundTripper {
+	return NewCachingTransportWithBase(ctr, keyFunc, http.DefaultTransport)
+}
+
+// NewCachingTransportWithBase 创建使用控制器缓存响应的 http.RoundTripper, 使用 base 发送请求, 见 NewCachingTransport
+func NewCachingTransportWithBase(ctr *modecache.CacheCtr[[]byte], keyFunc func(*http.Request) string, base http.RoundTripper) http.RoundTripper {
+	return &cachingTransport{ctr: ctr, keyFunc: keyFunc, base: base}
+}
@@ -0,0 +1,61 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wheat-os/modecache"
+)
+
+func TestCachingTransport(t *testing.T) {
+	var calls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Test", "1")
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	ctr := modecache.NewCacheController[[]byte]("test-http-cache", modecache.NewMemoryStore(),
+		modecache.WithPolicy[[]byte](modecache.EasyPloy(time.Minute)))
+	client := &http.Client{Transport: NewCachingTransport(ctr, func(r *http.Request) string {
+		return r.URL.String()
+	})}
+
+	// GET 请求命中缓存
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(server.URL + "/hello")
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "1", resp.Header.Get("X-Test"))
+		require.Equal(t, "hello", string(body))
+	}
+	require.Equal(t, int64(1), calls.Load())
+
+	// 非 2xx 响应不写入缓存
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(server.URL + "/missing")
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+	require.Equal(t, int64(3), calls.Load())
+
+	// 非 GET 请求直接发送
+	resp, err := client.Post(server.URL+"/hello", "text/plain", nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, int64(4), calls.Load())
+}
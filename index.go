@@ -0,0 +1,83 @@
+package modecache
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+)
+
+// IndexKeyPrefix 二级索引的 key 前缀, 索引 key 为 IndexKeyPrefix + 控制器名称 + ":" + 属性 + ":" + 属性值
+const IndexKeyPrefix = "index:"
+
+// IndexExtractor 从缓存数据中提取二级索引, 返回 属性 -> 属性值 列表, 例如 {"email": {"a@example.com"}}
+type IndexExtractor[T any] func(value T) map[string][]string
+
+// indexKey 获取二级索引的 key
+func (c *CacheCtr[T]) indexKey(attr, value string) string {
+	return c.storeKey(IndexKeyPrefix + c.Name + ":" + attr + ":" + value)
+}
+
+// getIndex 读取二级索引指向的主键列表, 索引不存在时返回空列表
+func (c *CacheCtr[T]) getIndex(ctx context.Context, store Store, indexKey string) ([]string, error) {
+	value, err := store.Get(ctx, indexKey)
+	if err != nil {
+		if errors.Is(err, ErrKeyNonExistent) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	data, _ := value.(string)
+	var keys []string
+	if err = unmarshalJSON(data, &keys); err != nil {
+		// 损坏的索引视为空, 由下一次写入覆盖
+		return nil, nil
+	}
+	return keys, nil
+}
+
+// setIndex 写入缓存数据的二级索引, 把 key 合并到每个索引指向的主键列表
+// 读取合并写入不是原子操作, 并发写入相同的索引可能丢失主键, 索引只用于失效缓存, 不保证完整
+func (c *CacheCtr[T]) setIndex(ctx context.Context, key string, value T, ttl time.Duration) error {
+	store := c.getStore(ctx)
+	var errs []error
+	for attr, values := range c.indexer(value) {
+		for _, v := range values {
+			indexKey := c.indexKey(attr, v)
+			keys, err := c.getIndex(ctx, store, indexKey)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if slices.Contains(keys, key) {
+				continue
+			}
+			data, err := marshalJSON(append(keys, key))
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if err = store.Set(ctx, indexKey, data, ttl); err != nil {
+				errs = append(errs, &StoreError{Op: "set", Key: indexKey, Err: err})
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DelBySecondary 根据二级索引删除缓存, 删除索引指向的全部缓存和索引本身, 需要使用 WithSecondaryIndex 开启二级索引
+// 索引指向的缓存可能已经更新为其他属性值, 这些缓存同样会被删除, 由 query 重新写入
+func (c *CacheCtr[T]) DelBySecondary(ctx context.Context, attr, value string) error {
+	store := c.getStore(ctx)
+	indexKey := c.indexKey(attr, value)
+	keys, err := c.getIndex(ctx, store, indexKey)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = store.Del(ctx, c.storeKey(key)); err != nil {
+			return err
+		}
+	}
+	return store.Del(ctx, indexKey)
+}
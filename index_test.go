@@ -0,0 +1,50 @@
+package modecache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type indexUser struct {
+	ID    string
+	Email string
+}
+
+func TestDelBySecondary(t *testing.T) {
+	ctx := context.Background()
+	store, closer := getRedis()
+	defer closer()
+	ctr := NewCacheController[indexUser]("test-index", store, WithSecondaryIndex(func(u indexUser) map[string][]string {
+		return map[string][]string{"email": {u.Email}}
+	}))
+
+	require.NoError(t, ctr.SetStore(ctx, "user:1", indexUser{ID: "1", Email: "a@example.com"}, time.Minute))
+	require.NoError(t, ctr.SetStore(ctx, "user:2", indexUser{ID: "2", Email: "a@example.com"}, time.Minute))
+	require.NoError(t, ctr.SetStore(ctx, "user:3", indexUser{ID: "3", Email: "b@example.com"}, time.Minute))
+	// 重复写入不会重复记录主键
+	require.NoError(t, ctr.SetStore(ctx, "user:1", indexUser{ID: "1", Email: "a@example.com"}, time.Minute))
+
+	require.NoError(t, ctr.DelBySecondary(ctx, "email", "a@example.com"))
+	for _, key := range []string{"user:1", "user:2", IndexKeyPrefix + "test-index:email:a@example.com"} {
+		_, err := store.Get(ctx, key)
+		require.ErrorIs(t, err, ErrKeyNonExistent, key)
+	}
+	user, _, err := ctr.GetStore(ctx, "user:3")
+	require.NoError(t, err)
+	require.Equal(t, "3", user.ID)
+
+	// 不存在的索引不返回错误
+	require.NoError(t, ctr.DelBySecondary(ctx, "email", "missing@example.com"))
+
+	// 通过 Wrap 写入的缓存同样维护索引
+	_, err = ctr.Wrap(ctx, "user:4", func(ctx context.Context) (indexUser, error) {
+		return indexUser{ID: "4", Email: "c@example.com"}, nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, ctr.DelBySecondary(ctx, "email", "c@example.com"))
+	_, err = store.Get(ctx, "user:4")
+	require.ErrorIs(t, err, ErrKeyNonExistent)
+}
@@ -0,0 +1,34 @@
+package modecache
+
+import (
+	"encoding/json"
+	"sync/atomic"
+
+	"github.com/bytedance/sonic"
+)
+
+// useStdJSON 是否使用 encoding/json 编码非直接存储的数据
+var useStdJSON atomic.Bool
+
+// UseStdlibJSON 设置非直接存储是否使用 encoding/json 代替 sonic 编码和解码 AbcBox, 默认使用 sonic
+// 适用于 sonic 的 JIT 不可用或者不稳定的环境 (例如部分 ARM 平台), 两种编码的数据可以互相读取, 可以在运行时切换
+func UseStdlibJSON(enable bool) {
+	useStdJSON.Store(enable)
+}
+
+// marshalJSON 编码数据为 JSON 字符串
+func marshalJSON(v any) (string, error) {
+	if useStdJSON.Load() {
+		data, err := json.Marshal(v)
+		return string(data), err
+	}
+	return sonic.MarshalString(v)
+}
+
+// unmarshalJSON 解码 JSON 字符串
+func unmarshalJSON(data string, v any) error {
+	if useStdJSON.Load() {
+		return json.Unmarshal([]byte(data), v)
+	}
+	return sonic.UnmarshalString(data, v)
+}
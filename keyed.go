@@ -0,0 +1,108 @@
+package modecache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// KeyFunc 把类型化的 key 转换为缓存使用的字符串 key, 相同的 key 必须得到相同的字符串
+type KeyFunc[K comparable] func(key K) string
+
+// DefaultKeyFunc 默认的 key 转换方法
+// 字符串类型直接使用, 数字和布尔类型使用 fmt 格式化, 其他类型 (结构体, 数组等) 使用 encoding/json 编码,
+// 结构体按照字段声明顺序, map 按照 key 排序, 结果是确定的
+// 只有未导出字段的结构体 (JSON 编码为 {}) 使用 %#v 格式化, 避免不同的 key 得到相同的字符串
+// # 注意指针类型使用指针指向的数据编码, 包含导出字段时未导出的字段不参与编码, 需要参与 key 的字段必须导出
+func DefaultKeyFunc[K comparable](key K) string {
+	v := reflect.ValueOf(key)
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return fmt.Sprint(key)
+	default:
+		data, err := json.Marshal(key)
+		if err != nil {
+			return fmt.Sprintf("%+v", key)
+		}
+		// 只有未导出字段的结构体编码为 {}, 不同的 key 会冲突, 使用 %#v 格式化全部字段
+		if string(data) == "{}" {
+			if sv := reflect.Indirect(v); sv.Kind() == reflect.Struct && sv.NumField() > 0 {
+				return fmt.Sprintf("%#v", sv.Interface())
+			}
+		}
+		return string(data)
+	}
+}
+
+// KeyedController 使用类型化 key 的缓存控制器, 通过 KeyFunc 生成字符串 key 后调用 CacheCtr
+// 避免调用方各自拼接字符串 key 导致的格式不一致和冲突
+type KeyedController[K comparable, T any] struct {
+	ctr   *CacheCtr[T]
+	keyFn KeyFunc[K]
+}
+
+// Key 获取 key 对应的字符串 key
+func (k *KeyedController[K, T]) Key(key K) string {
+	return k.keyFn(key)
+}
+
+// Controller 获取内部的缓存控制器, 可以使用字符串 key 访问
+func (k *KeyedController[K, T]) Controller() *CacheCtr[T] {
+	return k.ctr
+}
+
+// Wrap 控制器的包装方法, 参考 CacheCtr.Wrap
+func (k *KeyedController[K, T]) Wrap(ctx context.Context, key K, query Query[T]) (T, error) {
+	return k.ctr.Wrap(ctx, k.keyFn(key), query)
+}
+
+// WrapCacheable 控制器的包装方法, 参考 CacheCtr.WrapCacheable
+func (k *KeyedController[K, T]) WrapCacheable(ctx context.Context, key K, query CacheableQuery[T]) (T, error) {
+	return k.ctr.WrapCacheable(ctx, k.keyFn(key), query)
+}
+
+// GetStore 从 Store 中获取缓存, 参考 CacheCtr.GetStore
+func (k *KeyedController[K, T]) GetStore(ctx context.Context, key K) (T, int, error) {
+	return k.ctr.GetStore(ctx, k.keyFn(key))
+}
+
+// GetBox 从 Store 中获取装箱的缓存, 参考 CacheCtr.GetBox
+func (k *KeyedController[K, T]) GetBox(ctx context.Context, key K) (*AbcBox[T], error) {
+	return k.ctr.GetBox(ctx, k.keyFn(key))
+}
+
+// SetStore 设置缓存到 Store, 参考 CacheCtr.SetStore
+func (k *KeyedController[K, T]) SetStore(ctx context.Context, key K, value T, ttl time.Duration) error {
+	return k.ctr.SetStore(ctx, k.keyFn(key), value, ttl)
+}
+
+// Exists 判断缓存是否存在, 参考 CacheCtr.Exists
+func (k *KeyedController[K, T]) Exists(ctx context.Context, key K) (bool, error) {
+	return k.ctr.Exists(ctx, k.keyFn(key))
+}
+
+// Del 删除缓存
+func (k *KeyedController[K, T]) Del(ctx context.Context, key K) error {
+	sKey := k.keyFn(key)
+	if err := k.ctr.validateKey(sKey); err != nil {
+		return err
+	}
+	return k.ctr.getStore(ctx).Del(ctx, k.ctr.storeKey(sKey))
+}
+
+// NewKeyedController 创建使用类型化 key 的缓存控制器, keyFn 为空时使用 DefaultKeyFunc
+func NewKeyedController[K comparable, T any](name string, store Store, keyFn KeyFunc[K], optionChain ...Option[T]) *KeyedController[K, T] {
+	if keyFn == nil {
+		keyFn = DefaultKeyFunc[K]
+	}
+	return &KeyedController[K, T]{
+		ctr:   NewCacheController[T](name, store, optionChain...),
+		keyFn: keyFn,
+	}
+}
@@ -0,0 +1,70 @@
+package modecache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type keyedParams struct {
+	UserID int
+	Tags   map[string]string
+}
+
+func TestDefaultKeyFunc(t *testing.T) {
+	require.Equal(t, "user:1", DefaultKeyFunc("user:1"))
+	require.Equal(t, "42", DefaultKeyFunc(42))
+	require.Equal(t, "true", DefaultKeyFunc(true))
+
+	// 结构体按照字段顺序, map 按照 key 排序
+	key := &keyedParams{UserID: 1, Tags: map[string]string{"b": "2", "a": "1"}}
+	require.Equal(t, `{"UserID":1,"Tags":{"a":"1","b":"2"}}`, DefaultKeyFunc(key))
+	require.Equal(t, `[1,2]`, DefaultKeyFunc([2]int{1, 2}))
+
+	// 只有未导出字段的结构体不会编码为 {}
+	type privateKey struct {
+		tenant string
+		id     int
+	}
+	require.NotEqual(t, DefaultKeyFunc(privateKey{tenant: "a", id: 1}), DefaultKeyFunc(privateKey{tenant: "b", id: 1}))
+	require.Equal(t, DefaultKeyFunc(privateKey{tenant: "a", id: 1}), DefaultKeyFunc(&privateKey{tenant: "a", id: 1}))
+	require.Equal(t, `{}`, DefaultKeyFunc(struct{}{}))
+}
+
+func TestKeyedController(t *testing.T) {
+	type userKey struct {
+		TenantID string
+		UserID   int
+	}
+	ctx := context.Background()
+	store := NewMemoryStore()
+	ctr := NewKeyedController[userKey, int]("test-keyed", store, nil)
+
+	key := userKey{TenantID: "t1", UserID: 1}
+	res, err := ctr.Wrap(ctx, key, func(ctx context.Context) (int, error) { return 1, nil })
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+
+	// 使用生成的字符串 key 存储
+	require.Equal(t, `{"TenantID":"t1","UserID":1}`, ctr.Key(key))
+	_, err = store.Get(ctx, ctr.Key(key))
+	require.NoError(t, err)
+	res, _, err = ctr.GetStore(ctx, userKey{TenantID: "t1", UserID: 1})
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+
+	require.NoError(t, ctr.Del(ctx, key))
+	ok, err := ctr.Exists(ctx, key)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// 自定义 key 转换方法
+	custom := NewKeyedController[userKey, int]("test-keyed", store, func(k userKey) string {
+		return "user:" + k.TenantID + ":" + DefaultKeyFunc(k.UserID)
+	})
+	require.NoError(t, custom.SetStore(ctx, key, 2, time.Minute))
+	_, err = store.Get(ctx, "user:t1:1")
+	require.NoError(t, err)
+}
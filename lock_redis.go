@@ -0,0 +1,56 @@
+package modecache
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RefreshLocker 后台刷新使用的分布式锁
+type RefreshLocker interface {
+	// TryLock 尝试获取 key 的锁, 不等待
+	// return: 释放锁的方法, 是否获取到锁, 错误 (锁服务不可用)
+	TryLock(ctx context.Context, key string) (func(), bool, error)
+}
+
+// unlockScript 只删除自己持有的锁, 避免锁过期后删除其他节点的锁
+var unlockScript = redis.NewScript(`
+if redis.call('get', KEYS[1]) == ARGV[1] then
+	return redis.call('del', KEYS[1])
+end
+return 0
+`)
+
+// 使用 redis SET NX PX 实现的分布式锁
+type redisLocker struct {
+	rds    *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+func (r *redisLocker) TryLock(ctx context.Context, key string) (func(), bool, error) {
+	lockKey := r.prefix + key
+	token := strconv.FormatUint(rand.Uint64(), 36)
+	err := r.rds.SetArgs(ctx, lockKey, token, redis.SetArgs{Mode: "NX", TTL: r.ttl}).Err()
+	switch {
+	case err == nil:
+	case errors.Is(err, redis.Nil):
+		return nil, false, nil
+	default:
+		return nil, false, storeUnavailable(err)
+	}
+	return func() {
+		// 释放锁不使用调用方的上下文, 避免刷新超时后无法释放
+		_ = unlockScript.Run(context.Background(), r.rds, []string{lockKey}, token).Err()
+	}, true, nil
+}
+
+// NewRedisRefreshLocker 创建 redis 分布式锁, 锁保存在 prefix+key 中, ttl 后自动释放
+// ttl 应该大于后台刷新的超时时间, 避免刷新期间锁过期后其他节点重复刷新
+func NewRedisRefreshLocker(rd *redis.Client, prefix string, ttl time.Duration) RefreshLocker {
+	return &redisLocker{rds: rd, prefix: prefix, ttl: ttl}
+}
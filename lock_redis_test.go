@@ -0,0 +1,95 @@
+package modecache
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisRefreshLocker(t *testing.T) {
+	s := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer rds.Close()
+	ctx := context.Background()
+	locker := NewRedisRefreshLocker(rds, "lock:", time.Second)
+
+	unlock, ok, err := locker.TryLock(ctx, "key")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// 锁被持有
+	_, ok, err = locker.TryLock(ctx, "key")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// 释放后可以再次获取
+	unlock()
+	unlock2, ok, err := locker.TryLock(ctx, "key")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// 锁过期后被其他节点获取, 旧的释放方法不会删除新的锁
+	s.FastForward(2 * time.Second)
+	_, ok, err = locker.TryLock(ctx, "key")
+	require.NoError(t, err)
+	require.True(t, ok)
+	unlock2()
+	require.True(t, s.Exists("lock:key"))
+
+	// redis 不可用
+	s.Close()
+	_, _, err = locker.TryLock(ctx, "other")
+	require.ErrorIs(t, err, ErrStoreUnavailable)
+}
+
+func TestFirstCacheRefreshLocker(t *testing.T) {
+	s := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer rds.Close()
+	ctx := context.Background()
+	store := NewMemoryStore()
+	locker := NewRedisRefreshLocker(rds, "lock:", time.Minute)
+	_ = store.Set(ctx, "key", &AbcBox[int]{T: 1, Timestamp: int(time.Now().Add(-time.Minute).Unix())}, KeepTTL)
+
+	var queryCount int64
+	release := make(chan struct{})
+	query := func(ctx context.Context) (int, error) {
+		atomic.AddInt64(&queryCount, 1)
+		<-release
+		return 2, nil
+	}
+
+	// 两个节点使用各自的策略和同一个分布式锁, 只有一个节点刷新
+	node1 := NewCacheController[int]("node1", store, WithPolicy[int](FirstCachePolyIgnoreError(time.Second, WithRefreshLocker(locker, false))))
+	node2 := NewCacheController[int]("node2", store, WithPolicy[int](FirstCachePolyIgnoreError(time.Second, WithRefreshLocker(locker, false))))
+	res, err := node1.Wrap(ctx, "key", query)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&queryCount) == 1 }, time.Second, time.Millisecond)
+
+	res, err = node2.Wrap(ctx, "key", query)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, int64(1), atomic.LoadInt64(&queryCount))
+	close(release)
+	require.Eventually(t, func() bool { return !s.Exists("lock:key") }, time.Second, 10*time.Millisecond)
+
+	// redis 不可用时按照配置退化为本地锁刷新或者放弃刷新
+	s.Close()
+	_ = store.Set(ctx, "key", &AbcBox[int]{T: 1, Timestamp: int(time.Now().Add(-time.Minute).Unix())}, KeepTTL)
+	_, err = node2.Wrap(ctx, "key", query)
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, int64(1), atomic.LoadInt64(&queryCount))
+
+	node3 := NewCacheController[int]("node3", store, WithPolicy[int](FirstCachePolyIgnoreError(time.Second, WithRefreshLocker(locker, true))))
+	_, err = node3.Wrap(ctx, "key", query)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&queryCount) == 2 }, time.Second, time.Millisecond)
+}
@@ -1,390 +1,1433 @@
-package modecache
-
-import (
-	"context"
-	"fmt"
-	"sync"
-	"time"
-
-	"github.com/bytedance/sonic"
-	"github.com/pkg/errors"
-)
-
-const (
-	SHadowKeyPrefix = "shadow:"
-
-	KeepTTL = -1 // 永久存储
-)
-
-var (
-	ErrKeyNonExistent  = errors.New("modecache: key does not exist")    // ErrKeyNonExistent 缓存键不存在。
-	ErrUnpackingFailed = errors.New("modecache: warp unpacking failed") // warp 拆箱失败。
-	ErrNil             = errors.New("null pointer")                     // Nil 空指针。
-)
-
-type (
-	Store interface {
-		// Get 获取缓存。当缓存键不存在时返回 ErrKeyNonExistent 错误。
-		Get(ctx context.Context, key string) (any, error)
-		// Set 设置缓存, ttl 使用 KeepTTL 表示用不过期
-		Set(ctx context.Context, key string, data any, ttl time.Duration) error
-		// Del 删除缓存。
-		Del(ctx context.Context, key string) error
-
-		// IsDirectStore 释放可以直接存储数据，而不需要编码后存储
-		// 当 IsDirectStore 为 True 时，存储管理器会少一次编码和解码的操作，以提高缓存读取的性能（本地缓存可用）
-		IsDirectStore() bool
-	}
-
-	// Query 查询方法类型。
-	Query[T any] func(context.Context) (T, error)
-
-	// AbcBox 抽象箱
-	AbcBox[T any] struct {
-		Timestamp int `json:"Timestamp"`
-		T         T   `json:"T"`
-	}
-
-	// LoadingForCache 封装查询方法，return：数据, 数据创建时间，错误
-	LoadingForCache func(ctx context.Context, key string) (any, int, error)
-
-	// LoadingForQuery 数据库封装方法
-	LoadingForQuery func(ctx context.Context, key string, ttl time.Duration) (any, error)
-
-	// Policy 缓存控制策略, 用来控制缓存策略
-	Policy func(ctx context.Context, key string, queryFormDB LoadingForQuery, queryFormCache LoadingForCache) (any, error)
-
-	// 访问控制插件
-	Plugin interface {
-		// InterceptCallQuery 查询 query 前拦截调用
-		// return: LoadingForQuery: 不为空的场景,替换执行的 LoadingForQuery
-		// return: bool：是否允许继续执行插件，还是提前熔断
-		// return: error: 错误, 会导流程结束返回 error
-		InterceptCallQuery(ctx context.Context, key string, loadQuery LoadingForQuery) (LoadingForQuery, bool, error)
-
-		// InterceptCallCache 查询 cache 前拦截调用
-		// return: LoadingForCache: 不为空的场景,替换执行的 LoadingForCache
-		// return: bool：是否允许继续执行插件，还是提前熔断
-		// return: error: 错误, 会导流程结束返回 error
-		InterceptCallCache(ctx context.Context, key string, loadCache LoadingForCache) (LoadingForCache, bool, error)
-	}
-)
-
-// CtxStorageKey 上下文存储键,用来存储可变的 storage 实现替换全局 storage
-type CtxStorageKey struct{}
-
-type CacheCtr[T any] struct {
-	Name    string   // 缓存控制名称
-	plugins []Plugin // 缓存控制器插件
-	warp    Policy   // 缓存控制策略
-	store   Store    // 缓存层
-}
-
-// SetStore 设置缓存到 Store
-func (c *CacheCtr[T]) SetStore(ctx context.Context, key string, value T, ttl time.Duration) error {
-	// 优先使用 上下文中的 Store
-	store := c.store
-	if ctxStore, ok := ctx.Value(CtxStorageKey{}).(Store); ok {
-		store = ctxStore
-	}
-
-	// 装箱
-	box := AbcBox[T]{
-		T:         value,
-		Timestamp: int(time.Now().Unix()),
-	}
-	// 设置缓存, 根据 OriginalStore 检查
-	if store.IsDirectStore() {
-		return store.Set(ctx, key, &box, ttl)
-	}
-
-	// 编码处理
-	strVal, err := sonic.MarshalString(&box)
-	if err != nil {
-		return err
-	}
-	return store.Set(ctx, key, strVal, ttl)
-}
-
-// GetStore 从 Store 中获取缓存
-func (c *CacheCtr[T]) GetStore(ctx context.Context, key string) (T, int, error) {
-	// 优先使用 上下文中的 Store
-	store := c.store
-	if ctxStore, ok := ctx.Value(CtxStorageKey{}).(Store); ok {
-		store = ctxStore
-	}
-
-	value, err := store.Get(ctx, key)
-	if err != nil {
-		return *new(T), 0, err
-	}
-	var box = new(AbcBox[T])
-	if store.IsDirectStore() {
-		cBox, ok := value.(*AbcBox[T])
-		if !ok {
-			return *new(T), 0, fmt.Errorf("%w: assert type to abcBox fail", ErrUnpackingFailed)
-		}
-		box = cBox
-	} else {
-		strVal, ok := value.(string)
-		if !ok {
-			return *new(T), 0, fmt.Errorf("%w: directStore need string but got %s", ErrUnpackingFailed, fmt.Sprintf("%T", strVal))
-		}
-		if err = sonic.Unmarshal([]byte(strVal), box); err != nil {
-			return *new(T), 0, fmt.Errorf("%w: directStore unmarshal to abcBox fail, %w", ErrUnpackingFailed, err)
-		}
-	}
-	return box.T, box.Timestamp, nil
-}
-
-// Wrap 控制器的包装方法，控制使用 warp 方案
-func (c *CacheCtr[T]) Wrap(ctx context.Context, key string, query Query[T]) (p T, err error) {
-	loadQuery, err := c.buildTryLoadingQuery(ctx, key, query)
-	if err != nil {
-		return p, err
-	}
-	loadCache, err := c.buildTryLoadingCache(ctx, key)
-	if err != nil {
-		return p, err
-	}
-
-	result, err := c.warp(ctx, key, loadQuery, loadCache)
-	if err != nil {
-		return p, err
-	}
-	v, ok := result.(T)
-	if !ok {
-		return p, errors.WithMessage(ErrUnpackingFailed, "pares for T error")
-	}
-	return v, nil
-}
-
-// buildTryLoadingCache 构造缓存加载方法
-func (c *CacheCtr[T]) buildTryLoadingCache(ctx context.Context, key string) (LoadingForCache, error) {
-	loadCache := func(ctx context.Context, key string) (any, int, error) {
-		value, timestamp, err := c.GetStore(ctx, key)
-		if err != nil {
-			return nil, 0, err
-		}
-		if isNil(value) {
-			return nil, 0, ErrNil
-		}
-		return value, timestamp, nil
-	}
-
-	for _, plugin := range c.plugins {
-		plugCache, ok, err := plugin.InterceptCallCache(ctx, key, loadCache)
-		if err != nil {
-			return nil, err
-		}
-		loadCache = plugCache
-		if !ok {
-			break
-		}
-	}
-	return loadCache, nil
-}
-
-// 构造 query 加载方法
-func (c *CacheCtr[T]) buildTryLoadingQuery(ctx context.Context, key string, query Query[T]) (LoadingForQuery, error) {
-	loadQuery := func(ctx context.Context, key string, ttl time.Duration) (any, error) {
-		// 调用query方法
-		value, err := query(ctx)
-		if err != nil {
-			return nil, err
-		}
-		// 装箱
-		_ = c.SetStore(ctx, key, value, ttl)
-
-		if isNil(value) {
-			return nil, ErrNil
-		}
-		return value, nil
-	}
-
-	for _, plugin := range c.plugins {
-		plugQuery, ok, err := plugin.InterceptCallQuery(ctx, key, loadQuery)
-		if err != nil {
-			return nil, err
-		}
-		loadQuery = plugQuery
-		if !ok {
-			break
-		}
-	}
-	return loadQuery, nil
-}
-
-// NewCacheController 创建一个缓存控制器, 默认使用简单策略模式，设置 15 秒的缓存过期时间
-func NewCacheController[T any](name string, store Store, optionChain ...Option[T]) *CacheCtr[T] {
-	ctr := &CacheCtr[T]{
-		Name:    name,
-		plugins: []Plugin{},
-		//nolint:mnd
-		warp:  EasyPloy(15 * time.Second),
-		store: store,
-	}
-	for _, opt := range optionChain {
-		opt(ctr)
-	}
-	return ctr
-}
-
-var (
-	ctrStore = sync.Map{}
-)
-
-// Deprecated: use WrapWithTTL
-// Wrap 控制器封装方法，创建默认的控制器, 注意 name 只能够对应一个缓存 T 如果，冲突创建，会引发错误
-// 该方法默认使用 PolicyWarp 策略,应该使用 NewCacheController 来创建自定义的缓存控制器
-// 使用缓存策略 EasyPloy(15 * time.Second)
-func Wrap[T any](ctx context.Context, name string, store Store, key string, query Query[T]) (T, error) {
-	ctrIntr, ok := ctrStore.Load(name)
-	if ok {
-		if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
-			return ctr.Wrap(ctx, key, query)
-		}
-	}
-
-	// 创建并且使用 ctr
-	ctrIntr, _ = ctrStore.LoadOrStore(name, NewCacheController[T](name, store))
-	if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
-		return ctr.Wrap(ctx, key, query)
-	}
-	return *new(T), fmt.Errorf("unable to create a new cache controller, named to be used; name:%s, loadedType:%T", name, ctrIntr)
-}
-
-// // Deprecated: use WrapForReuseIgnoreErrorWithTTL
-// WrapForReuseIgnoreError 重用缓存封装模型, 注意 name 只能够对应一个缓存 T 如果，冲突创建，会引发错误
-// 使用缓存策略 ReuseCachePloy(30 * time.Second)
-// # 注意如果命中缓存，那么当 query 执行失败时，这个策略会重复使用缓存数据，直到 query 执行成功为止。
-func WrapForReuseIgnoreError[T any](ctx context.Context, name string, store Store, key string, query Query[T]) (T, error) {
-	const (
-		defaultTTL = 30 * time.Second
-	)
-
-	ctrIntr, ok := ctrStore.Load(name)
-	if ok {
-		if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
-			return ctr.Wrap(ctx, key, query)
-		}
-	}
-
-	// 创建并且使用 ctr
-	ctrIntr, _ = ctrStore.LoadOrStore(name, NewCacheController(name, store,
-		WithPolicy[T](ReuseCachePloyIgnoreError(defaultTTL)),
-	))
-	if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
-		return ctr.Wrap(ctx, key, query)
-	}
-	return *new(T), fmt.Errorf("unable to create a new cache controller, named to be used; name:%s, loadedType:%T", name, ctrIntr)
-}
-
-// Deprecated: use WrapForReuseIgnoreErrorWithTTL
-// WrapForFirst 优先缓存封装模型, 注意 name 只能够对应一个缓存 T 如果，冲突创建，会引发错误
-// 使用缓存策略 FirstCachePoly(1 * time.Minute)
-// # 注意如果命中缓存，那么当 query 执行失败时，这个策略会重复使用缓存数据，直到 query 执行成功为止。
-func WrapForFirstIgnoreError[T any](ctx context.Context, name string, store Store, key string, query Query[T]) (T, error) {
-	const (
-		defaultTTL = 1 * time.Minute
-	)
-
-	ctrIntr, ok := ctrStore.Load(name)
-	if ok {
-		if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
-			return ctr.Wrap(ctx, key, query)
-		}
-	}
-
-	// 创建并且使用 ctr
-	ctrIntr, _ = ctrStore.LoadOrStore(name, NewCacheController(name, store,
-		WithPolicy[T](FirstCachePolyIgnoreError(defaultTTL)),
-	))
-	if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
-		return ctr.Wrap(ctx, key, query)
-	}
-	return *new(T), fmt.Errorf("unable to create a new cache controller, named to be used; name:%s, loadedType:%T", name, ctrIntr)
-}
-
-// WrapForFirstIgnoreErrorWithTTL
-// # 注意如果命中缓存，那么当 query 执行失败时，这个策略会重复使用缓存数据，直到 query 执行成功为止。
-func WrapForFirstIgnoreErrorWithTTL[T any](ctx context.Context, store Store, key string, ttl time.Duration, query Query[T]) (T, error) {
-	name := fmt.Sprintf("library-modecache-first-default-%T", new(T))
-
-	ctrIntr, ok := ctrStore.Load(name)
-	if ok {
-		if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
-			return ctr.Wrap(ctx, key, query)
-		}
-	}
-	// 创建并且使用 ctr
-	ctrIntr, _ = ctrStore.LoadOrStore(name, NewCacheController(name, store,
-		WithPolicy[T](FirstCachePolyIgnoreError(ttl)),
-	))
-	if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
-		return ctr.Wrap(ctx, key, query)
-	}
-	return *new(T), fmt.Errorf("unable to create a new cache controller, named to be used; name:%s, loadedType:%T", name, ctrIntr)
-}
-
-// WrapForReuseIgnoreErrorWithTTL
-// # 注意如果命中缓存，那么当 query 执行失败时，这个策略会重复使用缓存数据，直到 query 执行成功为止。
-func WrapForReuseIgnoreErrorWithTTL[T any](ctx context.Context, store Store, key string, ttl time.Duration, query Query[T]) (T, error) {
-	name := fmt.Sprintf("library-modecache-reuse-default-%T", new(T))
-
-	ctrIntr, ok := ctrStore.Load(name)
-	if ok {
-		if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
-			return ctr.Wrap(ctx, key, query)
-		}
-	}
-	// 创建并且使用 ctr
-	ctrIntr, _ = ctrStore.LoadOrStore(name, NewCacheController(name, store,
-		WithPolicy[T](ReuseCachePloyIgnoreError(ttl)),
-	))
-	if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
-		return ctr.Wrap(ctx, key, query)
-	}
-	return *new(T), fmt.Errorf("unable to create a new cache controller, named to be used; name:%s, loadedType:%T", name, ctrIntr)
-}
-
-// WrapWithTTL 简单的缓存策略，当 query 执行失败时，直接返回错误。
-func WrapWithTTL[T any](ctx context.Context, store Store, key string, ttl time.Duration, query Query[T]) (T, error) {
-	name := fmt.Sprintf("library-modecache-easy-default-%T", new(T))
-
-	ctrIntr, ok := ctrStore.Load(name)
-	if ok {
-		if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
-			return ctr.Wrap(ctx, key, query)
-		}
-	}
-	// 创建并且使用 ctr
-	ctrIntr, _ = ctrStore.LoadOrStore(name, NewCacheController(name, store,
-		WithPolicy[T](EasyPloy(ttl)),
-	))
-	if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
-		return ctr.Wrap(ctx, key, query)
-	}
-	return *new(T), fmt.Errorf("unable to create a new cache controller, named to be used; name:%s, loadedType:%T", name, ctrIntr)
-}
-
-// SetStore 设置缓存
-func SetStore[T any](ctx context.Context, store Store, key string, value T, ttl time.Duration) error {
-	ctr := CacheCtr[T]{
-		store: store,
-	}
-	return ctr.SetStore(ctx, key, value, ttl)
-}
-
-// GetStore 获取缓存
-func GetStore[T any](ctx context.Context, store Store, key string) (T, int, error) {
-	ctr := CacheCtr[T]{
-		store: store,
-	}
-	return ctr.GetStore(ctx, key)
-}
-
-// DeleteStore 删除缓存
-func DeleteStore(ctx context.Context, store Store, key string) error {
-	return store.Del(ctx, key)
-}
+package modecache
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	SHadowKeyPrefix = "shadow:"
+	HashedKeyPrefix = "hashed:" // WithMaxKeyLength 哈希后的 key 前缀
+
+	DefaultDumpLimit = 1000 // DumpStore 默认最多返回的缓存数量
+
+	KeepTTL = -1 // 永久存储
+)
+
+var (
+	ErrKeyNonExistent   = errors.New("modecache: key does not exist")    // ErrKeyNonExistent 缓存键不存在。
+	ErrUnpackingFailed  = errors.New("modecache: warp unpacking failed") // warp 拆箱失败。
+	ErrNil              = errors.New("null pointer")                     // Nil 空指针。
+	ErrInvalidTTL       = errors.New("modecache: invalid ttl")           // ErrInvalidTTL 非法的过期时间。
+	ErrStoreUnavailable = errors.New("modecache: store unavailable")     // ErrStoreUnavailable 存储不可用, 包装存储层的原始错误。
+	ErrUnsupported      = errors.New("modecache: unsupported by store")  // ErrUnsupported 存储不支持该操作。
+	ErrQueryInFlight    = errors.New("modecache: query in flight")       // ErrQueryInFlight 不等待时, 相同 key 的 query 正在执行。
+	ErrUnknownPolicy    = errors.New("modecache: unknown policy")        // ErrUnknownPolicy 未知的策略名称。
+	ErrInvalidValue     = errors.New("modecache: invalid cached value")  // ErrInvalidValue 缓存数据未通过校验。
+	ErrRateLimited      = errors.New("modecache: rate limited")          // ErrRateLimited query 限流等待时间超过上限。
+	ErrNoDefaultStore   = errors.New("modecache: default store not set") // ErrNoDefaultStore 未设置默认存储。
+	ErrQueryTimeout     = errors.New("modecache: query timeout")         // ErrQueryTimeout query 执行超过 WithQueryTimeout 设置的时间。
+	ErrInvalidKey       = errors.New("modecache: invalid key")           // ErrInvalidKey 缓存键未通过 WithKeyValidator 校验。
+)
+
+// errIncompatibleValue 缓存中的数据不兼容 (旧版本写入或者类型不匹配), 包装在 ErrKeyNonExistent 中视为未命中
+var errIncompatibleValue = errors.New("incompatible value")
+
+// errTypeMismatch 缓存数据的类型和控制器不匹配 (严格类型检查或者存储返回的类型错误), 包装在 ErrUnpackingFailed 中
+// 这类错误来自配置或者代码, 数据本身没有损坏, 不会被损坏数据修复删除
+var errTypeMismatch = errors.New("type mismatch")
+
+// maxPoisonedKeys 记录损坏数据 key 的最大数量, 超过后清空记录
+const maxPoisonedKeys = 10000
+
+type (
+	Store interface {
+		// Get 获取缓存。当缓存键不存在时返回 ErrKeyNonExistent 错误。
+		Get(ctx context.Context, key string) (any, error)
+		// Set 设置缓存, ttl 使用 KeepTTL 表示用不过期
+		Set(ctx context.Context, key string, data any, ttl time.Duration) error
+		// Del 删除缓存。
+		Del(ctx context.Context, key string) error
+
+		// IsDirectStore 释放可以直接存储数据，而不需要编码后存储
+		// 当 IsDirectStore 为 True 时，存储管理器会少一次编码和解码的操作，以提高缓存读取的性能（本地缓存可用）
+		// 控制器只使用最外层 Store 的返回值: 只转发数据的装饰器 (例如 TaggedStore) 返回内部存储的值,
+		// 需要处理编码后数据的装饰器 (例如压缩, 加密) 必须返回 false, 此时 Set 收到和 Get 需要返回的都是编码后的 string
+		IsDirectStore() bool
+	}
+
+	// Clearable 可清空的存储, Store 的可选接口
+	Clearable interface {
+		// Clear 清空存储中的全部缓存
+		Clear(ctx context.Context) error
+	}
+
+	// Dumpable 可列出全部缓存的存储, Store 的可选接口, 用于排查问题
+	Dumpable interface {
+		// Keys 列出存储中的缓存键, 最多返回 limit 个
+		Keys(ctx context.Context, limit int) ([]string, error)
+		// Dump 列出存储中的缓存数据, 最多返回 limit 个, 数据为 Store 中保存的原始数据
+		Dump(ctx context.Context, limit int) (map[string]any, error)
+	}
+
+	// PatternDeleter 可按前缀批量删除缓存的存储, Store 的可选接口
+	PatternDeleter interface {
+		// DelByPrefix 删除 key 以 prefix 开头的全部缓存, 返回删除的数量
+		// ctx 取消时中止删除, 返回已经删除的数量和 ctx 的错误
+		DelByPrefix(ctx context.Context, prefix string) (int, error)
+	}
+
+	// BatchDeleter 可一次删除多个缓存的存储, Store 的可选接口
+	BatchDeleter interface {
+		// DelMulti 删除 keys 中的全部缓存, 不存在的 key 忽略
+		DelMulti(ctx context.Context, keys []string) error
+	}
+
+	// Transactional 支持事务写入的存储, Store 的可选接口
+	Transactional interface {
+		// DelAndSet 在一个事务中删除 del 中的缓存并写入 key, 其他请求不会读取到删除和写入之间的状态
+		DelAndSet(ctx context.Context, del []string, key string, data any, ttl time.Duration) error
+	}
+
+	// Exister 可判断缓存是否存在的存储, Store 的可选接口
+	Exister interface {
+		// Exists 判断缓存是否存在, 不读取数据
+		Exists(ctx context.Context, key string) (bool, error)
+	}
+
+	// TTLReader 可读取剩余过期时间的存储, Store 的可选接口
+	TTLReader interface {
+		// TTL 获取缓存的剩余过期时间, 永不过期时返回 KeepTTL
+		// 缓存键不存在时返回 ErrKeyNonExistent 错误
+		TTL(ctx context.Context, key string) (time.Duration, error)
+	}
+
+	// TTLExtender 可延长过期时间的存储, Store 的可选接口
+	TTLExtender interface {
+		// Touch 延长缓存的过期时间, 不重新读取和写入数据, ttl 使用 KeepTTL 表示永不过期
+		// 缓存键不存在时返回 ErrKeyNonExistent 错误
+		Touch(ctx context.Context, key string, ttl time.Duration) error
+	}
+
+	// ConditionalStore 支持条件写入的存储, Store 的可选接口
+	// 控制器使用 query 结果回填缓存时优先使用条件写入, 避免慢查询覆盖其他请求写入的更新的数据
+	ConditionalStore interface {
+		// SetIfNewer 只有缓存中的数据不比 timestampMs (毫秒) 更新时才写入, data 为 Set 使用的数据 (装箱或者编码后的数据)
+		// 缓存中没有毫秒时间戳的旧数据使用秒级时间戳比较
+		// return: 是否写入, 错误
+		SetIfNewer(ctx context.Context, key string, data any, timestampMs int64, ttl time.Duration) (bool, error)
+	}
+
+	// Query 查询方法类型。
+	Query[T any] func(context.Context) (T, error)
+
+	// CacheableQuery 可控制缓存的查询方法类型, 返回的 bool 表示查询结果是否允许写入缓存。
+	CacheableQuery[T any] func(context.Context) (T, bool, error)
+
+	// PrevQuery 可以获取缓存中旧数据的查询方法类型, hasPrev 表示缓存中是否有可用的旧数据。
+	PrevQuery[T any] func(ctx context.Context, prev T, hasPrev bool) (T, error)
+
+	// AbcBox 抽象箱
+	AbcBox[T any] struct {
+		Timestamp   int    `json:"Timestamp"`
+		TimestampMs int64  `json:"TimestampMs,omitempty"` // 数据创建时间(毫秒), 旧版本写入的数据为 0, 只有秒级的 Timestamp
+		ComputeMs   int64  `json:"ComputeMs,omitempty"`   // query 执行耗时(毫秒)
+		Err         string `json:"Err,omitempty"`         // 缓存的 query 错误信息, 不为空时 T 无效, 见 WithErrorCache
+		T           T      `json:"T"`
+	}
+
+	// LoadingForCache 封装查询方法，return：数据, 数据创建时间，错误
+	LoadingForCache func(ctx context.Context, key string) (any, int, error)
+
+	// LoadingForQuery 数据库封装方法
+	LoadingForQuery func(ctx context.Context, key string, ttl time.Duration) (any, error)
+
+	// Policy 缓存控制策略, 用来控制缓存策略
+	Policy func(ctx context.Context, key string, queryFormDB LoadingForQuery, queryFormCache LoadingForCache) (any, error)
+
+	// 访问控制插件
+	Plugin interface {
+		// InterceptCallQuery 查询 query 前拦截调用
+		// return: LoadingForQuery: 不为空的场景,替换执行的 LoadingForQuery
+		// return: bool：是否允许继续执行插件，还是提前熔断
+		// return: error: 错误, 会导流程结束返回 error
+		InterceptCallQuery(ctx context.Context, key string, loadQuery LoadingForQuery) (LoadingForQuery, bool, error)
+
+		// InterceptCallCache 查询 cache 前拦截调用
+		// return: LoadingForCache: 不为空的场景,替换执行的 LoadingForCache
+		// return: bool：是否允许继续执行插件，还是提前熔断
+		// return: error: 错误, 会导流程结束返回 error
+		InterceptCallCache(ctx context.Context, key string, loadCache LoadingForCache) (LoadingForCache, bool, error)
+	}
+
+	// SharedObserver 观察 singleflight 合并请求的插件, Plugin 的可选接口
+	SharedObserver interface {
+		// OnShared 请求通过 singleflight 复用了其他请求的 query 结果, 未实际执行 query 时调用
+		OnShared(ctx context.Context, key string)
+	}
+
+	// StaleObserver 观察使用过期数据的插件, Plugin 的可选接口
+	StaleObserver interface {
+		// OnStaleServe 策略返回了超过业务过期时间的缓存时调用 (query 失败, 超时或者后台刷新中)
+		OnStaleServe(ctx context.Context, key string)
+	}
+
+	// ResultObserver 观察最终结果的插件, Plugin 的可选接口
+	ResultObserver interface {
+		// ObserveResult 策略执行结束后调用, value 和 err 为 Wrap 最终返回给调用方的结果 (包含 WithFallback 的默认值)
+		ObserveResult(ctx context.Context, key string, value any, err error)
+	}
+)
+
+// CtxStorageKey 上下文存储键,用来存储可变的 storage 实现替换全局 storage
+type CtxStorageKey struct{}
+
+// boxTimestamp 获取装箱数据的毫秒精度创建时间, 用于直接存储在不知道 T 的情况下读取时间戳
+type boxTimestamp interface {
+	boxTimestampMs() int64
+}
+
+// boxTimestampMs 没有毫秒时间戳的旧数据使用秒级时间戳
+func (b *AbcBox[T]) boxTimestampMs() int64 {
+	if b.TimestampMs > 0 {
+		return b.TimestampMs
+	}
+	return int64(b.Timestamp) * 1000
+}
+
+// BoxMeta 缓存数据的元信息
+type BoxMeta struct {
+	Timestamp   int   // 数据创建时间
+	TimestampMs int64 // 数据创建时间(毫秒), 旧版本写入的数据为 0
+	ComputeMs   int64 // query 执行耗时(毫秒)
+}
+
+// createdMs 获取毫秒精度的数据创建时间, 没有毫秒时间戳的旧数据使用秒级时间戳 timestamp
+func (m *BoxMeta) createdMs(timestamp int) int64 {
+	if m.TimestampMs > 0 {
+		return m.TimestampMs
+	}
+	return int64(timestamp) * 1000
+}
+
+type ctxBoxMetaKey struct{}
+
+// WithBoxMeta 在上下文中挂载 BoxMeta, 控制器读取缓存成功后会把缓存的元信息写入 meta
+// 用于策略在 LoadingForCache 之外获取缓存的元信息
+func WithBoxMeta(ctx context.Context, meta *BoxMeta) context.Context {
+	return context.WithValue(ctx, ctxBoxMetaKey{}, meta)
+}
+
+type ctxControllerNameKey struct{}
+
+// WithControllerName 在上下文中设置控制器名称, 控制器的 Wrap 开始时会设置, 插件, query 和日志可以通过
+// ControllerNameFromContext 获取, 用于关联日志和链路追踪
+func WithControllerName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, ctxControllerNameKey{}, name)
+}
+
+// ControllerNameFromContext 获取上下文中的控制器名称, 不在控制器的 Wrap 中调用时返回 false
+func ControllerNameFromContext(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(ctxControllerNameKey{}).(string)
+	return name, ok
+}
+
+type ctxTTLOverrideKey struct{}
+
+// WithTTLOverride 在上下文中设置本次请求写入缓存使用的 ttl, 优先于策略的 ttl, 用于 A/B 实验等场景
+// # 注意策略使用 KeepTTL 写入缓存时 (ReuseCachePloyIgnoreError, FirstCachePolyIgnoreError) 忽略该设置,
+// 这些策略依赖永久存储和业务过期时间控制缓存
+func WithTTLOverride(ctx context.Context, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, ctxTTLOverrideKey{}, ttl)
+}
+
+type ctxQueryTimeoutKey struct{}
+
+// WithQueryTimeout 设置本次调用 query 的最长执行时间, query 的上下文在 d 后取消,
+// 策略的 singleflight 最多等待 d, 超时后 Forget 该 key 并返回 ErrQueryTimeout 错误 (同时包装 context.DeadlineExceeded),
+// 避免卡住的下游阻塞之后相同 key 的全部调用; 超时的 query 结束后依然会写入缓存, d <= 0 时不限制
+func WithQueryTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, ctxQueryTimeoutKey{}, d)
+}
+
+// StoreResolver 存储选择器, 根据上下文选择本次请求使用的 Store (例如按租户分片路由), 返回 nil 表示不做选择
+type StoreResolver func(ctx context.Context) Store
+
+type CacheCtr[T any] struct {
+	Name        string        // 缓存控制名称
+	plugins     []Plugin      // 缓存控制器插件
+	warp        Policy        // 缓存控制策略
+	store       Store         // 缓存层
+	resolver    StoreResolver // 存储选择器
+	onStore     func(T) T     // 写入缓存前的转换
+	onLoad      func(T) T     // 读取缓存后的转换
+	newValue    func() T      // 非直接存储解码时的初始值, 用于 T 为接口类型时指定具体类型
+	strict      bool          // 直接存储类型断言失败时返回 ErrUnpackingFailed, 默认视为未命中
+	fallback    func() T      // 策略返回错误时使用的默认值
+	validate    func(T) error // 读取缓存后的数据校验
+	backstopTTL time.Duration // KeepTTL 写入时使用的兜底过期时间, <= 0 时永久存储
+	keepPoison  bool          // 关闭损坏数据修复, 拆箱失败时返回 ErrUnpackingFailed
+	readRepair  bool          // 读取到不兼容或者损坏的数据时删除
+	poisoned    sync.Map      // 已经记录过的损坏数据的 key, 重新写入后删除
+	poisonedN   atomic.Int64  // poisoned 中记录的 key 数量
+
+	maxKeyLen int                 // 存储 key 的最大长度, <= 0 时不限制
+	hashKey   func(string) string // 超过最大长度的 key 使用的哈希函数
+	indexer   IndexExtractor[T]   // 二级索引提取方法, 为空时不维护二级索引
+	validKey  func(string) error  // 缓存键校验, 为空时不校验
+
+	errCacheTTL  time.Duration    // query 错误的缓存时间, <= 0 时不缓存错误
+	errCacheable func(error) bool // 判断 query 错误是否可以缓存
+
+	onHit    func(ctx context.Context, key string)            // 命中缓存回调
+	onMiss   func(ctx context.Context, key string)            // 未命中缓存回调
+	onError  func(ctx context.Context, key string, err error) // 错误回调
+	onShared func(ctx context.Context, key string)            // singleflight 合并请求回调
+	onStale  func(ctx context.Context, key string)            // 返回过期数据回调
+
+	stats     ctrStats           // 控制器统计
+	chanGroup singleflight.Group // WrapChan 合并请求
+}
+
+// WrapResult WrapChan 的结果
+type WrapResult[T any] struct {
+	Value  T     // 数据
+	Err    error // 错误
+	Shared bool  // 是否和其他 WrapChan 调用共享结果
+}
+
+// QueryError query 执行失败的错误, 可以使用 errors.As 和存储的错误区分
+type QueryError struct {
+	Key string // 缓存键
+	Err error  // query 返回的原始错误
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("modecache: query %s: %v", e.Key, e.Err)
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// StoreError 访问存储失败的错误 (不包含缓存不存在), 可以使用 errors.As 和 query 的错误区分
+type StoreError struct {
+	Op  string // 操作, get/set
+	Key string // 缓存键
+	Err error  // 存储返回的原始错误
+}
+
+func (e *StoreError) Error() string {
+	return fmt.Sprintf("modecache: store %s %s: %v", e.Op, e.Key, e.Err)
+}
+
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+// CachedError 从缓存中读取的 query 错误, 见 WithErrorCache
+// 错误以字符串缓存, 只保留原始错误的信息, 无法使用 errors.Is 匹配原始错误
+type CachedError struct {
+	Msg string // 原始错误的信息
+}
+
+func (e *CachedError) Error() string {
+	return e.Msg
+}
+
+// Stats 控制器统计快照
+type Stats struct {
+	Hits        uint64 // 命中缓存次数
+	Misses      uint64 // 未命中缓存次数 (包含读取缓存失败)
+	QueryCalls  uint64 // query 执行次数
+	QueryErrors uint64 // query 执行失败次数
+	SharedCalls uint64 // 通过 singleflight 合并, 未实际执行 query 的次数
+	StaleServed uint64 // 策略返回超过业务过期时间的缓存的次数
+}
+
+// ctrStats 控制器统计计数器
+type ctrStats struct {
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	queryCalls  atomic.Uint64
+	queryErrors atomic.Uint64
+	sharedCalls atomic.Uint64
+	staleServed atomic.Uint64
+}
+
+// sharedRecorder 记录 singleflight 合并的请求, 控制器通过上下文传递给 SingleflightGroup
+type sharedRecorder interface {
+	recordShared(ctx context.Context, key string)
+}
+
+type ctxSharedKey struct{}
+
+// staleRecorder 记录策略返回的过期数据, 控制器通过上下文传递给策略
+type staleRecorder interface {
+	recordStaleServed(ctx context.Context, key string)
+}
+
+type ctxStaleServedKey struct{}
+
+// Stats 获取控制器统计快照
+func (c *CacheCtr[T]) Stats() Stats {
+	return Stats{
+		Hits:        c.stats.hits.Load(),
+		Misses:      c.stats.misses.Load(),
+		QueryCalls:  c.stats.queryCalls.Load(),
+		QueryErrors: c.stats.queryErrors.Load(),
+		SharedCalls: c.stats.sharedCalls.Load(),
+		StaleServed: c.stats.staleServed.Load(),
+	}
+}
+
+// Plugins 获取控制器注册的插件, 返回副本, 可以通过类型断言获取具体的插件实例
+func (c *CacheCtr[T]) Plugins() []Plugin {
+	return slices.Clone(c.plugins)
+}
+
+// getStore 选择本次请求使用的 Store
+// 优先级: 存储选择器 > 上下文中的 Store > 控制器默认 Store
+func (c *CacheCtr[T]) getStore(ctx context.Context) Store {
+	if c.resolver != nil {
+		if store := c.resolver(ctx); store != nil {
+			return store
+		}
+	}
+	if ctxStore, ok := ctx.Value(CtxStorageKey{}).(Store); ok {
+		return ctxStore
+	}
+	return c.store
+}
+
+// SetStore 设置缓存到 Store
+func (c *CacheCtr[T]) SetStore(ctx context.Context, key string, value T, ttl time.Duration) error {
+	if err := c.validateKey(key); err != nil {
+		return err
+	}
+	// 装箱
+	createdAt := now()
+	box := &AbcBox[T]{
+		T:           value,
+		Timestamp:   int(createdAt.Unix()),
+		TimestampMs: createdAt.UnixMilli(),
+	}
+	return c.setBox(ctx, key, box, ttl, false)
+}
+
+// validateKey 使用 WithKeyValidator 设置的方法校验缓存键, 校验失败返回 ErrInvalidKey 错误
+func (c *CacheCtr[T]) validateKey(key string, keys ...string) error {
+	if c.validKey == nil {
+		return nil
+	}
+	for _, k := range append([]string{key}, keys...) {
+		if err := c.validKey(k); err != nil {
+			if errors.Is(err, ErrInvalidKey) {
+				return err
+			}
+			return fmt.Errorf("%w: %q, %w", ErrInvalidKey, k, err)
+		}
+	}
+	return nil
+}
+
+// storeKey 获取访问存储使用的 key, 超过最大长度的 key 替换为 HashedKeyPrefix + 哈希值
+func (c *CacheCtr[T]) storeKey(key string) string {
+	if c.maxKeyLen <= 0 || len(key) <= c.maxKeyLen {
+		return key
+	}
+	return HashedKeyPrefix + c.hashKey(key)
+}
+
+// storeTTL 获取写入存储使用的过期时间, 永久存储使用兜底过期时间, 增加最多 10% 的随机时间, 避免同时写入的缓存同时过期
+func (c *CacheCtr[T]) storeTTL(ttl time.Duration) time.Duration {
+	if ttl == KeepTTL && c.backstopTTL > 0 {
+		return c.backstopTTL + rand.N(c.backstopTTL/10+1)
+	}
+	return ttl
+}
+
+// setBox 设置装箱后的缓存到 Store
+// conditional 为 true 并且 Store 实现了 ConditionalStore 时, 只有缓存中没有更新的数据才会写入
+func (c *CacheCtr[T]) setBox(ctx context.Context, key string, box *AbcBox[T], ttl time.Duration, conditional bool) error {
+	ttl = c.storeTTL(ttl)
+	store := c.getStore(ctx)
+	data, err := c.encodeBox(store.IsDirectStore(), box)
+	if err != nil {
+		return err
+	}
+	storeKey := c.storeKey(key)
+	if conditional {
+		if cStore, ok := store.(ConditionalStore); ok {
+			_, err = cStore.SetIfNewer(ctx, storeKey, data, box.boxTimestampMs(), ttl)
+		} else {
+			err = store.Set(ctx, storeKey, data, ttl)
+		}
+	} else {
+		err = store.Set(ctx, storeKey, data, ttl)
+	}
+	if err != nil {
+		return &StoreError{Op: "set", Key: key, Err: err}
+	}
+	if c.indexer != nil && box.Err == "" {
+		return c.setIndex(ctx, key, box.T, ttl)
+	}
+	return nil
+}
+
+// encodeBox 编码装箱后的缓存, direct 为 Store 的 IsDirectStore
+func (c *CacheCtr[T]) encodeBox(direct bool, box *AbcBox[T]) (any, error) {
+	if c.onStore != nil && box.Err == "" {
+		box.T = c.onStore(box.T)
+	}
+
+	// 设置缓存, 根据 OriginalStore 检查
+	if direct {
+		return box, nil
+	}
+
+	// []byte/string 使用原始编码, proto.Message 使用 proto 编码, 缓存的错误只能使用 JSON 编码
+	if box.Err == "" {
+		if raw, ok := encodeRawBox(box); ok {
+			return raw, nil
+		}
+		if raw, ok, err := encodeProtoBox(box); ok {
+			return raw, err
+		}
+	}
+
+	// 编码处理
+	return marshalJSON(box)
+}
+
+// GetStore 从 Store 中获取缓存
+func (c *CacheCtr[T]) GetStore(ctx context.Context, key string) (T, int, error) {
+	box, err := c.GetBox(ctx, key)
+	if err != nil {
+		return *new(T), 0, err
+	}
+	if box.Err != "" {
+		return *new(T), box.Timestamp, &CachedError{Msg: box.Err}
+	}
+	return box.T, box.Timestamp, nil
+}
+
+// GetBox 从 Store 中获取装箱的缓存, 包含数据和全部元信息, 缓存不存在时返回 ErrKeyNonExistent 错误
+// 缓存的 query 错误 (WithErrorCache) 不返回错误, 通过 AbcBox.Err 判断; 返回的是副本, 修改不会影响直接存储中的数据
+func (c *CacheCtr[T]) GetBox(ctx context.Context, key string) (*AbcBox[T], error) {
+	if err := c.validateKey(key); err != nil {
+		return nil, err
+	}
+	box, err := c.getBox(ctx, key)
+	if err != nil {
+		if c.readRepair {
+			err = c.readRepairBox(ctx, key, err)
+		}
+		return nil, err
+	}
+	nBox := *box
+	return &nBox, nil
+}
+
+// getBox 从 Store 中获取装箱的缓存
+func (c *CacheCtr[T]) getBox(ctx context.Context, key string) (*AbcBox[T], error) {
+	store := c.getStore(ctx)
+
+	value, err := store.Get(ctx, c.storeKey(key))
+	if err != nil {
+		if !errors.Is(err, ErrKeyNonExistent) {
+			err = &StoreError{Op: "get", Key: key, Err: err}
+		}
+		return nil, err
+	}
+	var box = new(AbcBox[T])
+	if store.IsDirectStore() {
+		cBox, ok := value.(*AbcBox[T])
+		if !ok {
+			if c.strict {
+				return nil, fmt.Errorf("%w: %w: assert type %T to abcBox fail", ErrUnpackingFailed, errTypeMismatch, value)
+			}
+			// 其他类型写入的数据 (例如发布期间新旧版本共用 key) 视为未命中, 由 query 重新写入
+			return nil, fmt.Errorf("%w: %w: stored type %T is not %T", ErrKeyNonExistent, errIncompatibleValue, value, cBox)
+		}
+		box = cBox
+	} else {
+		strVal, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: %w: directStore need string but got %T", ErrUnpackingFailed, errTypeMismatch, value)
+		}
+		switch {
+		case isRawBox(strVal):
+			if err = decodeRawBox(strVal, box); err != nil {
+				return nil, err
+			}
+		case isProtoBox(strVal):
+			if c.newValue != nil {
+				box.T = c.newValue()
+			}
+			if err = decodeProtoBox(strVal, box); err != nil {
+				return nil, err
+			}
+		case isLegacyValue(strVal):
+			// 未装箱的旧数据视为未命中, 由 query 重新写入装箱后的数据
+			return nil, fmt.Errorf("%w: %w: legacy unboxed value", ErrKeyNonExistent, errIncompatibleValue)
+		default:
+			if c.newValue != nil {
+				box.T = c.newValue()
+			}
+			if err = unmarshalJSON(strVal, box); err != nil {
+				if c.newValue == nil && isInterfaceType[T]() {
+					return nil, fmt.Errorf("%w: %w: %s is an interface, register the concrete type with WithValueFactory, %w",
+						ErrUnpackingFailed, errTypeMismatch, reflect.TypeFor[T](), err)
+				}
+				return nil, fmt.Errorf("%w: directStore unmarshal to abcBox fail, %w", ErrUnpackingFailed, err)
+			}
+			if box.Timestamp == 0 {
+				return nil, fmt.Errorf("%w: %w: legacy unboxed value", ErrKeyNonExistent, errIncompatibleValue)
+			}
+		}
+	}
+	if c.onLoad != nil && box.Err == "" {
+		// 复制一份, 避免修改直接存储中的数据
+		nBox := *box
+		nBox.T = c.onLoad(box.T)
+		box = &nBox
+	}
+	return box, nil
+}
+
+// InvalidateWithDelay 延迟双删, 立即删除缓存, 并在 delay 后再次删除缓存
+// 第二次删除用来清理并发读取期间回填的旧数据, 使用脱离取消的上下文执行, 请求上下文取消不会跳过第二次删除
+func (c *CacheCtr[T]) InvalidateWithDelay(ctx context.Context, key string, delay time.Duration) error {
+	if err := c.validateKey(key); err != nil {
+		return err
+	}
+	store, key := c.getStore(ctx), c.storeKey(key)
+	if err := store.Del(ctx, key); err != nil {
+		return err
+	}
+
+	nCtx := context.WithoutCancel(ctx)
+	GO(func() {
+		time.Sleep(delay)
+		_ = store.Del(nCtx, key)
+	})
+	return nil
+}
+
+// WriteThrough 写穿透, 先调用 persist 写入数据源, 成功后使用 ttl 写入缓存
+// persist 失败时不修改缓存, 直接返回错误; 写入缓存使用 DelAndSet, invalidate 为需要同时删除的关联缓存
+// key 校验失败时返回 ErrInvalidKey, 不调用 persist, 避免数据源写入后缓存无法更新
+func (c *CacheCtr[T]) WriteThrough(ctx context.Context, key string, value T, ttl time.Duration, persist func(ctx context.Context, value T) error, invalidate ...string) error {
+	if err := c.validateKey(key, invalidate...); err != nil {
+		return err
+	}
+	if err := persist(ctx, value); err != nil {
+		return err
+	}
+	return c.DelAndSet(ctx, key, value, ttl, invalidate...)
+}
+
+// DelAndSet 删除 invalidate 中的关联缓存并写入 key, 存储实现 Transactional 时在一个事务中执行,
+// 其他请求不会读取到删除和写入之间的状态; 否则依次删除和写入, 不保证原子性
+func (c *CacheCtr[T]) DelAndSet(ctx context.Context, key string, value T, ttl time.Duration, invalidate ...string) error {
+	if err := c.validateKey(key, invalidate...); err != nil {
+		return err
+	}
+	createdAt := now()
+	box := &AbcBox[T]{
+		T:           value,
+		Timestamp:   int(createdAt.Unix()),
+		TimestampMs: createdAt.UnixMilli(),
+	}
+	store := c.getStore(ctx)
+	if tx, ok := store.(Transactional); ok {
+		data, err := c.encodeBox(store.IsDirectStore(), box)
+		if err != nil {
+			return err
+		}
+		dels := make([]string, len(invalidate))
+		for i, del := range invalidate {
+			dels[i] = c.storeKey(del)
+		}
+		ttl = c.storeTTL(ttl)
+		if err = tx.DelAndSet(ctx, dels, c.storeKey(key), data, ttl); err != nil || c.indexer == nil {
+			return err
+		}
+		return c.setIndex(ctx, key, box.T, ttl)
+	}
+	for _, del := range invalidate {
+		if err := store.Del(ctx, c.storeKey(del)); err != nil {
+			return err
+		}
+	}
+	return c.setBox(ctx, key, box, ttl, false)
+}
+
+// Touch 延长缓存的过期时间, 存储未实现 TTLExtender 时返回 ErrUnsupported 错误
+func (c *CacheCtr[T]) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	if err := c.validateKey(key); err != nil {
+		return err
+	}
+	store := c.getStore(ctx)
+	extender, ok := store.(TTLExtender)
+	if !ok {
+		return fmt.Errorf("%w: %T does not implement TTLExtender", ErrUnsupported, store)
+	}
+	return extender.Touch(ctx, c.storeKey(key), ttl)
+}
+
+// Exists 判断缓存是否存在, 不解码数据, 适用于去重等只需要判断存在的场景
+// 存储实现 Exister 时使用 Exists, 否则读取数据但不解码; 只判断 key 是否存在, 不校验数据能否拆箱和业务过期时间
+func (c *CacheCtr[T]) Exists(ctx context.Context, key string) (bool, error) {
+	if err := c.validateKey(key); err != nil {
+		return false, err
+	}
+	store, key := c.getStore(ctx), c.storeKey(key)
+	if exister, ok := store.(Exister); ok {
+		return exister.Exists(ctx, key)
+	}
+	_, err := store.Get(ctx, key)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrKeyNonExistent):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// RemainingTTL 获取缓存的剩余过期时间, 永不过期时返回 KeepTTL, 存储未实现 TTLReader 时返回 ErrUnsupported 错误
+func (c *CacheCtr[T]) RemainingTTL(ctx context.Context, key string) (time.Duration, error) {
+	if err := c.validateKey(key); err != nil {
+		return 0, err
+	}
+	store := c.getStore(ctx)
+	reader, ok := store.(TTLReader)
+	if !ok {
+		return 0, fmt.Errorf("%w: %T does not implement TTLReader", ErrUnsupported, store)
+	}
+	return reader.TTL(ctx, c.storeKey(key))
+}
+
+// Wrap 控制器的包装方法，控制使用 warp 方案
+func (c *CacheCtr[T]) Wrap(ctx context.Context, key string, query Query[T]) (p T, err error) {
+	return c.WrapCacheable(ctx, key, func(ctx context.Context) (T, bool, error) {
+		value, err := query(ctx)
+		return value, true, err
+	})
+}
+
+// WrapWithPrev 控制器的包装方法, 策略决定执行 query 时先读取缓存中的旧数据传递给 query
+// 适用于可以根据旧数据增量刷新的场景, 例如使用旧数据的 ETag 发起条件请求; 旧数据可能已经过期, 缓存缺失或者读取失败时 hasPrev 为 false
+// 传递给 query 的 ctx 使用 WithBoxMeta 时可以获取旧数据的写入时间
+func (c *CacheCtr[T]) WrapWithPrev(ctx context.Context, key string, query PrevQuery[T]) (T, error) {
+	return c.Wrap(ctx, key, func(ctx context.Context) (T, error) {
+		box, err := c.getBox(ctx, key)
+		if err != nil || box.Err != "" || isNil(box.T) {
+			return query(ctx, *new(T), false)
+		}
+		if meta, ok := ctx.Value(ctxBoxMetaKey{}).(*BoxMeta); ok {
+			meta.Timestamp = box.Timestamp
+			meta.TimestampMs = box.TimestampMs
+			meta.ComputeMs = box.ComputeMs
+		}
+		return query(ctx, box.T, true)
+	})
+}
+
+// WrapChan 控制器的包装方法, 异步执行 Wrap 并通过 channel 返回结果, 便于和其他操作一起 select
+// 相同 key 并发的 WrapChan 调用通过 singleflight 合并, 共享第一个调用的 ctx 和 query 的结果
+// ctx 取消时立即返回 ctx 的错误, 已经开始的 Wrap 继续执行; channel 只会收到一个结果
+func (c *CacheCtr[T]) WrapChan(ctx context.Context, key string, query Query[T]) <-chan WrapResult[T] {
+	out := make(chan WrapResult[T], 1)
+	ch := c.chanGroup.DoChan(key, func() (any, error) {
+		return c.Wrap(ctx, key, query)
+	})
+	GO(func() {
+		select {
+		case res := <-ch:
+			value, _ := res.Val.(T)
+			out <- WrapResult[T]{Value: value, Err: res.Err, Shared: res.Shared}
+		case <-ctx.Done():
+			out <- WrapResult[T]{Err: ctx.Err()}
+		}
+	})
+	return out
+}
+
+// TryWrap 控制器的包装方法, 需要执行 query 但是相同 key 的 query 正在执行时不等待, 立即返回 ok=false
+// 适用于延迟敏感的场景, 命中缓存或者策略可以使用旧数据时依然返回数据;
+// 只对策略内 singleflight 合并的 query 生效, 其他错误返回 ok=true 和错误
+func (c *CacheCtr[T]) TryWrap(ctx context.Context, key string, query Query[T]) (T, bool, error) {
+	value, err := c.Wrap(context.WithValue(ctx, ctxNoWaitKey{}, true), key, query)
+	if errors.Is(err, ErrQueryInFlight) {
+		return value, false, nil
+	}
+	return value, true, err
+}
+
+// WrapOrError 控制器的包装方法, 和 Wrap 相同, 策略 query 失败但是返回了旧数据时, 通过 staleErr 返回 query 的错误
+// err 只在无法返回数据 (没有可用的缓存并且 query 失败) 时不为 nil, staleErr 用于在降级时记录告警
+// ReuseCachePloyIgnoreError, ProbabilisticPloy 会记录 staleErr; FirstCachePolyIgnoreError, AsyncRefreshPloy 在后台刷新,
+// 返回时刷新的结果未知, staleErr 总是为 nil, 后台刷新的错误通过 WithOnError 回调获取
+func (c *CacheCtr[T]) WrapOrError(ctx context.Context, key string, query Query[T]) (value T, staleErr error, err error) {
+	value, err = c.Wrap(context.WithValue(ctx, ctxStaleErrKey{}, &staleErr), key, query)
+	if err != nil {
+		return value, nil, err
+	}
+	return value, staleErr, nil
+}
+
+// WrapCacheable 控制器的包装方法, query 返回的 bool 决定本次查询结果是否写入缓存
+// 适用于查询结果有效但不应该缓存的场景, 例如从只读副本降级读取到的数据
+func (c *CacheCtr[T]) WrapCacheable(ctx context.Context, key string, query CacheableQuery[T]) (p T, err error) {
+	if err = c.validateKey(key); err != nil {
+		return p, err
+	}
+	ctx = WithControllerName(ctx, c.Name)
+	loadQuery, err := c.buildTryLoadingQuery(ctx, key, query)
+	if err != nil {
+		return p, err
+	}
+	loadCache, err := c.buildTryLoadingCache(ctx, key)
+	if err != nil {
+		return p, err
+	}
+	if c.errCacheTTL > 0 {
+		loadQuery, loadCache = shortCircuitCachedError(loadQuery, loadCache)
+	}
+
+	// 挂载控制器, 策略内 singleflight 合并请求和返回过期数据时记录
+	ctx = context.WithValue(ctx, ctxSharedKey{}, sharedRecorder(c))
+	ctx = context.WithValue(ctx, ctxStaleServedKey{}, staleRecorder(c))
+	result, err := c.warp(ctx, key, loadQuery, loadCache)
+	p, err = c.resolveResult(result, err)
+	c.observeResult(ctx, key, p, err)
+	return p, err
+}
+
+// resolveResult 把策略的结果转换为返回给调用方的结果, 策略返回错误时使用默认值
+func (c *CacheCtr[T]) resolveResult(result any, err error) (p T, _ error) {
+	if err != nil {
+		if c.fallback != nil && !errors.Is(err, ErrQueryInFlight) {
+			return c.fallback(), nil
+		}
+		return p, err
+	}
+	v, ok := result.(T)
+	if !ok {
+		return p, errors.WithMessage(ErrUnpackingFailed, "pares for T error")
+	}
+	return v, nil
+}
+
+// observeResult 通知实现 ResultObserver 的插件最终返回给调用方的结果
+func (c *CacheCtr[T]) observeResult(ctx context.Context, key string, value T, err error) {
+	for _, plugin := range c.pluginChain(ctx) {
+		if observer, ok := plugin.(ResultObserver); ok {
+			observer.ObserveResult(ctx, key, value, err)
+		}
+	}
+}
+
+type ctxBypassPluginsKey struct{}
+
+// WithBypassPlugins 在上下文中设置本次请求跳过插件链, 直接使用原始的缓存和 query 加载方法
+// 适用于预热、后台管理刷新等不应该被限流或者熔断的请求, 回调和统计依然生效
+func WithBypassPlugins(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxBypassPluginsKey{}, true)
+}
+
+type ctxBypassRatioKey struct{}
+
+// WithBypassRatio 在上下文中设置跳过缓存读取的比例, 用于灰度时按比例回源校验数据源
+// 每次调用以 ratio 的概率跳过缓存读取 (视为未命中), query 结果依然写入缓存, 回源请求依然经过策略的 singleflight 合并
+// ratio <= 0 不跳过, ratio >= 1 总是跳过
+func WithBypassRatio(ctx context.Context, ratio float64) context.Context {
+	return context.WithValue(ctx, ctxBypassRatioKey{}, ratio)
+}
+
+// bypassCache 根据上下文中的比例判断本次请求是否跳过缓存读取
+func bypassCache(ctx context.Context) bool {
+	ratio, ok := ctx.Value(ctxBypassRatioKey{}).(float64)
+	return ok && ratio > 0 && rand.Float64() < ratio
+}
+
+// repairPoison 删除无法拆箱的损坏数据, 并视为未命中, 由策略执行 query 重新写入
+// 每个 key 只在第一次发现时触发 OnError 回调, 重新写入成功后再次损坏时会再次触发
+// 类型不匹配 (例如 WithStrictTyping) 不是数据损坏, 直接返回错误, 不删除数据;
+// 记录的 key 超过 maxPoisonedKeys 时清空记录, 之后发现的损坏数据会再次触发回调
+func (c *CacheCtr[T]) repairPoison(ctx context.Context, key string, err error) error {
+	if errors.Is(err, errTypeMismatch) {
+		return err
+	}
+	if _, logged := c.poisoned.LoadOrStore(key, struct{}{}); !logged {
+		if c.poisonedN.Add(1) > maxPoisonedKeys {
+			c.poisoned.Clear()
+			c.poisonedN.Store(0)
+		}
+		c.callOnError(ctx, key, err)
+	}
+	_ = c.getStore(ctx).Del(ctx, c.storeKey(key))
+	return fmt.Errorf("%w: deleted poisoned value, %v", ErrKeyNonExistent, err)
+}
+
+// shortCircuitCachedError 读取缓存得到缓存的 query 错误时, 策略执行 query 直接返回该错误, 不再执行 query
+func shortCircuitCachedError(loadQuery LoadingForQuery, loadCache LoadingForCache) (LoadingForQuery, LoadingForCache) {
+	var cachedErr atomic.Pointer[CachedError]
+	wrapCache := func(ctx context.Context, key string) (any, int, error) {
+		value, timestamp, err := loadCache(ctx, key)
+		var cErr *CachedError
+		if errors.As(err, &cErr) {
+			cachedErr.Store(cErr)
+		}
+		return value, timestamp, err
+	}
+	wrapQuery := func(ctx context.Context, key string, ttl time.Duration) (any, error) {
+		if cErr := cachedErr.Load(); cErr != nil {
+			return nil, cErr
+		}
+		return loadQuery(ctx, key, ttl)
+	}
+	return wrapQuery, wrapCache
+}
+
+// pluginChain 获取本次请求使用的插件链
+func (c *CacheCtr[T]) pluginChain(ctx context.Context) []Plugin {
+	if bypass, _ := ctx.Value(ctxBypassPluginsKey{}).(bool); bypass {
+		return nil
+	}
+	return c.plugins
+}
+
+// readRepairBox 修复读取失败的缓存, 删除不兼容的数据 (旧版本写入或者类型不匹配), 损坏的数据使用 repairPoison 修复
+// 立即删除可以避免其他节点在 query 重新写入前一直读取到这些数据
+func (c *CacheCtr[T]) readRepairBox(ctx context.Context, key string, err error) error {
+	switch {
+	case errors.Is(err, errIncompatibleValue):
+		_ = c.getStore(ctx).Del(ctx, c.storeKey(key))
+	case !c.keepPoison && errors.Is(err, ErrUnpackingFailed):
+		return c.repairPoison(ctx, key, err)
+	}
+	return err
+}
+
+// buildTryLoadingCache 构造缓存加载方法
+func (c *CacheCtr[T]) buildTryLoadingCache(ctx context.Context, key string) (LoadingForCache, error) {
+	bypass := bypassCache(ctx)
+	loadCache := func(ctx context.Context, key string) (any, int, error) {
+		if bypass {
+			c.stats.misses.Add(1)
+			return nil, 0, fmt.Errorf("%w: bypassed by ratio", ErrKeyNonExistent)
+		}
+		box, err := c.getBox(ctx, key)
+		if err != nil {
+			c.stats.misses.Add(1)
+			if c.readRepair || !c.keepPoison && errors.Is(err, ErrUnpackingFailed) {
+				err = c.readRepairBox(ctx, key, err)
+			}
+			return nil, 0, err
+		}
+		if box.Err != "" {
+			c.stats.hits.Add(1)
+			return nil, 0, &CachedError{Msg: box.Err}
+		}
+		if isNil(box.T) {
+			c.stats.misses.Add(1)
+			return nil, 0, ErrNil
+		}
+		if c.validate != nil {
+			if err := c.validate(box.T); err != nil {
+				c.stats.misses.Add(1)
+				err = fmt.Errorf("%w: controller %s key %s, %w", ErrInvalidValue, c.Name, key, err)
+				c.callOnError(ctx, key, err)
+				return nil, 0, fmt.Errorf("%w: %w", ErrKeyNonExistent, err)
+			}
+		}
+		c.stats.hits.Add(1)
+		if meta, ok := ctx.Value(ctxBoxMetaKey{}).(*BoxMeta); ok {
+			meta.Timestamp = box.Timestamp
+			meta.TimestampMs = box.TimestampMs
+			meta.ComputeMs = box.ComputeMs
+		}
+		return box.T, box.Timestamp, nil
+	}
+
+	for _, plugin := range c.pluginChain(ctx) {
+		plugCache, ok, err := plugin.InterceptCallCache(ctx, key, loadCache)
+		if err != nil {
+			c.callOnError(ctx, key, err)
+			return nil, err
+		}
+		loadCache = plugCache
+		if !ok {
+			break
+		}
+	}
+	return c.hookLoadingCache(loadCache), nil
+}
+
+// hookLoadingCache 在插件链外层挂载回调, 插件提前熔断时回调依然执行
+func (c *CacheCtr[T]) hookLoadingCache(loadCache LoadingForCache) LoadingForCache {
+	if c.onHit == nil && c.onMiss == nil && c.onError == nil {
+		return loadCache
+	}
+	return func(ctx context.Context, key string) (any, int, error) {
+		value, timestamp, err := loadCache(ctx, key)
+		var cachedErr *CachedError
+		switch {
+		case err == nil || errors.As(err, &cachedErr):
+			if c.onHit != nil {
+				c.onHit(ctx, key)
+			}
+		case errors.Is(err, ErrKeyNonExistent) || errors.Is(err, ErrNil):
+			if c.onMiss != nil {
+				c.onMiss(ctx, key)
+			}
+		default:
+			c.callOnError(ctx, key, err)
+		}
+		return value, timestamp, err
+	}
+}
+
+// 构造 query 加载方法
+func (c *CacheCtr[T]) buildTryLoadingQuery(ctx context.Context, key string, query CacheableQuery[T]) (LoadingForQuery, error) {
+	loadQuery := func(ctx context.Context, key string, ttl time.Duration) (any, error) {
+		// 调用query方法
+		startTime := now()
+		c.stats.queryCalls.Add(1)
+		qCtx := ctx
+		if timeout, _ := ctx.Value(ctxQueryTimeoutKey{}).(time.Duration); timeout > 0 {
+			var cancel context.CancelFunc
+			qCtx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		value, cacheable, err := query(qCtx)
+		if err != nil {
+			c.stats.queryErrors.Add(1)
+			// 只在缓存不存在时缓存错误, 避免覆盖策略可以重用的旧数据
+			if c.errCacheTTL > 0 && c.errCacheable(err) {
+				if exists, eErr := c.Exists(ctx, key); eErr == nil && !exists {
+					box := &AbcBox[T]{Err: err.Error(), Timestamp: int(startTime.Unix()), TimestampMs: startTime.UnixMilli()}
+					_ = c.setBox(ctx, key, box, c.errCacheTTL, true)
+				}
+			}
+			return nil, &QueryError{Key: key, Err: err}
+		}
+		// 上下文覆盖 ttl
+		if override, ok := ctx.Value(ctxTTLOverrideKey{}).(time.Duration); ok && ttl != KeepTTL {
+			ttl = override
+		}
+		// 装箱, 使用 query 开始时间作为数据创建时间, 条件写入时慢查询不会覆盖更新的数据
+		if cacheable {
+			box := &AbcBox[T]{
+				T:           value,
+				Timestamp:   int(startTime.Unix()),
+				TimestampMs: startTime.UnixMilli(),
+				ComputeMs:   now().Sub(startTime).Milliseconds(),
+			}
+			if c.setBox(ctx, key, box, ttl, true) == nil {
+				if _, logged := c.poisoned.LoadAndDelete(key); logged {
+					c.poisonedN.Add(-1)
+				}
+			}
+		}
+
+		if isNil(value) {
+			return nil, ErrNil
+		}
+		return value, nil
+	}
+
+	for _, plugin := range c.pluginChain(ctx) {
+		plugQuery, ok, err := plugin.InterceptCallQuery(ctx, key, loadQuery)
+		if err != nil {
+			c.callOnError(ctx, key, err)
+			return nil, err
+		}
+		loadQuery = plugQuery
+		if !ok {
+			break
+		}
+	}
+	return c.hookLoadingQuery(loadQuery), nil
+}
+
+// hookLoadingQuery 在插件链外层挂载错误回调, 插件提前熔断时回调依然执行
+func (c *CacheCtr[T]) hookLoadingQuery(loadQuery LoadingForQuery) LoadingForQuery {
+	if c.onError == nil {
+		return loadQuery
+	}
+	return func(ctx context.Context, key string, ttl time.Duration) (any, error) {
+		value, err := loadQuery(ctx, key, ttl)
+		if err != nil && !errors.Is(err, ErrNil) {
+			c.callOnError(ctx, key, err)
+		}
+		return value, err
+	}
+}
+
+func (c *CacheCtr[T]) callOnError(ctx context.Context, key string, err error) {
+	if c.onError != nil {
+		c.onError(ctx, key, err)
+	}
+}
+
+// recordShared 记录 singleflight 合并的请求, 更新统计并通知 OnShared 回调和实现 SharedObserver 的插件
+func (c *CacheCtr[T]) recordShared(ctx context.Context, key string) {
+	c.stats.sharedCalls.Add(1)
+	if c.onShared != nil {
+		c.onShared(ctx, key)
+	}
+	for _, plugin := range c.pluginChain(ctx) {
+		if observer, ok := plugin.(SharedObserver); ok {
+			observer.OnShared(ctx, key)
+		}
+	}
+}
+
+// recordStaleServed 记录策略返回的过期数据, 更新统计并通知 OnStaleServe 回调和实现 StaleObserver 的插件
+func (c *CacheCtr[T]) recordStaleServed(ctx context.Context, key string) {
+	c.stats.staleServed.Add(1)
+	if c.onStale != nil {
+		c.onStale(ctx, key)
+	}
+	for _, plugin := range c.pluginChain(ctx) {
+		if observer, ok := plugin.(StaleObserver); ok {
+			observer.OnStaleServe(ctx, key)
+		}
+	}
+}
+
+// NewCacheController 创建一个缓存控制器, 默认使用简单策略模式，设置 15 秒的缓存过期时间
+func NewCacheController[T any](name string, store Store, optionChain ...Option[T]) *CacheCtr[T] {
+	ctr := &CacheCtr[T]{
+		Name:    name,
+		plugins: []Plugin{},
+		//nolint:mnd
+		warp:  EasyPloy(15 * time.Second),
+		store: store,
+	}
+	for _, opt := range optionChain {
+		opt(ctr)
+	}
+	return ctr
+}
+
+var (
+	ctrStore = sync.Map{}
+
+	defaultStore atomic.Pointer[Store] // 包级默认存储, 见 SetDefaultStore
+)
+
+// SetDefaultStore 设置包级默认存储, 供 WrapDefault 使用, 通常在启动时设置, 并发安全
+// store 为 nil 时清除默认存储
+func SetDefaultStore(store Store) {
+	if store == nil {
+		defaultStore.Store(nil)
+		return
+	}
+	defaultStore.Store(&store)
+}
+
+// DefaultStore 获取包级默认存储, 未设置时返回 nil
+func DefaultStore() Store {
+	if store := defaultStore.Load(); store != nil {
+		return *store
+	}
+	return nil
+}
+
+// WrapDefault 使用 SetDefaultStore 设置的默认存储调用 Wrap, 未设置默认存储时返回 ErrNoDefaultStore 错误
+// # 注意控制器在 name 第一次使用时创建, 之后修改默认存储不会影响已经创建的控制器
+func WrapDefault[T any](ctx context.Context, name string, key string, query Query[T]) (T, error) {
+	store := DefaultStore()
+	if store == nil {
+		return *new(T), ErrNoDefaultStore
+	}
+	return Wrap(ctx, name, store, key, query)
+}
+
+// Deprecated: use WrapWithTTL
+// Wrap 控制器封装方法，创建默认的控制器, 注意 name 只能够对应一个缓存 T 如果，冲突创建，会引发错误
+// 该方法默认使用 PolicyWarp 策略,应该使用 NewCacheController 来创建自定义的缓存控制器
+// 使用缓存策略 EasyPloy(15 * time.Second)
+func Wrap[T any](ctx context.Context, name string, store Store, key string, query Query[T]) (T, error) {
+	ctrIntr, ok := ctrStore.Load(name)
+	if ok {
+		if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
+			return ctr.Wrap(ctx, key, query)
+		}
+	}
+
+	// 创建并且使用 ctr
+	ctrIntr, _ = ctrStore.LoadOrStore(name, NewCacheController[T](name, store))
+	if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
+		return ctr.Wrap(ctx, key, query)
+	}
+	return *new(T), fmt.Errorf("unable to create a new cache controller, named to be used; name:%s, loadedType:%T", name, ctrIntr)
+}
+
+// // Deprecated: use WrapForReuseIgnoreErrorWithTTL
+// WrapForReuseIgnoreError 重用缓存封装模型, 注意 name 只能够对应一个缓存 T 如果，冲突创建，会引发错误
+// 使用缓存策略 ReuseCachePloy(30 * time.Second)
+// # 注意如果命中缓存，那么当 query 执行失败时，这个策略会重复使用缓存数据，直到 query 执行成功为止。
+func WrapForReuseIgnoreError[T any](ctx context.Context, name string, store Store, key string, query Query[T]) (T, error) {
+	const (
+		defaultTTL = 30 * time.Second
+	)
+
+	ctrIntr, ok := ctrStore.Load(name)
+	if ok {
+		if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
+			return ctr.Wrap(ctx, key, query)
+		}
+	}
+
+	// 创建并且使用 ctr
+	ctrIntr, _ = ctrStore.LoadOrStore(name, NewCacheController(name, store,
+		WithPolicy[T](ReuseCachePloyIgnoreError(defaultTTL)),
+	))
+	if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
+		return ctr.Wrap(ctx, key, query)
+	}
+	return *new(T), fmt.Errorf("unable to create a new cache controller, named to be used; name:%s, loadedType:%T", name, ctrIntr)
+}
+
+// Deprecated: use WrapForReuseIgnoreErrorWithTTL
+// WrapForFirst 优先缓存封装模型, 注意 name 只能够对应一个缓存 T 如果，冲突创建，会引发错误
+// 使用缓存策略 FirstCachePoly(1 * time.Minute)
+// # 注意如果命中缓存，那么当 query 执行失败时，这个策略会重复使用缓存数据，直到 query 执行成功为止。
+func WrapForFirstIgnoreError[T any](ctx context.Context, name string, store Store, key string, query Query[T]) (T, error) {
+	const (
+		defaultTTL = 1 * time.Minute
+	)
+
+	ctrIntr, ok := ctrStore.Load(name)
+	if ok {
+		if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
+			return ctr.Wrap(ctx, key, query)
+		}
+	}
+
+	// 创建并且使用 ctr
+	ctrIntr, _ = ctrStore.LoadOrStore(name, NewCacheController(name, store,
+		WithPolicy[T](FirstCachePolyIgnoreError(defaultTTL)),
+	))
+	if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
+		return ctr.Wrap(ctx, key, query)
+	}
+	return *new(T), fmt.Errorf("unable to create a new cache controller, named to be used; name:%s, loadedType:%T", name, ctrIntr)
+}
+
+// WrapForFirstIgnoreErrorWithTTL
+// # 注意如果命中缓存，那么当 query 执行失败时，这个策略会重复使用缓存数据，直到 query 执行成功为止。
+// # 注意控制器名称由类型 T 推导, 相同类型 T 的不同数据集会共享同一个控制器(策略实例和 singleflight),
+// 不同数据集的 key 不能重复, 需要隔离时应该使用 WrapForFirstIgnoreErrorNamed。
+func WrapForFirstIgnoreErrorWithTTL[T any](ctx context.Context, store Store, key string, ttl time.Duration, query Query[T]) (T, error) {
+	name := fmt.Sprintf("library-modecache-first-default-%T", new(T))
+
+	ctrIntr, ok := ctrStore.Load(name)
+	if ok {
+		if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
+			return ctr.Wrap(ctx, key, query)
+		}
+	}
+	// 创建并且使用 ctr
+	ctrIntr, _ = ctrStore.LoadOrStore(name, NewCacheController(name, store,
+		WithPolicy[T](FirstCachePolyIgnoreError(ttl)),
+	))
+	if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
+		return ctr.Wrap(ctx, key, query)
+	}
+	return *new(T), fmt.Errorf("unable to create a new cache controller, named to be used; name:%s, loadedType:%T", name, ctrIntr)
+}
+
+// WrapForFirstIgnoreErrorNamed 使用显式名称的优先缓存封装模型, 注意 name 只能够对应一个缓存 T 如果，冲突创建，会引发错误
+// 不同名称的控制器相互隔离, 适用于相同类型 T 的不同数据集
+// # 注意如果命中缓存，那么当 query 执行失败时，这个策略会重复使用缓存数据，直到 query 执行成功为止。
+func WrapForFirstIgnoreErrorNamed[T any](ctx context.Context, name string, store Store, key string, ttl time.Duration, query Query[T]) (T, error) {
+	ctrIntr, ok := ctrStore.Load(name)
+	if ok {
+		if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
+			return ctr.Wrap(ctx, key, query)
+		}
+	}
+	// 创建并且使用 ctr
+	ctrIntr, _ = ctrStore.LoadOrStore(name, NewCacheController(name, store,
+		WithPolicy[T](FirstCachePolyIgnoreError(ttl)),
+	))
+	if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
+		return ctr.Wrap(ctx, key, query)
+	}
+	return *new(T), fmt.Errorf("unable to create a new cache controller, named to be used; name:%s, loadedType:%T", name, ctrIntr)
+}
+
+// WrapForReuseIgnoreErrorWithTTL
+// # 注意如果命中缓存，那么当 query 执行失败时，这个策略会重复使用缓存数据，直到 query 执行成功为止。
+// # 注意控制器名称由类型 T 推导, 相同类型 T 的不同数据集会共享同一个控制器(策略实例和 singleflight),
+// 不同数据集的 key 不能重复, 需要隔离时应该使用 WrapForReuseIgnoreErrorNamed。
+func WrapForReuseIgnoreErrorWithTTL[T any](ctx context.Context, store Store, key string, ttl time.Duration, query Query[T]) (T, error) {
+	name := fmt.Sprintf("library-modecache-reuse-default-%T", new(T))
+
+	ctrIntr, ok := ctrStore.Load(name)
+	if ok {
+		if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
+			return ctr.Wrap(ctx, key, query)
+		}
+	}
+	// 创建并且使用 ctr
+	ctrIntr, _ = ctrStore.LoadOrStore(name, NewCacheController(name, store,
+		WithPolicy[T](ReuseCachePloyIgnoreError(ttl)),
+	))
+	if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
+		return ctr.Wrap(ctx, key, query)
+	}
+	return *new(T), fmt.Errorf("unable to create a new cache controller, named to be used; name:%s, loadedType:%T", name, ctrIntr)
+}
+
+// WrapForReuseIgnoreErrorNamed 使用显式名称的重用缓存封装模型, 注意 name 只能够对应一个缓存 T 如果，冲突创建，会引发错误
+// 不同名称的控制器相互隔离, 适用于相同类型 T 的不同数据集
+// # 注意如果命中缓存，那么当 query 执行失败时，这个策略会重复使用缓存数据，直到 query 执行成功为止。
+func WrapForReuseIgnoreErrorNamed[T any](ctx context.Context, name string, store Store, key string, ttl time.Duration, query Query[T]) (T, error) {
+	ctrIntr, ok := ctrStore.Load(name)
+	if ok {
+		if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
+			return ctr.Wrap(ctx, key, query)
+		}
+	}
+	// 创建并且使用 ctr
+	ctrIntr, _ = ctrStore.LoadOrStore(name, NewCacheController(name, store,
+		WithPolicy[T](ReuseCachePloyIgnoreError(ttl)),
+	))
+	if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
+		return ctr.Wrap(ctx, key, query)
+	}
+	return *new(T), fmt.Errorf("unable to create a new cache controller, named to be used; name:%s, loadedType:%T", name, ctrIntr)
+}
+
+// WrapWithTTL 简单的缓存策略，当 query 执行失败时，直接返回错误。
+// # 注意控制器名称由类型 T 推导, 相同类型 T 的不同数据集会共享同一个控制器(策略实例和 singleflight),
+// 不同数据集的 key 不能重复, 需要隔离时应该使用 WrapWithTTLNamed。
+func WrapWithTTL[T any](ctx context.Context, store Store, key string, ttl time.Duration, query Query[T]) (T, error) {
+	name := fmt.Sprintf("library-modecache-easy-default-%T", new(T))
+
+	ctrIntr, ok := ctrStore.Load(name)
+	if ok {
+		if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
+			return ctr.Wrap(ctx, key, query)
+		}
+	}
+	// 创建并且使用 ctr
+	ctrIntr, _ = ctrStore.LoadOrStore(name, NewCacheController(name, store,
+		WithPolicy[T](EasyPloy(ttl)),
+	))
+	if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
+		return ctr.Wrap(ctx, key, query)
+	}
+	return *new(T), fmt.Errorf("unable to create a new cache controller, named to be used; name:%s, loadedType:%T", name, ctrIntr)
+}
+
+// WrapWithTTLNamed 使用显式名称的简单缓存封装模型, 注意 name 只能够对应一个缓存 T 如果，冲突创建，会引发错误
+// 不同名称的控制器相互隔离, 适用于相同类型 T 的不同数据集
+func WrapWithTTLNamed[T any](ctx context.Context, name string, store Store, key string, ttl time.Duration, query Query[T]) (T, error) {
+	ctrIntr, ok := ctrStore.Load(name)
+	if ok {
+		if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
+			return ctr.Wrap(ctx, key, query)
+		}
+	}
+	// 创建并且使用 ctr
+	ctrIntr, _ = ctrStore.LoadOrStore(name, NewCacheController(name, store,
+		WithPolicy[T](EasyPloy(ttl)),
+	))
+	if ctr, ok := ctrIntr.(*CacheCtr[T]); ok {
+		return ctr.Wrap(ctx, key, query)
+	}
+	return *new(T), fmt.Errorf("unable to create a new cache controller, named to be used; name:%s, loadedType:%T", name, ctrIntr)
+}
+
+// SetStore 不创建控制器, 使用和 CacheCtr.SetStore 相同的装箱和编码写入缓存, ttl 使用 KeepTTL 表示永不过期
+// 上下文中设置了 CtxStorageKey 时优先使用上下文中的 Store, 和控制器的行为一致
+// 写入的数据可以被相同类型的控制器读取, 适用于在控制器之外预热或者修复缓存
+func SetStore[T any](ctx context.Context, store Store, key string, value T, ttl time.Duration) error {
+	ctr := CacheCtr[T]{
+		store: store,
+	}
+	return ctr.SetStore(ctx, key, value, ttl)
+}
+
+// EstimateSize 估算数据写入非直接存储 (例如 redis) 后的大小 (字节), 使用和 SetStore 相同的装箱和编码, 不写入任何存储
+// 用于在缓存新的类型前评估存储的内存占用; 不包含存储本身的开销 (例如 redis 的 key 和对象头)
+func EstimateSize[T any](value T) (int, error) {
+	var ctr CacheCtr[T]
+	createdAt := now()
+	data, err := ctr.encodeBox(false, &AbcBox[T]{
+		T:           value,
+		Timestamp:   int(createdAt.Unix()),
+		TimestampMs: createdAt.UnixMilli(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(data.(string)), nil
+}
+
+// GetStore 不创建控制器, 使用和 CacheCtr.GetStore 相同的解码读取缓存, return: 数据, 数据创建时间 (秒), 错误
+// 上下文中设置了 CtxStorageKey 时优先使用上下文中的 Store, 缓存不存在时返回 ErrKeyNonExistent 错误
+func GetStore[T any](ctx context.Context, store Store, key string) (T, int, error) {
+	ctr := CacheCtr[T]{
+		store: store,
+	}
+	return ctr.GetStore(ctx, key)
+}
+
+// DeleteStore 删除缓存
+func DeleteStore(ctx context.Context, store Store, key string) error {
+	return store.Del(ctx, key)
+}
+
+// Clear 清空存储中的全部缓存, 存储未实现 Clearable 时返回 ErrUnsupported 错误
+func Clear(ctx context.Context, store Store) error {
+	clearable, ok := store.(Clearable)
+	if !ok {
+		return fmt.Errorf("%w: %T does not implement Clearable", ErrUnsupported, store)
+	}
+	return clearable.Clear(ctx)
+}
+
+// ScanDel 删除存储中 key 以 prefix 开头的全部缓存, 返回删除的数量, 存储未实现 PatternDeleter 时返回 ErrUnsupported 错误
+// ctx 取消时中止删除, 返回已经删除的数量和 ctx 的错误, 避免大量删除阻塞服务退出
+func ScanDel(ctx context.Context, store Store, prefix string) (int, error) {
+	deleter, ok := store.(PatternDeleter)
+	if !ok {
+		return 0, fmt.Errorf("%w: %T does not implement PatternDeleter", ErrUnsupported, store)
+	}
+	return deleter.DelByPrefix(ctx, prefix)
+}
+
+// DumpStore 列出存储中的缓存数据, 最多返回 limit 个, limit <= 0 时使用 DefaultDumpLimit
+// 存储未实现 Dumpable 时返回 ErrUnsupported 错误
+// # 注意只用于排查问题, 大量数据会占用存储和内存资源
+func DumpStore(ctx context.Context, store Store, limit int) (map[string]any, error) {
+	dumpable, ok := store.(Dumpable)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T does not implement Dumpable", ErrUnsupported, store)
+	}
+	if limit <= 0 {
+		limit = DefaultDumpLimit
+	}
+	return dumpable.Dump(ctx, limit)
+}
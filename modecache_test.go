@@ -1,10 +1,15 @@
 package modecache
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
+	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -331,33 +336,6 @@ func TestControlWrap(t *testing.T) {
 	}
 }
 
-type snakeCache struct {
-	result any
-	isErr  bool
-}
-
-func (s snakeCache) Get(ctx context.Context, key string) (any, error) {
-	if s.isErr {
-		return s.result, errors.New("test err")
-	}
-	return s.result, nil
-}
-
-func (s snakeCache) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
-	if s.isErr {
-		return errors.New("test err")
-	}
-	return nil
-}
-
-func (s snakeCache) Del(ctx context.Context, key string) error {
-	return nil
-}
-
-func (s snakeCache) IsDirectStore() bool {
-	return true
-}
-
 func TestControlWrapErrorTest(t *testing.T) {
 	rdsStore, c := getRedis()
 	defer c()
@@ -473,10 +451,7 @@ func TestControlWrapErrorTest(t *testing.T) {
 			require.Equal(t, res, 1)
 
 			// 测试 cache Error 的场景
-			ctr.store = snakeCache{
-				result: 1,
-				isErr:  true,
-			}
+			ctr.store = getClosedRedis(t)
 			res, err = ctr.Wrap(context.Background(), "test_error", func(ctx context.Context) (int, error) {
 				return 1, nil
 			})
@@ -932,11 +907,8 @@ func TestSetStoreAndGetStoreIntegration(t *testing.T) {
 func TestSetStoreGetStoreWithNilStore(t *testing.T) {
 	ctx := context.Background()
 
-	// 使用一个总是返回错误的 mock store
-	errorStore := snakeCache{
-		result: nil,
-		isErr:  true,
-	}
+	// 使用一个读写总是返回错误的 store
+	errorStore := getClosedRedis(t)
 
 	t.Run("SetStore with error store", func(t *testing.T) {
 		err := SetStore(ctx, errorStore, "test_key", "test_value", time.Minute)
@@ -950,3 +922,1633 @@ func TestSetStoreGetStoreWithNilStore(t *testing.T) {
 		require.Equal(t, 0, timestamp)
 	})
 }
+
+// TestSetStoreGetStoreContextOverride 测试包级 SetStore/GetStore 使用上下文中的 Store, 并且和控制器互通
+func TestSetStoreGetStoreContextOverride(t *testing.T) {
+	rds, closer := getRedis()
+	defer closer()
+
+	for _, override := range []Store{NewMemoryStore(), rds} {
+		store := NewMemoryStore()
+		ctx := context.WithValue(context.Background(), CtxStorageKey{}, override)
+
+		require.NoError(t, SetStore(ctx, store, "override", 42, time.Minute))
+		_, err := store.Get(ctx, "override")
+		require.ErrorIs(t, err, ErrKeyNonExistent)
+
+		got, timestamp, err := GetStore[int](ctx, store, "override")
+		require.NoError(t, err)
+		require.Equal(t, 42, got)
+		require.NotZero(t, timestamp)
+
+		// 控制器可以读取包级方法写入的数据
+		ctr := NewCacheController[int]("test-store-override", override)
+		got, _, err = ctr.GetStore(context.Background(), "override")
+		require.NoError(t, err)
+		require.Equal(t, 42, got)
+
+		require.NoError(t, ctr.SetStore(context.Background(), "ctr", 7, time.Minute))
+		got, _, err = GetStore[int](ctx, store, "ctr")
+		require.NoError(t, err)
+		require.Equal(t, 7, got)
+	}
+}
+
+// TestStoreResolver 测试存储选择器的优先级
+func TestStoreResolver(t *testing.T) {
+	ctx := context.Background()
+	defaultStore := NewCacheStore(getTestLocalCache())
+	shardA := NewCacheStore(getTestLocalCache())
+	shardB := NewCacheStore(getTestLocalCache())
+
+	type tenantKey struct{}
+	ctr := NewCacheController[int]("test-resolver", defaultStore,
+		WithStoreResolver[int](func(ctx context.Context) Store {
+			switch ctx.Value(tenantKey{}) {
+			case "a":
+				return shardA
+			case "b":
+				return shardB
+			}
+			return nil
+		}),
+	)
+
+	query := func(ctx context.Context) (int, error) { return 1, nil }
+
+	// 选择器命中分片 A
+	_, err := ctr.Wrap(context.WithValue(ctx, tenantKey{}, "a"), "key", query)
+	require.NoError(t, err)
+	_, err = shardA.Get(ctx, "key")
+	require.NoError(t, err)
+	_, err = shardB.Get(ctx, "key")
+	require.ErrorIs(t, err, ErrKeyNonExistent)
+
+	// 选择器未命中, 回退到上下文中的 Store
+	ctxStore := NewCacheStore(getTestLocalCache())
+	_, err = ctr.Wrap(context.WithValue(ctx, CtxStorageKey{}, ctxStore), "key", query)
+	require.NoError(t, err)
+	_, err = ctxStore.Get(ctx, "key")
+	require.NoError(t, err)
+
+	// 都未设置, 使用默认 Store
+	_, err = ctr.Wrap(ctx, "key", query)
+	require.NoError(t, err)
+	_, err = defaultStore.Get(ctx, "key")
+	require.NoError(t, err)
+}
+
+// TestFirstCacheRefreshTimeout 测试后台刷新超时后释放分片锁
+func TestFirstCacheRefreshTimeout(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	ctr := NewCacheController[int]("test-refresh-timeout", store,
+		WithPolicy[int](FirstCachePolyIgnoreErrorWithTimeout(time.Second, 50*time.Millisecond)),
+	)
+
+	// 写入已过期的缓存数据
+	_ = store.Set(ctx, "key", &AbcBox[int]{T: 1, Timestamp: int(time.Now().Add(-time.Minute).Unix())}, KeepTTL)
+
+	var queryCount int64
+	hangQuery := func(ctx context.Context) (int, error) {
+		atomic.AddInt64(&queryCount, 1)
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+
+	// 返回过期数据, 后台刷新阻塞直到超时
+	res, err := ctr.Wrap(ctx, "key", hangQuery)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&queryCount) == 1 }, time.Second, time.Millisecond)
+
+	// 刷新期间不会重复拉起刷新
+	_, _ = ctr.Wrap(ctx, "key", hangQuery)
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, int64(1), atomic.LoadInt64(&queryCount))
+
+	// 刷新超时释放分片锁后可以再次刷新
+	time.Sleep(100 * time.Millisecond)
+	res, err = ctr.Wrap(ctx, "key", hangQuery)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&queryCount) == 2 }, time.Second, 10*time.Millisecond)
+}
+
+// TestInvalidateWithDelay 测试延迟双删
+func TestInvalidateWithDelay(t *testing.T) {
+	store := NewMemoryStore()
+	ctr := NewCacheController[int]("test-double-delete", store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, ctr.SetStore(ctx, "key", 1, time.Minute))
+	require.NoError(t, ctr.InvalidateWithDelay(ctx, "key", 50*time.Millisecond))
+	cancel()
+
+	// 第一次删除立即生效
+	_, _, err := ctr.GetStore(context.Background(), "key")
+	require.ErrorIs(t, err, ErrKeyNonExistent)
+
+	// 模拟并发读取回填旧数据
+	require.NoError(t, ctr.SetStore(context.Background(), "key", 0, time.Minute))
+
+	// 第二次删除在请求上下文取消后依然执行
+	require.Eventually(t, func() bool {
+		_, _, err := ctr.GetStore(context.Background(), "key")
+		return errors.Is(err, ErrKeyNonExistent)
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestProbabilisticPloy 测试概率提前过期策略
+func TestProbabilisticPloy(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	ctr := NewCacheController[int]("test-xfetch", store, WithPolicy[int](ProbabilisticPloy(time.Minute, 1)))
+
+	var queryCount int64
+	query := func(ctx context.Context) (int, error) {
+		atomic.AddInt64(&queryCount, 1)
+		time.Sleep(5 * time.Millisecond)
+		return 2, nil
+	}
+
+	// 缓存未命中, 执行 query 并记录执行耗时
+	res, err := ctr.Wrap(ctx, "key", query)
+	require.NoError(t, err)
+	require.Equal(t, 2, res)
+	require.Equal(t, int64(1), queryCount)
+	box, err := ctr.getBox(ctx, "key")
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, box.ComputeMs, int64(5))
+
+	// 新鲜的缓存且执行耗时很短, 不会提前刷新
+	_ = store.Set(ctx, "key", &AbcBox[int]{T: 1, Timestamp: int(time.Now().Unix())}, time.Minute)
+	res, err = ctr.Wrap(ctx, "key", query)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+	require.Equal(t, int64(1), queryCount)
+
+	// 执行耗时很长, 提前刷新
+	_ = store.Set(ctx, "key", &AbcBox[int]{T: 1, Timestamp: int(time.Now().Unix()), ComputeMs: 1e12}, time.Minute)
+	res, err = ctr.Wrap(ctx, "key", query)
+	require.NoError(t, err)
+	require.Equal(t, 2, res)
+	require.Equal(t, int64(2), queryCount)
+
+	// 提前刷新失败, 使用未过期的缓存数据
+	_ = store.Set(ctx, "key", &AbcBox[int]{T: 1, Timestamp: int(time.Now().Unix()), ComputeMs: 1e12}, time.Minute)
+	res, err = ctr.Wrap(ctx, "key", func(ctx context.Context) (int, error) {
+		return 0, errors.New("test error")
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+}
+
+// TestWrapForReuseIgnoreErrorNamed 测试显式名称隔离控制器
+func TestWrapForReuseIgnoreErrorNamed(t *testing.T) {
+	ctx := context.Background()
+	storeA := NewMemoryStore()
+	storeB := NewMemoryStore()
+
+	resA, err := WrapForReuseIgnoreErrorNamed(ctx, "test-named-a", storeA, "key", time.Minute, func(ctx context.Context) (int, error) {
+		return 1, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, resA)
+
+	// 相同类型不同名称, 使用各自的控制器和 store
+	resB, err := WrapForReuseIgnoreErrorNamed(ctx, "test-named-b", storeB, "key", time.Minute, func(ctx context.Context) (int, error) {
+		return 2, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, resB)
+
+	// 名称冲突的类型返回错误
+	_, err = WrapForReuseIgnoreErrorNamed(ctx, "test-named-a", storeA, "key", time.Minute, func(ctx context.Context) (string, error) {
+		return "", nil
+	})
+	require.Error(t, err)
+}
+
+// TestWrapNamed 测试简单缓存和优先缓存的显式名称版本
+func TestWrapNamed(t *testing.T) {
+	ctx := context.Background()
+	wraps := map[string]func(ctx context.Context, name string, store Store, key string, ttl time.Duration, query Query[int]) (int, error){
+		"easy":  WrapWithTTLNamed[int],
+		"first": WrapForFirstIgnoreErrorNamed[int],
+	}
+	for mode, wrap := range wraps {
+		storeA, storeB := NewMemoryStore(), NewMemoryStore()
+		resA, err := wrap(ctx, "test-named-"+mode+"-a", storeA, "key", time.Minute, func(ctx context.Context) (int, error) { return 1, nil })
+		require.NoError(t, err)
+		require.Equal(t, 1, resA)
+
+		// 相同类型不同名称, 使用各自的控制器和 store
+		resB, err := wrap(ctx, "test-named-"+mode+"-b", storeB, "key", time.Minute, func(ctx context.Context) (int, error) { return 2, nil })
+		require.NoError(t, err)
+		require.Equal(t, 2, resB)
+		got, _, err := GetStore[int](ctx, storeA, "key")
+		require.NoError(t, err)
+		require.Equal(t, 1, got)
+	}
+}
+
+// TestWithTransform 测试缓存数据转换
+func TestWithTransform(t *testing.T) {
+	type user struct {
+		Name     string
+		Password string
+	}
+
+	ctx := context.Background()
+	rds, c := getRedis()
+	defer c()
+
+	for _, store := range []Store{NewMemoryStore(), rds} {
+		ctr := NewCacheController[*user]("test-transform", store, WithTransform[*user](
+			func(u *user) *user {
+				if u == nil {
+					return nil
+				}
+				return &user{Name: u.Name}
+			},
+			func(u *user) *user {
+				return &user{Name: "load:" + u.Name}
+			},
+		))
+		_ = store.Del(ctx, "key")
+
+		// 本次调用方拿到完整数据
+		res, err := ctr.Wrap(ctx, "key", func(ctx context.Context) (*user, error) {
+			return &user{Name: "wheat", Password: "secret"}, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, &user{Name: "wheat", Password: "secret"}, res)
+
+		// 缓存中的数据已经剔除敏感字段, 读取时执行 onLoad
+		res, err = ctr.Wrap(ctx, "key", func(ctx context.Context) (*user, error) {
+			return nil, errors.New("should not query")
+		})
+		require.NoError(t, err)
+		require.Equal(t, &user{Name: "load:wheat"}, res)
+
+		// onLoad 不会修改直接存储中的数据
+		res, _, err = ctr.GetStore(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, &user{Name: "load:wheat"}, res)
+	}
+}
+
+// TestWrapCacheable 测试查询结果控制是否写入缓存
+func TestWrapCacheable(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	ctr := NewCacheController[int]("test-cacheable", store)
+
+	// 不允许缓存, 结果正常返回但不写入缓存
+	res, err := ctr.WrapCacheable(ctx, "key", func(ctx context.Context) (int, bool, error) {
+		return 1, false, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+	_, err = store.Get(ctx, "key")
+	require.ErrorIs(t, err, ErrKeyNonExistent)
+
+	// 允许缓存
+	res, err = ctr.WrapCacheable(ctx, "key", func(ctx context.Context) (int, bool, error) {
+		return 2, true, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, res)
+	cached, _, err := ctr.GetStore(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, 2, cached)
+}
+
+// TestWithTTLOverride 测试上下文覆盖 ttl
+func TestWithTTLOverride(t *testing.T) {
+	lc := getTestLocalCache()
+	store := NewCacheStore(lc)
+	ctx := WithTTLOverride(context.Background(), time.Second)
+	query := func(ctx context.Context) (int, error) { return 1, nil }
+
+	// EasyPloy 使用覆盖的 ttl
+	ctr := NewCacheController[int]("test-ttl-override", store, WithPolicy[int](EasyPloy(time.Hour)))
+	_, err := ctr.Wrap(ctx, "easy", query)
+	require.NoError(t, err)
+	_, expiration, ok := lc.GetWithExpiration("easy")
+	require.True(t, ok)
+	require.WithinDuration(t, time.Now().Add(time.Second), expiration, 500*time.Millisecond)
+
+	// ReuseCache 使用 KeepTTL, 忽略覆盖的 ttl
+	ctr = NewCacheController[int]("test-ttl-override", store, WithPolicy[int](ReuseCachePloyIgnoreError(time.Hour)))
+	_, err = ctr.Wrap(ctx, "reuse", query)
+	require.NoError(t, err)
+	_, expiration, ok = lc.GetWithExpiration("reuse")
+	require.True(t, ok)
+	require.True(t, expiration.IsZero())
+}
+
+// TestClear 测试清空存储
+func TestClear(t *testing.T) {
+	ctx := context.Background()
+	rds, cleanup := getTestRedis()
+	defer cleanup()
+	_, hashStore := NewRedisHashStore(ctx, rds, "test-clear", "field")
+
+	for _, store := range []Store{NewCacheStore(getTestLocalCache()), NewMemoryStore(), hashStore} {
+		require.NoError(t, store.Set(ctx, "key", "value", time.Minute))
+		require.NoError(t, Clear(ctx, store))
+		_, err := store.Get(ctx, "key")
+		require.ErrorIs(t, err, ErrKeyNonExistent)
+	}
+
+	// 不支持清空的存储
+	require.ErrorIs(t, Clear(ctx, NewRedisStore(rds)), ErrUnsupported)
+}
+
+// TestTouch 测试延长缓存过期时间
+func TestTouch(t *testing.T) {
+	ctx := context.Background()
+	rds, cleanup := getRedis()
+	defer cleanup()
+
+	for _, store := range []Store{NewCacheStore(getTestLocalCache()), NewMemoryStore(), rds} {
+		ctr := NewCacheController[int]("test-touch", store)
+		_ = store.Del(ctx, "key")
+
+		// 缓存键不存在
+		require.ErrorIs(t, ctr.Touch(ctx, "key", time.Minute), ErrKeyNonExistent)
+
+		require.NoError(t, ctr.SetStore(ctx, "key", 1, 100*time.Millisecond))
+		require.NoError(t, ctr.Touch(ctx, "key", time.Minute))
+		require.NoError(t, ctr.Touch(ctx, "key", KeepTTL))
+		time.Sleep(150 * time.Millisecond)
+
+		// 延长后依然可以读取
+		res, _, err := ctr.GetStore(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, 1, res)
+	}
+
+	// 不支持延长过期时间的存储
+	ctr := NewCacheController[int]("test-touch", NewNoopStore())
+	require.ErrorIs(t, ctr.Touch(ctx, "key", time.Minute), ErrUnsupported)
+}
+
+// TestFirstCacheShardHasher 测试自定义分片哈希方法
+func TestFirstCacheShardHasher(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	var hashed int64
+	ctr := NewCacheController[int]("test-shard-hasher", store, WithPolicy[int](FirstCachePolyIgnoreError(time.Second,
+		WithShardCount(1024),
+		WithShardHasher(func(key string) uint {
+			atomic.AddInt64(&hashed, 1)
+			return HashFnv1aToUint(key)
+		}),
+	)))
+
+	// 写入已过期的缓存数据, 触发后台刷新
+	_ = store.Set(ctx, "key", &AbcBox[int]{T: 1, Timestamp: int(time.Now().Add(-time.Minute).Unix())}, KeepTTL)
+	res, err := ctr.Wrap(ctx, "key", func(ctx context.Context) (int, error) { return 2, nil })
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+	require.Equal(t, int64(1), atomic.LoadInt64(&hashed))
+	require.Eventually(t, func() bool {
+		res, _, err := ctr.GetStore(ctx, "key")
+		return err == nil && res == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestStats 测试控制器统计
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	ctr := NewCacheController[int]("test-stats", NewMemoryStore())
+
+	// 未命中 -> query, 命中
+	_, err := ctr.Wrap(ctx, "key", func(ctx context.Context) (int, error) { return 1, nil })
+	require.NoError(t, err)
+	_, err = ctr.Wrap(ctx, "key", func(ctx context.Context) (int, error) { return 1, nil })
+	require.NoError(t, err)
+	// query 失败
+	_, err = ctr.Wrap(ctx, "err", func(ctx context.Context) (int, error) { return 0, errors.New("test error") })
+	require.Error(t, err)
+	require.Equal(t, Stats{Hits: 1, Misses: 2, QueryCalls: 2, QueryErrors: 1}, ctr.Stats())
+
+	// 并发请求通过 singleflight 合并
+	wg := sync.WaitGroup{}
+	release := make(chan struct{})
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = ctr.Wrap(ctx, "shared", func(ctx context.Context) (int, error) {
+				<-release
+				return 1, nil
+			})
+		}()
+	}
+	require.Eventually(t, func() bool { return ctr.Stats().Misses == 7 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	stats := ctr.Stats()
+	require.Equal(t, uint64(5), stats.QueryCalls-2+stats.SharedCalls)
+}
+
+// TestWriteThrough 测试写穿透
+func TestWriteThrough(t *testing.T) {
+	ctx := context.Background()
+	ctr := NewCacheController[int]("test-write-through", NewMemoryStore())
+	require.NoError(t, ctr.SetStore(ctx, "key", 1, time.Minute))
+
+	// 写入数据源失败, 缓存不变
+	testErr := errors.New("test error")
+	err := ctr.WriteThrough(ctx, "key", 2, time.Minute, func(ctx context.Context, value int) error {
+		return testErr
+	})
+	require.ErrorIs(t, err, testErr)
+	res, _, err := ctr.GetStore(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+
+	// 写入数据源成功, 更新缓存
+	var persisted int
+	err = ctr.WriteThrough(ctx, "key", 3, time.Minute, func(ctx context.Context, value int) error {
+		persisted = value
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, persisted)
+	res, _, err = ctr.GetStore(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, 3, res)
+}
+
+// TestDelAndSet 测试删除关联缓存并写入
+func TestDelAndSet(t *testing.T) {
+	ctx := context.Background()
+	rds, closer := getRedis()
+	defer closer()
+
+	for _, store := range []Store{NewMemoryStore(), rds} {
+		ctr := NewCacheController[int]("test-del-and-set", store)
+		require.NoError(t, ctr.SetStore(ctx, "key", 1, time.Minute))
+		require.NoError(t, ctr.SetStore(ctx, "list", 1, time.Minute))
+
+		err := ctr.WriteThrough(ctx, "key", 2, time.Minute, func(ctx context.Context, value int) error { return nil }, "list")
+		require.NoError(t, err)
+		res, _, err := ctr.GetStore(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, 2, res)
+		_, _, err = ctr.GetStore(ctx, "list")
+		require.ErrorIs(t, err, ErrKeyNonExistent)
+	}
+}
+
+func TestConditionalSet(t *testing.T) {
+	ctx := context.Background()
+	store := NewCacheStore(getTestLocalCache())
+	ctr := NewCacheController[int]("test-conditional-set", store)
+
+	// 慢查询期间其他请求写入了更新的数据, query 结果不会覆盖
+	res, err := ctr.Wrap(ctx, "key", func(ctx context.Context) (int, error) {
+		newer := &AbcBox[int]{Timestamp: int(time.Now().Unix()) + 1, T: 2}
+		require.NoError(t, store.Set(ctx, "key", newer, time.Minute))
+		return 1, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+
+	res, _, err = ctr.GetStore(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, 2, res)
+}
+
+type testValueUser struct {
+	Name string
+}
+
+type testValueNamer interface {
+	GetName() string
+}
+
+func (u *testValueUser) GetName() string {
+	return u.Name
+}
+
+func TestWithValueFactory(t *testing.T) {
+	ctx := context.Background()
+	store, closer := getRedis()
+	defer closer()
+
+	// 未设置时 any 解码为 map
+	ctr := NewCacheController[any]("test-value-factory", store)
+	require.NoError(t, ctr.SetStore(ctx, "user", &testValueUser{Name: "wheat"}, time.Minute))
+	res, _, err := ctr.GetStore(ctx, "user")
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"Name": "wheat"}, res)
+
+	// 设置后解码为具体类型
+	ctr = NewCacheController("test-value-factory", store, WithValueFactory(func() any { return new(testValueUser) }))
+	res, _, err = ctr.GetStore(ctx, "user")
+	require.NoError(t, err)
+	require.Equal(t, &testValueUser{Name: "wheat"}, res)
+
+	// 非空接口未设置时返回 ErrUnpackingFailed
+	namerCtr := NewCacheController[testValueNamer]("test-value-factory", store)
+	_, _, err = namerCtr.GetStore(ctx, "user")
+	require.ErrorIs(t, err, ErrUnpackingFailed)
+	require.ErrorContains(t, err, "WithValueFactory")
+
+	namerCtr = NewCacheController("test-value-factory", store,
+		WithValueFactory(func() testValueNamer { return new(testValueUser) }))
+	namer, _, err := namerCtr.GetStore(ctx, "user")
+	require.NoError(t, err)
+	require.Equal(t, "wheat", namer.GetName())
+}
+
+// TestBypassRatio 测试按比例跳过缓存读取
+func TestBypassRatio(t *testing.T) {
+	ctx := context.Background()
+	ctr := NewCacheController[int]("test-bypass-ratio", NewMemoryStore(), WithPolicy[int](EasyPloy(time.Minute)))
+	require.NoError(t, ctr.SetStore(ctx, "key", 1, time.Minute))
+
+	// 比例为 0 读取缓存
+	res, err := ctr.Wrap(WithBypassRatio(ctx, 0), "key", func(ctx context.Context) (int, error) { return 2, nil })
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+
+	// 比例为 1 总是回源, 结果写入缓存
+	res, err = ctr.Wrap(WithBypassRatio(ctx, 1), "key", func(ctx context.Context) (int, error) { return 2, nil })
+	require.NoError(t, err)
+	require.Equal(t, 2, res)
+	res, _, err = ctr.GetStore(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, 2, res)
+}
+
+// TestRefreshWorkers 测试后台刷新协程池限制并发
+func TestRefreshWorkers(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	ctr := NewCacheController[int]("test-refresh-workers", store,
+		WithPolicy[int](FirstCachePolyIgnoreError(time.Second, WithRefreshWorkers(1, 1))),
+	)
+
+	// 写入已过期的缓存数据
+	expired := int(time.Now().Add(-time.Minute).Unix())
+	for _, key := range []string{"a", "b", "c"} {
+		_ = store.Set(ctx, key, &AbcBox[int]{T: 1, Timestamp: expired}, KeepTTL)
+	}
+
+	var queryCount int64
+	release := make(chan struct{})
+	query := func(ctx context.Context) (int, error) {
+		atomic.AddInt64(&queryCount, 1)
+		<-release
+		return 2, nil
+	}
+
+	// 唯一的刷新协程被占用
+	res, err := ctr.Wrap(ctx, "a", query)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&queryCount) == 1 }, time.Second, time.Millisecond)
+
+	// 刷新排队, 队列已满后丢弃刷新, 都返回过期数据
+	for _, key := range []string{"b", "c"} {
+		res, err = ctr.Wrap(ctx, key, query)
+		require.NoError(t, err)
+		require.Equal(t, 1, res)
+	}
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, int64(1), atomic.LoadInt64(&queryCount))
+
+	// 协程空闲后执行排队的刷新, 被丢弃的刷新在下次请求时重新提交
+	close(release)
+	require.Eventually(t, func() bool {
+		res, _, err := ctr.GetStore(ctx, "b")
+		return err == nil && res == 2
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, int64(2), atomic.LoadInt64(&queryCount))
+	require.Eventually(t, func() bool {
+		_, _ = ctr.Wrap(ctx, "c", query)
+		res, _, err := ctr.GetStore(ctx, "c")
+		return err == nil && res == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestReuseTimeout 测试重用缓存模型限制等待 query 的时间
+func TestReuseTimeout(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	ctr := NewCacheController[int]("test-reuse-timeout", store,
+		WithPolicy[int](ReuseCachePloyWithTimeout(time.Second, 20*time.Millisecond)),
+	)
+	_ = store.Set(ctx, "key", &AbcBox[int]{T: 1, Timestamp: int(time.Now().Add(-time.Minute).Unix())}, KeepTTL)
+
+	// query 超时返回过期数据, query 在后台继续执行并更新缓存
+	release := make(chan struct{})
+	start := time.Now()
+	res, err := ctr.Wrap(ctx, "key", func(ctx context.Context) (int, error) {
+		<-release
+		return 2, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+
+	close(release)
+	require.Eventually(t, func() bool {
+		res, _, err := ctr.GetStore(ctx, "key")
+		return err == nil && res == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// 没有缓存数据时依然同步等待 query
+	res, err = ctr.Wrap(ctx, "cold", func(ctx context.Context) (int, error) {
+		time.Sleep(50 * time.Millisecond)
+		return 3, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, res)
+
+	// 并发返回过期数据的调用共享一次后台 query
+	_ = store.Set(ctx, "key", &AbcBox[int]{T: 1, Timestamp: int(time.Now().Add(-time.Minute).Unix())}, KeepTTL)
+	release = make(chan struct{})
+	var calls atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := ctr.Wrap(ctx, "key", func(ctx context.Context) (int, error) {
+				calls.Add(1)
+				<-release
+				return 4, nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, 1, res)
+		}()
+	}
+	wg.Wait()
+	close(release)
+	require.Eventually(t, func() bool {
+		res, _, err := ctr.GetStore(ctx, "key")
+		return err == nil && res == 4
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, int32(1), calls.Load())
+}
+
+// TestDetachContext 测试后台刷新的上下文不继承调用方的不等待和超时设置
+func TestDetachContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(WithQueryTimeout(context.WithValue(context.Background(), ctxNoWaitKey{}, true), time.Millisecond))
+	nCtx := detachContext(ctx)
+	cancel()
+	require.NoError(t, nCtx.Err())
+
+	// 相同 key 正在执行时等待结果, 不返回 ErrQueryInFlight, 也不使用调用方的超时
+	sg := SingleflightGroup{}
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go func() {
+		_, _, _ = sg.Do(context.Background(), "key", func() (any, error) {
+			close(started)
+			<-release
+			return 1, nil
+		})
+	}()
+	<-started
+	time.AfterFunc(20*time.Millisecond, func() { close(release) })
+	value, err, _ := sg.Do(nCtx, "key", func() (any, error) { return 2, nil })
+	require.NoError(t, err)
+	require.Equal(t, 1, value)
+}
+
+// TestWrapWithPrev 测试 query 获取缓存中的旧数据
+func TestWrapWithPrev(t *testing.T) {
+	ctx := context.Background()
+	ctr := NewCacheController[int]("test-wrap-prev", NewMemoryStore(), WithPolicy[int](ReuseCachePloyIgnoreError(time.Minute)))
+
+	// 缓存缺失时没有旧数据
+	res, err := ctr.WrapWithPrev(ctx, "key", func(ctx context.Context, prev int, hasPrev bool) (int, error) {
+		require.False(t, hasPrev)
+		require.Zero(t, prev)
+		return 1, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+
+	// 缓存过期后 query 获取旧数据和写入时间
+	_ = ctr.store.Set(ctx, "key", &AbcBox[int]{T: 1, Timestamp: 100}, KeepTTL)
+	meta := &BoxMeta{}
+	res, err = ctr.WrapWithPrev(WithBoxMeta(ctx, meta), "key", func(ctx context.Context, prev int, hasPrev bool) (int, error) {
+		require.True(t, hasPrev)
+		return prev + 1, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, res)
+	require.Equal(t, 100, meta.Timestamp)
+}
+
+// TestStrictTyping 测试直接存储类型断言失败
+func TestStrictTyping(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	require.NoError(t, NewCacheController[string]("test-strict", store).SetStore(ctx, "key", "value", time.Minute))
+
+	// 默认视为未命中, query 重新写入
+	ctr := NewCacheController[int]("test-strict", store)
+	_, _, err := ctr.GetStore(ctx, "key")
+	require.ErrorIs(t, err, ErrKeyNonExistent)
+	res, err := ctr.Wrap(ctx, "key", func(ctx context.Context) (int, error) { return 1, nil })
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+
+	// 严格模式返回 ErrUnpackingFailed
+	strictCtr := NewCacheController("test-strict", store, WithStrictTyping[string](true), WithReadRepair[string](true))
+	_, _, err = strictCtr.GetStore(ctx, "key")
+	require.ErrorIs(t, err, ErrUnpackingFailed)
+
+	// 类型不匹配不是数据损坏, 不会被损坏数据修复删除
+	_, _ = strictCtr.Wrap(ctx, "key", func(ctx context.Context) (string, error) { return "", errors.New("query failed") })
+	got, _, err := ctr.GetStore(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, 1, got)
+}
+
+// TestWithValidator 测试校验失败的缓存视为未命中
+func TestWithValidator(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	var hookErr error
+	ctr := NewCacheController[int]("test-validator", store,
+		WithValidator(func(v int) error {
+			if v < 0 {
+				return errors.New("negative value")
+			}
+			return nil
+		}),
+		WithOnError[int](func(ctx context.Context, key string, err error) { hookErr = err }),
+	)
+	require.NoError(t, ctr.SetStore(ctx, "key", -1, time.Minute))
+
+	var calls int
+	query := func(ctx context.Context) (int, error) {
+		calls++
+		return 1, nil
+	}
+	res, err := ctr.Wrap(ctx, "key", query)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+	require.Equal(t, 1, calls)
+	require.ErrorIs(t, hookErr, ErrInvalidValue)
+	require.ErrorContains(t, hookErr, "test-validator")
+
+	// query 重新写入后命中缓存
+	res, err = ctr.Wrap(ctx, "key", query)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+	require.Equal(t, 1, calls)
+}
+
+// TestWithFallback 测试缓存和 query 都失败时返回默认值
+func TestWithFallback(t *testing.T) {
+	ctx := context.Background()
+	testErr := errors.New("test error")
+	var hookErr error
+	ctr := NewCacheController("test-fallback", NewMemoryStore(),
+		WithFallback(func() []int { return []int{} }),
+		WithOnError[[]int](func(ctx context.Context, key string, err error) { hookErr = err }),
+	)
+
+	res, err := ctr.Wrap(ctx, "key", func(ctx context.Context) ([]int, error) { return nil, testErr })
+	require.NoError(t, err)
+	require.Equal(t, []int{}, res)
+	require.ErrorIs(t, hookErr, testErr)
+
+	// 默认值不写入缓存
+	_, _, err = ctr.GetStore(ctx, "key")
+	require.ErrorIs(t, err, ErrKeyNonExistent)
+}
+
+// TestTryWrap 测试不等待正在执行的 query
+func TestTryWrap(t *testing.T) {
+	ctx := context.Background()
+	ctr := NewCacheController[int]("test-try-wrap", NewMemoryStore(), WithFallback(func() int { return -1 }))
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = ctr.Wrap(ctx, "key", func(ctx context.Context) (int, error) {
+			close(started)
+			<-release
+			return 1, nil
+		})
+	}()
+	<-started
+
+	// query 正在执行, 立即返回
+	res, ok, err := ctr.TryWrap(ctx, "key", func(ctx context.Context) (int, error) { return 2, nil })
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Zero(t, res)
+
+	// 命中缓存
+	close(release)
+	<-done
+	res, ok, err = ctr.TryWrap(ctx, "key", func(ctx context.Context) (int, error) { return 2, nil })
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 1, res)
+
+	// 没有正在执行的 query 时执行 query
+	res, ok, err = ctr.TryWrap(ctx, "other", func(ctx context.Context) (int, error) { return 3, nil })
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 3, res)
+}
+
+// TestRemainingTTL 测试获取剩余过期时间
+func TestRemainingTTL(t *testing.T) {
+	ctx := context.Background()
+	for _, store := range []Store{NewCacheStore(getTestLocalCache()), NewMemoryStore()} {
+		ctr := NewCacheController[int]("test-remaining-ttl", store)
+		_, err := ctr.RemainingTTL(ctx, "key")
+		require.ErrorIs(t, err, ErrKeyNonExistent)
+
+		require.NoError(t, ctr.SetStore(ctx, "key", 1, time.Minute))
+		ttl, err := ctr.RemainingTTL(ctx, "key")
+		require.NoError(t, err)
+		require.InDelta(t, time.Minute, ttl, float64(time.Second))
+
+		require.NoError(t, ctr.SetStore(ctx, "key", 1, KeepTTL))
+		ttl, err = ctr.RemainingTTL(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, time.Duration(KeepTTL), ttl)
+	}
+
+	_, err := NewCacheController[int]("test-remaining-ttl", NewNoopStore()).RemainingTTL(ctx, "key")
+	require.ErrorIs(t, err, ErrUnsupported)
+}
+
+// TestClockSkew 测试未来和零时间戳的数据
+func TestClockSkew(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	policies := map[string]Policy{
+		"reuse": ReuseCachePloyIgnoreError(time.Minute),
+		"first": FirstCachePolyIgnoreError(time.Minute),
+	}
+	for name, policy := range policies {
+		t.Run(name, func(t *testing.T) {
+			store := NewMemoryStore()
+			ctr := NewCacheController[int]("test-clock-skew", store, WithPolicy[int](policy))
+			var queryCount int64
+			query := func(ctx context.Context) (int, error) {
+				atomic.AddInt64(&queryCount, 1)
+				return 2, nil
+			}
+
+			// 轻微超前的时间戳视为新鲜
+			_ = store.Set(ctx, "small", &AbcBox[int]{T: 1, Timestamp: int(now.Add(10 * time.Second).Unix())}, KeepTTL)
+			res, err := ctr.Wrap(ctx, "small", query)
+			require.NoError(t, err)
+			require.Equal(t, 1, res)
+			require.Equal(t, int64(0), atomic.LoadInt64(&queryCount))
+
+			// 超前超过业务过期时间的时间戳视为过期
+			_ = store.Set(ctx, "future", &AbcBox[int]{T: 1, Timestamp: int(now.Add(time.Hour).Unix())}, KeepTTL)
+			_, err = ctr.Wrap(ctx, "future", query)
+			require.NoError(t, err)
+			require.Eventually(t, func() bool { return atomic.LoadInt64(&queryCount) == 1 }, time.Second, time.Millisecond)
+
+			// 零时间戳视为过期
+			_ = store.Set(ctx, "zero", &AbcBox[int]{T: 1, Timestamp: 0}, KeepTTL)
+			_, err = ctr.Wrap(ctx, "zero", query)
+			require.NoError(t, err)
+			require.Eventually(t, func() bool { return atomic.LoadInt64(&queryCount) == 2 }, time.Second, time.Millisecond)
+		})
+	}
+}
+
+// TestWrapChan 测试通过 channel 获取结果
+func TestWrapChan(t *testing.T) {
+	ctx := context.Background()
+	ctr := NewCacheController[int]("test-wrap-chan", NewMemoryStore())
+
+	var queryCount int64
+	release := make(chan struct{})
+	query := func(ctx context.Context) (int, error) {
+		atomic.AddInt64(&queryCount, 1)
+		<-release
+		return 1, nil
+	}
+
+	// 并发调用共享结果
+	ch1 := ctr.WrapChan(ctx, "key", query)
+	ch2 := ctr.WrapChan(ctx, "key", query)
+	close(release)
+	res1, res2 := <-ch1, <-ch2
+	require.NoError(t, res1.Err)
+	require.Equal(t, 1, res1.Value)
+	require.Equal(t, 1, res2.Value)
+	require.True(t, res1.Shared && res2.Shared)
+	require.Equal(t, int64(1), atomic.LoadInt64(&queryCount))
+
+	// ctx 取消时立即返回
+	cancelCtx, cancel := context.WithCancel(ctx)
+	ch := ctr.WrapChan(cancelCtx, "hang", func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	cancel()
+	select {
+	case res := <-ch:
+		require.ErrorIs(t, res.Err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("WrapChan not return after cancel")
+	}
+}
+
+// TestPolicyByName 测试根据名称创建策略
+func TestPolicyByName(t *testing.T) {
+	for _, name := range []string{"easy", "Reuse", " first "} {
+		policy, err := PolicyByName(name, time.Minute)
+		require.NoError(t, err)
+		ctr := NewCacheController[int]("test-policy-name", NewMemoryStore(), WithPolicy[int](policy))
+		res, err := ctr.Wrap(context.Background(), "key", func(ctx context.Context) (int, error) { return 1, nil })
+		require.NoError(t, err)
+		require.Equal(t, 1, res)
+	}
+
+	_, err := PolicyByName("unknown", time.Minute)
+	require.ErrorIs(t, err, ErrUnknownPolicy)
+	require.ErrorContains(t, err, `"unknown"`)
+}
+
+// TestRefreshPanic 测试后台刷新 panic 不会导致进程退出, 并且释放分片锁
+func TestRefreshPanic(t *testing.T) {
+	ctx := context.Background()
+	panics := make(chan any, 2)
+	SetPanicHandler(func(recovered any, stack []byte) {
+		require.NotEmpty(t, stack)
+		panics <- recovered
+	})
+	defer SetPanicHandler(nil)
+
+	ctr := NewCacheController[int]("test-refresh-panic", NewMemoryStore(),
+		WithPolicy[int](FirstCachePolyIgnoreError(time.Millisecond)))
+	_, err := ctr.Wrap(ctx, "key", func(ctx context.Context) (int, error) { return 1, nil })
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	query := func(ctx context.Context) (int, error) { panic("refresh panic") }
+	for i := 0; i < 2; i++ {
+		res, err := ctr.Wrap(ctx, "key", query)
+		require.NoError(t, err)
+		require.Equal(t, 1, res)
+		select {
+		case r := <-panics:
+			require.Equal(t, "refresh panic", r)
+		case <-time.After(time.Second):
+			t.Fatal("panic handler not called")
+		}
+	}
+}
+
+// TestWithErrorCache 测试缓存 query 错误
+func TestWithErrorCache(t *testing.T) {
+	ctx := context.Background()
+	validationErr := errors.New("invalid argument")
+	otherErr := errors.New("timeout")
+
+	rds, closer := getRedis()
+	defer closer()
+	for _, store := range []Store{NewMemoryStore(), rds} {
+		ctr := NewCacheController[string]("test-error-cache", store,
+			WithErrorCache[string](50*time.Millisecond, func(err error) bool { return errors.Is(err, validationErr) }))
+
+		var calls int
+		query := func(err error) Query[string] {
+			return func(ctx context.Context) (string, error) {
+				calls++
+				return "", err
+			}
+		}
+
+		// 不可缓存的错误每次都执行 query
+		_, err := ctr.Wrap(ctx, "other", query(otherErr))
+		require.ErrorIs(t, err, otherErr)
+		_, err = ctr.Wrap(ctx, "other", query(otherErr))
+		require.ErrorIs(t, err, otherErr)
+		require.Equal(t, 2, calls)
+
+		// 可缓存的错误在 ttl 内直接返回
+		calls = 0
+		_, err = ctr.Wrap(ctx, "key", query(validationErr))
+		require.ErrorIs(t, err, validationErr)
+		_, err = ctr.Wrap(ctx, "key", query(validationErr))
+		var cachedErr *CachedError
+		require.ErrorAs(t, err, &cachedErr)
+		require.Equal(t, validationErr.Error(), cachedErr.Msg)
+		require.Equal(t, 1, calls)
+		_, _, err = ctr.GetStore(ctx, "key")
+		require.ErrorAs(t, err, &cachedErr)
+	}
+
+	// 过期后重新执行 query
+	ctr := NewCacheController[string]("test-error-cache", NewMemoryStore(), WithErrorCache[string](50*time.Millisecond, nil))
+	_, err := ctr.Wrap(ctx, "key", func(ctx context.Context) (string, error) { return "", validationErr })
+	require.ErrorIs(t, err, validationErr)
+	time.Sleep(60 * time.Millisecond)
+	res, err := ctr.Wrap(ctx, "key", func(ctx context.Context) (string, error) { return "ok", nil })
+	require.NoError(t, err)
+	require.Equal(t, "ok", res)
+
+	// 已经存在的数据不会被错误覆盖, 策略可以继续重用旧数据
+	ctr = NewCacheController[string]("test-error-cache", NewMemoryStore(),
+		WithPolicy[string](ReuseCachePloyIgnoreError(10*time.Millisecond)), WithErrorCache[string](time.Minute, nil))
+	require.NoError(t, ctr.SetStore(ctx, "key", "old", KeepTTL))
+	time.Sleep(20 * time.Millisecond)
+	res, err = ctr.Wrap(ctx, "key", func(ctx context.Context) (string, error) { return "", validationErr })
+	require.NoError(t, err)
+	require.Equal(t, "old", res)
+	box, err := ctr.GetBox(ctx, "key")
+	require.NoError(t, err)
+	require.Empty(t, box.Err)
+	require.Equal(t, "old", box.T)
+}
+
+// TestWithBackstopTTL 测试永久存储使用兜底过期时间
+func TestWithBackstopTTL(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	query := func(ctx context.Context) (int, error) { return 1, nil }
+
+	// 默认永久存储
+	ctr := NewCacheController[int]("test-backstop", store, WithPolicy[int](ReuseCachePloyIgnoreError(time.Minute)))
+	_, err := ctr.Wrap(ctx, "keep", query)
+	require.NoError(t, err)
+	ttl, err := ctr.RemainingTTL(ctx, "keep")
+	require.NoError(t, err)
+	require.Equal(t, time.Duration(KeepTTL), ttl)
+
+	ctr = NewCacheController[int]("test-backstop", store, WithPolicy[int](ReuseCachePloyIgnoreError(time.Minute)),
+		WithBackstopTTL[int](3*time.Minute))
+	_, err = ctr.Wrap(ctx, "backstop", query)
+	require.NoError(t, err)
+	ttl, err = ctr.RemainingTTL(ctx, "backstop")
+	require.NoError(t, err)
+	require.Greater(t, ttl, 2*time.Minute)
+	require.LessOrEqual(t, ttl, 3*time.Minute+18*time.Second)
+
+	// 非永久存储不受影响
+	require.NoError(t, ctr.SetStore(ctx, "ttl", 1, time.Minute))
+	ttl, err = ctr.RemainingTTL(ctx, "ttl")
+	require.NoError(t, err)
+	require.LessOrEqual(t, ttl, time.Minute)
+}
+
+// TestIsNil 测试标量快速路径和反射判断结果一致
+func TestIsNil(t *testing.T) {
+	var nilPtr *int
+	var nilSlice []int
+	var nilBytes []byte
+	for _, v := range []any{int64(0), 1.5, "", false, nilBytes, nilSlice, map[string]int(nil), 1} {
+		require.False(t, isNil(v), "%T", v)
+	}
+	require.True(t, isNil(nil))
+	require.True(t, isNil(nilPtr))
+	require.False(t, isNil(new(int)))
+}
+
+// TestPoisonRepair 测试删除无法拆箱的损坏数据并重新写入
+func TestPoisonRepair(t *testing.T) {
+	ctx := context.Background()
+	store, closer := getRedis()
+	defer closer()
+	testErr := errors.New("query failed")
+
+	for _, repair := range []bool{true, false} {
+		var poisonCount int
+		ctr := NewCacheController[int]("test-poison", store, WithPoisonRepair[int](repair),
+			WithOnError[int](func(ctx context.Context, key string, err error) {
+				if errors.Is(err, ErrUnpackingFailed) {
+					poisonCount++
+				}
+			}))
+
+		// query 失败时, 开启修复会删除损坏数据, 只记录一次
+		require.NoError(t, store.Set(ctx, "key", `{"T": "bad"`, time.Minute))
+		for i := 0; i < 2; i++ {
+			_, err := ctr.Wrap(ctx, "key", func(ctx context.Context) (int, error) { return 0, testErr })
+			require.ErrorIs(t, err, testErr)
+		}
+		_, err := store.Get(ctx, "key")
+		if repair {
+			require.Equal(t, 1, poisonCount)
+			require.ErrorIs(t, err, ErrKeyNonExistent)
+		} else {
+			require.Equal(t, 2, poisonCount)
+			require.NoError(t, err)
+		}
+
+		// query 成功后重新写入
+		res, err := ctr.Wrap(ctx, "key", func(ctx context.Context) (int, error) { return 1, nil })
+		require.NoError(t, err)
+		require.Equal(t, 1, res)
+		got, _, err := ctr.GetStore(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, 1, got)
+		require.Zero(t, ctr.poisonedN.Load())
+	}
+}
+
+// TestWrapDefault 测试使用包级默认存储
+func TestWrapDefault(t *testing.T) {
+	ctx := context.Background()
+	query := func(ctx context.Context) (int, error) { return 1, nil }
+	defer SetDefaultStore(nil)
+
+	_, err := WrapDefault(ctx, "test-wrap-default", "key", query)
+	require.ErrorIs(t, err, ErrNoDefaultStore)
+
+	store := NewMemoryStore()
+	SetDefaultStore(store)
+	require.Equal(t, store, DefaultStore())
+	res, err := WrapDefault(ctx, "test-wrap-default", "key", query)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+
+	got, _, err := GetStore[int](ctx, store, "key")
+	require.NoError(t, err)
+	require.Equal(t, 1, got)
+}
+
+// TestReuseCachePloyWithMaxStale 测试超过最大时长的数据不再重用
+func TestReuseCachePloyWithMaxStale(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	testErr := errors.New("query failed")
+	ctr := NewCacheController[int]("test-max-stale", store,
+		WithPolicy[int](ReuseCachePloyWithMaxStale(10*time.Second, time.Minute)))
+	query := func(ctx context.Context) (int, error) { return 0, testErr }
+
+	// 未超过最大时长, 重用数据
+	now := int(time.Now().Unix())
+	require.NoError(t, store.Set(ctx, "stale", &AbcBox[int]{T: 1, Timestamp: now - 30}, KeepTTL))
+	res, err := ctr.Wrap(ctx, "stale", query)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+
+	// 超过最大时长, 返回 query 错误
+	require.NoError(t, store.Set(ctx, "expired", &AbcBox[int]{T: 1, Timestamp: now - 120}, KeepTTL))
+	_, err = ctr.Wrap(ctx, "expired", query)
+	require.ErrorIs(t, err, testErr)
+}
+
+// TestExists 测试判断缓存是否存在
+func TestExists(t *testing.T) {
+	ctx := context.Background()
+	rds, cleanup := getTestRedis()
+	defer cleanup()
+
+	stores := []Store{NewMemoryStore(), NewCacheStore(getTestLocalCache()), NewRedisStore(rds), NewRedisHashFieldStore(rds, "exists")}
+	for _, store := range stores {
+		ctr := NewCacheController[int]("test-exists", store)
+		exists, err := ctr.Exists(ctx, "key")
+		require.NoError(t, err)
+		require.False(t, exists, "%T", store)
+
+		require.NoError(t, ctr.SetStore(ctx, "key", 1, time.Minute))
+		exists, err = ctr.Exists(ctx, "key")
+		require.NoError(t, err)
+		require.True(t, exists, "%T", store)
+	}
+}
+
+// TestComputeMsCompat 测试没有 ComputeMs 字段的旧数据可以读取, 并且非直接存储会记录 query 执行耗时
+func TestComputeMsCompat(t *testing.T) {
+	ctx := context.Background()
+	store, closer := getRedis()
+	defer closer()
+	ctr := NewCacheController[int]("test-compute-ms", store)
+
+	// 旧数据没有 ComputeMs 字段, 默认为 0
+	now := int(time.Now().Unix())
+	require.NoError(t, store.Set(ctx, "legacy", fmt.Sprintf(`{"Timestamp":%d,"T":1}`, now), time.Minute))
+	box, err := ctr.getBox(ctx, "legacy")
+	require.NoError(t, err)
+	require.Equal(t, 1, box.T)
+	require.Equal(t, int64(0), box.ComputeMs)
+
+	// 编码后的数据包含 ComputeMs
+	_, err = ctr.Wrap(ctx, "key", func(ctx context.Context) (int, error) {
+		time.Sleep(5 * time.Millisecond)
+		return 2, nil
+	})
+	require.NoError(t, err)
+	box, err = ctr.getBox(ctx, "key")
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, box.ComputeMs, int64(5))
+}
+
+// TestAsyncRefreshPloy 测试异步刷新策略
+func TestAsyncRefreshPloy(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	ctr := NewCacheController[int]("test-async-refresh", store, WithPolicy[int](AsyncRefreshPloy(time.Minute)))
+
+	var calls atomic.Int64
+	release := make(chan struct{})
+	query := func(ctx context.Context) (int, error) {
+		calls.Add(1)
+		<-release
+		return 2, nil
+	}
+
+	// 过期的缓存立即返回, 后台刷新
+	require.NoError(t, store.Set(ctx, "key", &AbcBox[int]{T: 1, Timestamp: int(time.Now().Add(-time.Hour).Unix())}, KeepTTL))
+	for i := 0; i < 3; i++ {
+		res, err := ctr.Wrap(ctx, "key", query)
+		require.NoError(t, err)
+		require.Equal(t, 1, res)
+	}
+	close(release)
+	require.Eventually(t, func() bool {
+		res, _, err := ctr.GetStore(ctx, "key")
+		return err == nil && res == 2
+	}, time.Second, 5*time.Millisecond)
+
+	// 缓存不存在时同步执行 query
+	res, err := ctr.Wrap(ctx, "cold", func(ctx context.Context) (int, error) { return 3, nil })
+	require.NoError(t, err)
+	require.Equal(t, 3, res)
+
+	// 并发读取过期数据时只启动一个后台刷新协程
+	require.NoError(t, store.Set(ctx, "hot", &AbcBox[int]{T: 1, Timestamp: int(time.Now().Add(-time.Hour).Unix())}, KeepTTL))
+	release = make(chan struct{})
+	calls.Store(0)
+	before := runtime.NumGoroutine()
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := ctr.Wrap(ctx, "hot", query)
+			require.NoError(t, err)
+			require.Equal(t, 1, res)
+		}()
+	}
+	wg.Wait()
+	require.Less(t, runtime.NumGoroutine()-before, 10)
+	close(release)
+	require.Eventually(t, func() bool {
+		res, _, err := ctr.GetStore(ctx, "hot")
+		return err == nil && res == 2
+	}, time.Second, 5*time.Millisecond)
+	require.Equal(t, int64(1), calls.Load())
+}
+
+// TestQueryErrorStoreError 测试区分 query 和存储的错误
+func TestQueryErrorStoreError(t *testing.T) {
+	ctx := context.Background()
+	ctr := NewCacheController[int]("test-error-kind", NewMemoryStore())
+
+	queryErr := errors.New("query failed")
+	_, err := ctr.Wrap(ctx, "key", func(ctx context.Context) (int, error) { return 0, queryErr })
+	var qErr *QueryError
+	require.ErrorAs(t, err, &qErr)
+	require.Equal(t, "key", qErr.Key)
+	require.ErrorIs(t, err, queryErr)
+	var sErr *StoreError
+	require.False(t, errors.As(err, &sErr))
+
+	storeErr := errors.New("store failed")
+	ctr = NewCacheController[int]("test-error-kind", failStore{Store: NewMemoryStore(), err: storeErr})
+	err = ctr.SetStore(ctx, "key", 1, time.Minute)
+	require.ErrorAs(t, err, &sErr)
+	require.Equal(t, "set", sErr.Op)
+	require.ErrorIs(t, err, storeErr)
+	require.False(t, errors.As(err, &qErr))
+
+	// 缓存不存在不是存储错误
+	_, _, err = ctr.GetStore(ctx, "missing")
+	require.ErrorIs(t, err, ErrKeyNonExistent)
+	require.False(t, errors.As(err, &sErr))
+}
+
+// TestWithMaxKeyLength 测试超长 key 哈希
+func TestWithMaxKeyLength(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	ctr := NewCacheController[int]("test-max-key", store, WithMaxKeyLength[int](80, nil))
+
+	longKey := strings.Repeat("k", 100)
+	res, err := ctr.Wrap(ctx, longKey, func(ctx context.Context) (int, error) { return 1, nil })
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+
+	// 存储中使用哈希后的 key, 读取和写入一致
+	hashed := HashedKeyPrefix + sha256Hex(longKey)
+	_, err = store.Get(ctx, longKey)
+	require.ErrorIs(t, err, ErrKeyNonExistent)
+	_, err = store.Get(ctx, hashed)
+	require.NoError(t, err)
+	res, _, err = ctr.GetStore(ctx, longKey)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+	ok, err := ctr.Exists(ctx, longKey)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// 删除使用相同的 key
+	require.NoError(t, ctr.DelAndSet(ctx, "short", 2, time.Minute, longKey))
+	_, err = store.Get(ctx, hashed)
+	require.ErrorIs(t, err, ErrKeyNonExistent)
+	res, _, err = ctr.GetStore(ctx, "short")
+	require.NoError(t, err)
+	require.Equal(t, 2, res)
+	_, err = store.Get(ctx, "short")
+	require.NoError(t, err)
+	require.LessOrEqual(t, len(ctr.storeKey(longKey)), 80)
+
+	// 最大长度小于哈希后的长度时使用哈希后的长度, 替换后的 key 不超过最大长度
+	ctr = NewCacheController[int]("test-max-key", store, WithMaxKeyLength[int](16, nil))
+	require.Equal(t, len(hashed), ctr.maxKeyLen)
+	require.Equal(t, strings.Repeat("k", 32), ctr.storeKey(strings.Repeat("k", 32)))
+	require.Equal(t, hashed, ctr.storeKey(longKey))
+}
+
+// TestWithReadRepair 测试读修复删除不兼容的数据
+func TestWithReadRepair(t *testing.T) {
+	ctx := context.Background()
+	store, closer := getRedis()
+	defer closer()
+
+	// 未开启时保留旧数据
+	ctr := NewCacheController[int]("test-read-repair", store)
+	require.NoError(t, store.Set(ctx, "legacy", "1", time.Minute))
+	_, _, err := ctr.GetStore(ctx, "legacy")
+	require.ErrorIs(t, err, ErrKeyNonExistent)
+	_, err = store.Get(ctx, "legacy")
+	require.NoError(t, err)
+
+	// 开启后删除旧数据和损坏的数据
+	ctr = NewCacheController[int]("test-read-repair", store, WithReadRepair[int](true))
+	_, _, err = ctr.GetStore(ctx, "legacy")
+	require.ErrorIs(t, err, ErrKeyNonExistent)
+	_, err = store.Get(ctx, "legacy")
+	require.ErrorIs(t, err, ErrKeyNonExistent)
+
+	require.NoError(t, store.Set(ctx, "poison", `{"Timestamp":1,"T":"x"}`, time.Minute))
+	_, _, err = ctr.GetStore(ctx, "poison")
+	require.ErrorIs(t, err, ErrKeyNonExistent)
+	_, err = store.Get(ctx, "poison")
+	require.ErrorIs(t, err, ErrKeyNonExistent)
+}
+
+// TestWrapOrError 测试返回旧数据时获取 query 的错误
+func TestWrapOrError(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	ctr := NewCacheController[int]("test-wrap-or-error", store, WithPolicy[int](ReuseCachePloyIgnoreError(time.Minute)))
+	queryErr := errors.New("query failed")
+	failed := func(ctx context.Context) (int, error) { return 0, queryErr }
+
+	// 没有缓存时返回错误
+	_, staleErr, err := ctr.WrapOrError(ctx, "key", failed)
+	require.ErrorIs(t, err, queryErr)
+	require.NoError(t, staleErr)
+
+	// 过期的缓存返回旧数据和 query 的错误
+	require.NoError(t, store.Set(ctx, "key", &AbcBox[int]{T: 1, Timestamp: int(time.Now().Add(-time.Hour).Unix())}, KeepTTL))
+	res, staleErr, err := ctr.WrapOrError(ctx, "key", failed)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+	require.ErrorIs(t, staleErr, queryErr)
+
+	// 刷新成功时没有错误
+	res, staleErr, err = ctr.WrapOrError(ctx, "key", func(ctx context.Context) (int, error) { return 2, nil })
+	require.NoError(t, err)
+	require.NoError(t, staleErr)
+	require.Equal(t, 2, res)
+}
+
+// gzipStore 压缩编码后数据的装饰器, 内部存储可以是直接存储
+type gzipStore struct {
+	Store
+}
+
+func (g gzipStore) Get(ctx context.Context, key string) (any, error) {
+	value, err := g.Store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(value.([]byte)))
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(zr)
+	return string(data), err
+}
+
+func (g gzipStore) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(data.(string))); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return g.Store.Set(ctx, key, buf.Bytes(), ttl)
+}
+
+func (g gzipStore) IsDirectStore() bool {
+	return false
+}
+
+// TestDecoratorIsDirectStore 测试控制器使用最外层存储的 IsDirectStore, 直接存储外的压缩装饰器使用编码后的数据
+func TestDecoratorIsDirectStore(t *testing.T) {
+	ctx := context.Background()
+	inner := NewCacheStore(cache.New(time.Minute, time.Minute))
+	require.True(t, inner.IsDirectStore())
+	ctr := NewCacheController[map[string]int]("test-decorator", gzipStore{Store: inner})
+
+	want := map[string]int{"a": 1, "b": 2}
+	res, err := ctr.Wrap(ctx, "key", func(ctx context.Context) (map[string]int, error) { return want, nil })
+	require.NoError(t, err)
+	require.Equal(t, want, res)
+
+	// 内部存储保存的是压缩后的数据
+	raw, err := inner.Get(ctx, "key")
+	require.NoError(t, err)
+	require.IsType(t, []byte{}, raw)
+
+	res, _, err = ctr.GetStore(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, want, res)
+}
+
+// TestGetBox 测试获取装箱的缓存
+func TestGetBox(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	ctr := NewCacheController[int]("test-get-box", store, WithErrorCache[int](time.Minute, nil))
+
+	_, err := ctr.GetBox(ctx, "key")
+	require.ErrorIs(t, err, ErrKeyNonExistent)
+
+	require.NoError(t, ctr.SetStore(ctx, "key", 1, time.Minute))
+	box, err := ctr.GetBox(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, 1, box.T)
+	require.NotZero(t, box.Timestamp)
+	require.Empty(t, box.Err)
+
+	// 返回副本, 修改不影响直接存储中的数据
+	box.T = 2
+	res, _, err := ctr.GetStore(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+
+	// 缓存的 query 错误通过 Err 返回
+	_, err = ctr.Wrap(ctx, "err", func(ctx context.Context) (int, error) { return 0, errors.New("query failed") })
+	require.Error(t, err)
+	box, err = ctr.GetBox(ctx, "err")
+	require.NoError(t, err)
+	require.Equal(t, "query failed", box.Err)
+}
+
+// TestWithQueryTimeout 测试单次调用的 query 超时
+func TestWithQueryTimeout(t *testing.T) {
+	ctx := context.Background()
+	ctr := NewCacheController[int]("test-query-timeout", NewMemoryStore())
+
+	// 响应取消的 query 在超时后取消
+	_, err := ctr.Wrap(WithQueryTimeout(ctx, 20*time.Millisecond), "key", func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// 不响应取消的 query 超时后返回 ErrQueryTimeout
+	release := make(chan struct{})
+	defer close(release)
+	_, err = ctr.Wrap(WithQueryTimeout(ctx, 20*time.Millisecond), "hang", func(ctx context.Context) (int, error) {
+		<-release
+		return 1, nil
+	})
+	require.ErrorIs(t, err, ErrQueryTimeout)
+	res, err := ctr.Wrap(ctx, "hang", func(ctx context.Context) (int, error) { return 2, nil })
+	require.NoError(t, err)
+	require.Equal(t, 2, res)
+}
+
+// fixedClock 测试使用的固定时钟
+type fixedClock struct {
+	now atomic.Int64
+}
+
+func (c *fixedClock) Now() time.Time {
+	return time.UnixMilli(c.now.Load())
+}
+
+// TestTimestampMs 测试毫秒精度的业务过期时间
+func TestTimestampMs(t *testing.T) {
+	clock := &fixedClock{}
+	clock.now.Store(time.Now().UnixMilli())
+	SetClock(clock)
+	defer SetClock(nil)
+
+	ctx := context.Background()
+	store := NewMemoryStore()
+	ctr := NewCacheController[int]("test-timestamp-ms", store, WithPolicy[int](ReuseCachePloyIgnoreError(100*time.Millisecond)))
+	var calls int
+	query := func(ctx context.Context) (int, error) {
+		calls++
+		return calls, nil
+	}
+
+	res, err := ctr.Wrap(ctx, "key", query)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+	box, err := ctr.GetBox(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, clock.now.Load(), box.TimestampMs)
+
+	// 100ms 内使用缓存, 超过后重新执行 query
+	clock.now.Add(50)
+	res, err = ctr.Wrap(ctx, "key", query)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+	clock.now.Add(60)
+	res, err = ctr.Wrap(ctx, "key", query)
+	require.NoError(t, err)
+	require.Equal(t, 2, res)
+
+	// 没有毫秒时间戳的旧数据使用秒级时间戳
+	clock.now.Store(clock.now.Load()/1000*1000 + 50)
+	require.NoError(t, store.Set(ctx, "old", &AbcBox[int]{T: 30, Timestamp: int(clock.Now().Unix())}, KeepTTL))
+	res, err = ctr.Wrap(ctx, "old", query)
+	require.NoError(t, err)
+	require.Equal(t, 30, res)
+	clock.now.Add(100)
+	res, err = ctr.Wrap(ctx, "old", query)
+	require.NoError(t, err)
+	require.Equal(t, 3, res)
+}
+
+// TestWithKeyValidator 测试缓存键校验
+func TestWithKeyValidator(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	ctr := NewCacheController[int]("test-key-validator", store, WithKeyValidator[int](NewKeyValidator(true)))
+
+	calls := 0
+	query := func(ctx context.Context) (int, error) {
+		calls++
+		return 1, nil
+	}
+	for _, key := range []string{"", "user 1", "user\n1"} {
+		_, err := ctr.Wrap(ctx, key, query)
+		require.ErrorIs(t, err, ErrInvalidKey)
+		require.ErrorIs(t, ctr.SetStore(ctx, key, 1, time.Minute), ErrInvalidKey)
+		_, _, err = ctr.GetStore(ctx, key)
+		require.ErrorIs(t, err, ErrInvalidKey)
+	}
+	require.Zero(t, calls)
+	require.ErrorIs(t, ctr.DelAndSet(ctx, "key", 1, time.Minute, ""), ErrInvalidKey)
+
+	// 写穿透校验失败时不写入数据源
+	persist := func(ctx context.Context, value int) error {
+		calls++
+		return nil
+	}
+	require.ErrorIs(t, ctr.WriteThrough(ctx, "user 1", 1, time.Minute, persist), ErrInvalidKey)
+	require.ErrorIs(t, ctr.WriteThrough(ctx, "key", 1, time.Minute, persist, ""), ErrInvalidKey)
+	require.Zero(t, calls)
+
+	// 哈希标签只警告
+	res, err := ctr.Wrap(ctx, "{user}:1", query)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+
+	// 自定义校验的错误使用 ErrInvalidKey 包装
+	tooLong := errors.New("too long")
+	ctr = NewCacheController[int]("test-key-validator", store, WithKeyValidator[int](func(key string) error {
+		if len(key) > 4 {
+			return tooLong
+		}
+		return nil
+	}))
+	_, err = ctr.Exists(ctx, "long-key")
+	require.ErrorIs(t, err, ErrInvalidKey)
+	require.ErrorIs(t, err, tooLong)
+}
@@ -1,30 +1,345 @@
-package modecache
-
-import (
-	"context"
-	"time"
-)
-
-type Option[T any] func(m *CacheCtr[T])
-
-// WithPolicy 设置需要使用的缓存策略
-func WithPolicy[T any](p Policy) Option[T] {
-	return func(m *CacheCtr[T]) {
-		m.warp = p
-	}
-}
-
-// WithAddPlugin 设置想要使用的缓存插件
-func WithPlugins[T any](p ...Plugin) Option[T] {
-	return func(m *CacheCtr[T]) {
-		m.plugins = append(m.plugins, p...)
-	}
-}
-
-type TaskResult[T any] struct {
-	Key string        // 缓存 Key
-	T   T             // 缓存内容
-	TTL time.Duration // 缓存的过期时间, KeepTTL 永久存储
-}
-
-type TimerJobList[T any] func(ctx context.Context) ([]*TaskResult[T], error)
+package modecache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type Option[T any] func(m *CacheCtr[T])
+
+// WithPolicy 设置需要使用的缓存策略
+func WithPolicy[T any](p Policy) Option[T] {
+	return func(m *CacheCtr[T]) {
+		m.warp = p
+	}
+}
+
+// WithAddPlugin 设置想要使用的缓存插件
+func WithPlugins[T any](p ...Plugin) Option[T] {
+	return func(m *CacheCtr[T]) {
+		m.plugins = append(m.plugins, p...)
+	}
+}
+
+// WithStoreResolver 设置存储选择器, 用于按请求选择 Store (例如按租户分片)
+// 选择器返回 nil 时回退到上下文中的 Store, 再回退到控制器默认 Store
+func WithStoreResolver[T any](resolver StoreResolver) Option[T] {
+	return func(m *CacheCtr[T]) {
+		m.resolver = resolver
+	}
+}
+
+// WithTransform 设置缓存数据的转换方法, 例如写入缓存前剔除敏感字段
+// onStore 在写入 Store 前执行 (SetStore 和 query 回填缓存), 只影响缓存中的数据, 本次 query 的调用方依然拿到原始数据
+// onLoad 在从 Store 读取并解码后执行 (GetStore 和读取缓存)
+// 执行顺序: query 返回的数据先经过 onStore 写入缓存, 再对原始数据做空指针判断, 因此 onStore 可能收到 nil 指针;
+// 读取缓存时先执行 onLoad 再做空指针判断, onLoad 返回 nil 指针会被视为 ErrNil
+// # 注意 onStore/onLoad 不应该原地修改入参, 指针类型需要返回新的对象
+func WithTransform[T any](onStore func(T) T, onLoad func(T) T) Option[T] {
+	return func(m *CacheCtr[T]) {
+		m.onStore = onStore
+		m.onLoad = onLoad
+	}
+}
+
+// WithValueFactory 设置非直接存储 (例如 redis) 解码缓存时使用的初始值, 用于 T 为接口类型时解码到具体类型
+// 例如 CacheCtr[any] 使用 WithValueFactory[any](func() any { return new(User) }) 读取到 *User
+// 未设置时 T 为 any 的 JSON 对象会被解码为 map[string]any, 数字解码为 float64; T 为非空接口时返回 ErrUnpackingFailed
+// # 注意 factory 需要返回非 nil 的指针, 返回非指针时解码结果依然是 JSON 的默认类型; 直接存储不做编码, 不需要设置
+func WithValueFactory[T any](factory func() T) Option[T] {
+	return func(m *CacheCtr[T]) {
+		m.newValue = factory
+	}
+}
+
+// WithStrictTyping 设置直接存储中的数据类型不是 *AbcBox[T] 时是否返回 ErrUnpackingFailed
+// 默认视为未命中 (ErrKeyNonExistent), 由 query 重新写入, 适用于发布期间不同类型的控制器共用 key 的场景;
+// 开启后返回 ErrUnpackingFailed, 会触发 OnError 回调, 便于发现 key 冲突
+func WithStrictTyping[T any](strict bool) Option[T] {
+	return func(m *CacheCtr[T]) {
+		m.strict = strict
+	}
+}
+
+// WithValidator 设置读取缓存后的数据校验, 校验失败的缓存视为未命中, 由 query 重新写入
+// 校验失败会以 ErrInvalidValue 错误 (包含控制器名称和 key) 触发 OnError 回调, 便于记录错误数据
+func WithValidator[T any](validate func(T) error) Option[T] {
+	return func(m *CacheCtr[T]) {
+		m.validate = validate
+	}
+}
+
+// WithErrorCache 设置 query 错误的缓存, query 返回 isCacheable 判断可以缓存的错误时, 使用 ttl 缓存错误信息,
+// ttl 内读取缓存直接返回 *CachedError 错误, 不再执行 query, 适用于确定性的错误 (例如参数校验失败), 类似空值缓存
+// isCacheable 为空时缓存除 context 取消和超时之外的全部错误
+// # 注意缓存的错误只保留错误信息, 不会经过 WithOnStore/WithOnLoad 转换
+func WithErrorCache[T any](ttl time.Duration, isCacheable func(error) bool) Option[T] {
+	if isCacheable == nil {
+		isCacheable = func(err error) bool {
+			return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+		}
+	}
+	return func(m *CacheCtr[T]) {
+		m.errCacheTTL = ttl
+		m.errCacheable = isCacheable
+	}
+}
+
+// WithBackstopTTL 设置永久存储 (KeepTTL) 写入时使用的兜底过期时间, 默认关闭, 保持永久存储
+// ReuseCachePloyIgnoreError, FirstCachePolyIgnoreError 使用 KeepTTL 写入缓存并使用业务过期时间判断是否过期,
+// 不再访问的 key 会一直保存在存储中, 设置兜底过期时间后这些 key 最终会被删除, 通常设置为业务过期时间的数倍 (例如 3 倍)
+// 实际的过期时间会增加最多 10% 的随机时间, 避免同时写入的缓存同时过期
+// # 注意兜底过期时间需要大于业务过期时间, 否则缓存在业务过期前被删除, 策略无法重用过期的缓存
+func WithBackstopTTL[T any](ttl time.Duration) Option[T] {
+	return func(m *CacheCtr[T]) {
+		m.backstopTTL = ttl
+	}
+}
+
+// WithPoisonRepair 设置是否修复无法拆箱的损坏数据 (例如数据损坏或者结构不兼容), 默认开启
+// 开启时读取缓存遇到 ErrUnpackingFailed 会删除该 key 并视为未命中, 由 query 重新写入, 每个 key 只触发一次 OnError 回调;
+// 关闭时读取缓存返回 ErrUnpackingFailed, 每次读取都触发 OnError 回调, 损坏数据保留到 query 成功覆盖或者过期
+func WithPoisonRepair[T any](enable bool) Option[T] {
+	return func(m *CacheCtr[T]) {
+		m.keepPoison = !enable
+	}
+}
+
+// WithMaxKeyLength 设置访问存储使用的 key 的最大长度 (字节), 超过 n 的 key 替换为 HashedKeyPrefix + hashFn(key)
+// hashFn 为 nil 时使用 sha256 十六进制编码; 读取, 写入, 删除等访问存储的操作统一替换, 读写使用相同的 key
+// n 小于 HashedKeyPrefix 加哈希值的长度时使用该长度, 保证替换后的 key 不超过最大长度 (hashFn 需要输出固定长度)
+// # 注意不同的 key 哈希后可能冲突, 冲突的 key 会读取到对方的缓存, 需要使用输出足够长的哈希函数 (例如 sha256)
+// # 注意替换后的 key 无法通过前缀删除 (ScanDel) 或者 DumpStore 还原原始 key
+func WithMaxKeyLength[T any](n int, hashFn func(string) string) Option[T] {
+	return func(m *CacheCtr[T]) {
+		if hashFn == nil {
+			hashFn = sha256Hex
+		}
+		if n > 0 {
+			n = max(n, len(HashedKeyPrefix)+len(hashFn("")))
+		}
+		m.maxKeyLen = n
+		m.hashKey = hashFn
+	}
+}
+
+// WithReadRepair 设置是否开启读修复, 默认关闭
+// 开启时读取到不兼容的数据 (旧版本写入的未装箱数据或者直接存储中类型不匹配的数据) 会立即删除该 key 并视为未命中,
+// 避免其他节点在 query 重新写入前一直读取到这些数据; GetStore 读取到损坏的数据时也按照 WithPoisonRepair 删除
+func WithReadRepair[T any](enable bool) Option[T] {
+	return func(m *CacheCtr[T]) {
+		m.readRepair = enable
+	}
+}
+
+// WithSecondaryIndex 开启二级索引, 写入缓存时使用 extract 提取索引属性, 在存储中写入 属性值 -> 主键 的反向索引,
+// 之后可以使用 DelBySecondary 根据属性值删除缓存 (例如按 email 删除按 ID 缓存的用户), 索引和缓存使用相同的过期时间
+// # 注意每次写入缓存需要额外读写索引, 并且索引的读取合并写入不是原子操作, 并发写入相同的索引可能丢失主键
+func WithSecondaryIndex[T any](extract IndexExtractor[T]) Option[T] {
+	return func(m *CacheCtr[T]) {
+		m.indexer = extract
+	}
+}
+
+// WithKeyValidator 设置缓存键校验, 访问存储前 (Wrap, GetStore, SetStore, DelAndSet 等) 校验缓存键,
+// 校验失败时不访问存储, 返回 ErrInvalidKey 错误, validate 返回的错误会使用 ErrInvalidKey 包装; 可以使用 NewKeyValidator 创建默认的校验
+// # 注意 WrapFanout 的关联数据和二级索引的 key 不校验
+func WithKeyValidator[T any](validate func(key string) error) Option[T] {
+	return func(m *CacheCtr[T]) {
+		m.validKey = validate
+	}
+}
+
+// WithDegradeToLocal 设置主存储不可用时降级使用的本地存储 (例如 NewMemoryStore), 避免 redis 不可用时所有调用失败
+// 主存储 Get/Set/Del 返回 ErrStoreUnavailable 时切换到 fallback 读写 (可能读取到更旧的数据或者未命中),
+// 降级期间的请求每 5 秒触发一次主存储探测, 恢复后切换回主存储, 并清空实现了 Clearable 的 fallback
+// 条件写入, 事务删除写入, Exists, Touch 和 RemainingTTL 使用当前存储 (主存储或者 fallback) 的实现, 未实现时和直接使用该存储的行为一致
+// # 注意只对控制器的默认存储生效 (不包含 WithStoreResolver 和上下文中的存储), 降级期间的删除不会同步到主存储;
+// Clear, ScanDel 和 Dump 不支持降级存储, 返回 ErrUnsupported
+func WithDegradeToLocal[T any](fallback Store) Option[T] {
+	return func(m *CacheCtr[T]) {
+		m.store = &degradeStore{primary: m.store, fallback: fallback, probeInterval: degradeProbeInterval}
+	}
+}
+
+// WithFallback 设置策略返回错误 (缓存不可用并且 query 失败) 时使用的默认值, 返回默认值和 nil 错误
+// # 注意默认值会掩盖 query 错误, 调用方无法感知失败, 需要配合 WithOnError 回调记录错误
+func WithFallback[T any](fallback func() T) Option[T] {
+	return func(m *CacheCtr[T]) {
+		m.fallback = fallback
+	}
+}
+
+// WithOnHit 设置命中缓存的回调, 用于简单的日志和指标统计
+func WithOnHit[T any](fn func(ctx context.Context, key string)) Option[T] {
+	return func(m *CacheCtr[T]) {
+		m.onHit = fn
+	}
+}
+
+// WithOnMiss 设置未命中缓存的回调 (缓存键不存在或缓存为空指针)
+func WithOnMiss[T any](fn func(ctx context.Context, key string)) Option[T] {
+	return func(m *CacheCtr[T]) {
+		m.onMiss = fn
+	}
+}
+
+// WithOnError 设置错误回调, 读取缓存的非缺失错误、query 错误和插件拦截错误都会触发
+func WithOnError[T any](fn func(ctx context.Context, key string, err error)) Option[T] {
+	return func(m *CacheCtr[T]) {
+		m.onError = fn
+	}
+}
+
+// WithOnShared 设置 singleflight 合并请求回调, 请求复用了其他请求的 query 结果时触发, 用于统计合并节省的 query 次数
+func WithOnShared[T any](fn func(ctx context.Context, key string)) Option[T] {
+	return func(m *CacheCtr[T]) {
+		m.onShared = fn
+	}
+}
+
+// WithOnStaleServe 设置返回过期数据回调, 策略返回超过业务过期时间的缓存时触发 (query 失败, 超时或者后台刷新中),
+// 用于统计降级的次数
+func WithOnStaleServe[T any](fn func(ctx context.Context, key string)) Option[T] {
+	return func(m *CacheCtr[T]) {
+		m.onStale = fn
+	}
+}
+
+// policyOptions 策略的可选配置
+type policyOptions struct {
+	refreshTimeout time.Duration     // 后台刷新超时时间
+	shardHasher    func(string) uint // key 分片哈希方法
+	shards         int               // 分片锁数量
+	refreshWorkers int               // 后台刷新协程数量, 0 表示每次刷新启动新的协程
+	refreshQueue   int               // 后台刷新排队数量
+	reuseTimeout   time.Duration     // 重用缓存模型有缓存数据时等待 query 的时间, 0 表示不限制
+	locker         RefreshLocker     // 后台刷新的分布式锁, nil 表示只使用本地分片锁
+	lockFallback   bool              // 分布式锁不可用时是否退化为只使用本地分片锁刷新
+	forgetAfter    time.Duration     // singleflight 执行超过该时间后不再合并新的调用, 0 表示不限制
+	maxStale       time.Duration     // 重用缓存模型重用数据的最大时长, 0 表示不限制
+}
+
+func newPolicyOptions(opts ...PolicyOption) *policyOptions {
+	o := &policyOptions{
+		refreshTimeout: DefaultRefreshTimeout,
+		shardHasher:    hashCrc32ToUint,
+		shards:         Mutex128Shards,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// PolicyOption 策略的可选配置
+type PolicyOption func(o *policyOptions)
+
+// WithRefreshTimeout 设置后台刷新缓存的超时时间, 默认 DefaultRefreshTimeout
+func WithRefreshTimeout(timeout time.Duration) PolicyOption {
+	return func(o *policyOptions) {
+		if timeout > 0 {
+			o.refreshTimeout = timeout
+		}
+	}
+}
+
+// WithShardHasher 设置 key 映射到分片锁的哈希方法, 默认使用 CRC32
+// 结构化的 key 使用 CRC32 可能聚集到少数分片, 导致不相关的 key 相互阻塞刷新
+func WithShardHasher(hasher func(string) uint) PolicyOption {
+	return func(o *policyOptions) {
+		if hasher != nil {
+			o.shardHasher = hasher
+		}
+	}
+}
+
+// WithShardCount 设置分片锁数量, 默认 Mutex128Shards
+func WithShardCount(shards int) PolicyOption {
+	return func(o *policyOptions) {
+		if shards > 0 {
+			o.shards = shards
+		}
+	}
+}
+
+// WithRefreshWorkers 使用固定数量的协程执行后台刷新, 限制后台刷新的总并发, 不受 key 数量影响
+// 所有协程繁忙时刷新任务最多排队 queue 个, 队列已满时丢弃本次刷新, 继续使用缓存数据, 下次请求时重新提交刷新
+// queue 为 0 表示不排队, 只有空闲的协程可以接收刷新任务
+// 默认每次刷新启动新的协程, 并发数量只受分片锁数量限制
+// # 注意协程在策略创建时启动并常驻, 不会随策略释放
+func WithRefreshWorkers(workers, queue int) PolicyOption {
+	return func(o *policyOptions) {
+		if workers > 0 {
+			o.refreshWorkers = workers
+			o.refreshQueue = max(queue, 0)
+		}
+	}
+}
+
+// WithMaxStale 设置 ReuseCachePloyIgnoreError 重用缓存数据的最大时长, 数据创建时间超过 maxStale 后不再重用, query 失败时返回错误
+func WithMaxStale(maxStale time.Duration) PolicyOption {
+	return func(o *policyOptions) {
+		if maxStale > 0 {
+			o.maxStale = maxStale
+		}
+	}
+}
+
+// WithForgetAfter 设置策略的 singleflight 执行超过 d 后 Forget key, 之后相同 key 的调用重新执行 query, 不再合并到本次执行
+// 默认合并到正在执行的 query, 下游慢查询或者超时失败时, 期间的所有调用都等待同一次执行并得到同一个结果 (重用策略返回同一份旧数据),
+// 设置后长时间执行的 query 不再吸收新的调用, 限制重试的间隔; 适用于 ReuseCachePloyIgnoreError 和 FirstCachePolyIgnoreError
+// # 注意 d 过小时相同 key 可能同时执行多个 query, 增加下游压力
+func WithForgetAfter(d time.Duration) PolicyOption {
+	return func(o *policyOptions) {
+		if d > 0 {
+			o.forgetAfter = d
+		}
+	}
+}
+
+// WithReuseTimeout 设置 ReuseCachePloyIgnoreError 有缓存数据时同步等待 query 的最长时间, 超时后返回缓存数据
+// query 在后台继续执行并更新缓存, 后台执行的超时时间使用 WithRefreshTimeout 配置; 没有缓存数据时依然同步等待 query
+func WithReuseTimeout(timeout time.Duration) PolicyOption {
+	return func(o *policyOptions) {
+		if timeout > 0 {
+			o.reuseTimeout = timeout
+		}
+	}
+}
+
+// WithRefreshLocker 设置 FirstCachePolyIgnoreError 后台刷新使用的分布式锁, 使集群内同一个 key 同时只有一个节点刷新
+// 获取本地分片锁后再获取分布式锁, 锁被其他节点持有时放弃刷新, 继续使用缓存数据
+// fallback 控制分布式锁不可用 (例如 redis 故障) 时的行为: true 退化为只使用本地分片锁刷新, false 放弃刷新
+func WithRefreshLocker(locker RefreshLocker, fallback bool) PolicyOption {
+	return func(o *policyOptions) {
+		o.locker = locker
+		o.lockFallback = fallback
+	}
+}
+
+type TaskResult[T any] struct {
+	Key string        // 缓存 Key
+	T   T             // 缓存内容
+	TTL time.Duration // 缓存的过期时间, KeepTTL 永久存储
+}
+
+type TimerJobList[T any] func(ctx context.Context) ([]*TaskResult[T], error)
+
+// warmupOptions 预热的可选配置
+type warmupOptions struct {
+	concurrency int // 并发写入数量
+}
+
+// WarmupOption 预热的可选配置
+type WarmupOption func(o *warmupOptions)
+
+// WithWarmupConcurrency 设置预热并发写入缓存的数量, 默认 1 顺序写入
+func WithWarmupConcurrency(n int) WarmupOption {
+	return func(o *warmupOptions) {
+		if n > 0 {
+			o.concurrency = n
+		}
+	}
+}
@@ -1,95 +1,207 @@
-package modecache
-
-import (
-	"context"
-	"time"
-
-	"github.com/prometheus/client_golang/prometheus"
-	"golang.org/x/time/rate"
-)
-
-// 使用 go 限流器实现 query 访问限流插件
-type LimitQueryPlugin struct {
-	limit *rate.Limiter
-}
-
-// DB 限流器
-func (m *LimitQueryPlugin) InterceptCallQuery(ctx context.Context, key string, loadQuery LoadingForQuery) (LoadingForQuery, bool, error) {
-	// 等待限流器
-	return func(ctx context.Context, key string, ttl time.Duration) (any, error) {
-		if err := m.limit.Wait(ctx); err != nil {
-			return nil, err
-		}
-		return loadQuery(ctx, key, ttl)
-	}, true, nil
-}
-
-func (m *LimitQueryPlugin) InterceptCallCache(ctx context.Context, key string, loadCache LoadingForCache) (LoadingForCache, bool, error) {
-	return loadCache, true, nil
-}
-
-func NewLimitQueryPlugin(r rate.Limit, b int) Plugin {
-	return &LimitQueryPlugin{
-		limit: rate.NewLimiter(r, b),
-	}
-}
-
-var (
-	_metricControllerCallCount = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Namespace: "cache",
-		Subsystem: "modecache",
-		Name:      "modecache_controller_count",
-		Help:      "Count the number of accesses to the  mode controller",
-	}, []string{"name", "query", "error"})
-
-	_metricControllerCallSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Namespace: "cache",
-		Subsystem: "modecache",
-		Name:      "modecache_controller_sec",
-		Help:      "mode cache duration(sec).",
-		Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.250, 0.5, 1},
-	}, []string{"name", "query", "error"})
-)
-
-// MetricsPlugin 指标插件
-type MetricsPlugin struct {
-	name string
-}
-
-func (m *MetricsPlugin) InterceptCallQuery(ctx context.Context, key string, loadQuery LoadingForQuery) (LoadingForQuery, bool, error) {
-	return func(ctx context.Context, key string, ttl time.Duration) (any, error) {
-		startTime := time.Now()
-		isTest := "0"
-		value, err := loadQuery(ctx, key, ttl)
-		isError := "0"
-		if err != nil {
-			isError = "1"
-		}
-
-		_metricControllerCallCount.WithLabelValues(m.name, isTest, "1", isError).Inc()
-		_metricControllerCallSeconds.WithLabelValues(m.name, isTest, "1", isError).Observe(time.Since(startTime).Seconds())
-
-		return value, err
-	}, true, nil
-}
-
-func (m *MetricsPlugin) InterceptCallCache(ctx context.Context, key string, loadCache LoadingForCache) (LoadingForCache, bool, error) {
-	return func(ctx context.Context, key string) (any, int, error) {
-		startTime := time.Now()
-		value, dataTime, err := loadCache(ctx, key)
-		isError := "0"
-		if err != nil {
-			isError = "1"
-		}
-		_metricControllerCallCount.WithLabelValues(m.name, "0", isError).Inc()
-		_metricControllerCallSeconds.WithLabelValues(m.name, "0", isError).Observe(time.Since(startTime).Seconds())
-
-		return value, dataTime, err
-	}, true, nil
-}
-
-func NewMetricsPlugin(name string) Plugin {
-	return &MetricsPlugin{
-		name: name,
-	}
-}
+package modecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// 使用 go 限流器实现 query 访问限流插件
+type LimitQueryPlugin struct {
+	limit   *rate.Limiter
+	maxWait time.Duration // 最长等待时间, <= 0 时一直等待到获取令牌或者 ctx 结束
+}
+
+// DB 限流器
+func (m *LimitQueryPlugin) InterceptCallQuery(ctx context.Context, key string, loadQuery LoadingForQuery) (LoadingForQuery, bool, error) {
+	// 等待限流器
+	return func(ctx context.Context, key string, ttl time.Duration) (any, error) {
+		if err := m.wait(ctx); err != nil {
+			return nil, err
+		}
+		return loadQuery(ctx, key, ttl)
+	}, true, nil
+}
+
+// wait 等待令牌, 设置 maxWait 时预约令牌, 需要等待的时间超过 maxWait 或者 ctx 的截止时间时直接返回 ErrRateLimited
+func (m *LimitQueryPlugin) wait(ctx context.Context) error {
+	if m.maxWait <= 0 {
+		return m.limit.Wait(ctx)
+	}
+	r := m.limit.Reserve()
+	if !r.OK() {
+		return fmt.Errorf("%w: burst exceeded", ErrRateLimited)
+	}
+	delay := r.Delay()
+	if delay == 0 {
+		return nil
+	}
+	if delay > m.maxWait {
+		r.Cancel()
+		return fmt.Errorf("%w: need wait %s, max wait %s", ErrRateLimited, delay, m.maxWait)
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		r.Cancel()
+		return fmt.Errorf("%w: need wait %s, exceeds context deadline", ErrRateLimited, delay)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	}
+}
+
+func (m *LimitQueryPlugin) InterceptCallCache(ctx context.Context, key string, loadCache LoadingForCache) (LoadingForCache, bool, error) {
+	return loadCache, true, nil
+}
+
+func NewLimitQueryPlugin(r rate.Limit, b int) Plugin {
+	return &LimitQueryPlugin{
+		limit: rate.NewLimiter(r, b),
+	}
+}
+
+// NewLimitQueryPluginWithMaxWait 创建快速失败的 query 限流插件, 获取令牌需要等待的时间超过 maxWait 时,
+// 不再等待, 直接返回 ErrRateLimited 错误, 避免持续过载时每个请求都等待到超时, 便于调用方降级
+func NewLimitQueryPluginWithMaxWait(r rate.Limit, b int, maxWait time.Duration) Plugin {
+	return &LimitQueryPlugin{
+		limit:   rate.NewLimiter(r, b),
+		maxWait: maxWait,
+	}
+}
+
+var (
+	_metricControllerCallCountOpts = prometheus.CounterOpts{
+		Namespace: "cache",
+		Subsystem: "modecache",
+		Name:      "modecache_controller_count",
+		Help:      "Count the number of accesses to the  mode controller",
+	}
+
+	_metricControllerCallSecondsOpts = prometheus.HistogramOpts{
+		Namespace: "cache",
+		Subsystem: "modecache",
+		Name:      "modecache_controller_sec",
+		Help:      "mode cache duration(sec).",
+		Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.250, 0.5, 1},
+	}
+
+	_metricSingleflightSharedOpts = prometheus.CounterOpts{
+		Namespace: "cache",
+		Subsystem: "modecache",
+		Name:      "modecache_singleflight_shared_total",
+		Help:      "Count the number of queries saved by singleflight coalescing",
+	}
+
+	_metricStaleServedOpts = prometheus.CounterOpts{
+		Namespace: "cache",
+		Subsystem: "modecache",
+		Name:      "modecache_stale_served_total",
+		Help:      "Count the number of cached values served past their business expiry",
+	}
+
+	_metricControllerLabels = []string{"name", "query", "error"}
+	_metricSharedLabels     = []string{"name"}
+
+	_metricControllerCallCount   = prometheus.NewCounterVec(_metricControllerCallCountOpts, _metricControllerLabels)
+	_metricControllerCallSeconds = prometheus.NewHistogramVec(_metricControllerCallSecondsOpts, _metricControllerLabels)
+	_metricSingleflightShared    = prometheus.NewCounterVec(_metricSingleflightSharedOpts, _metricSharedLabels)
+	_metricStaleServed           = prometheus.NewCounterVec(_metricStaleServedOpts, _metricSharedLabels)
+)
+
+// MetricsPlugin 指标插件
+type MetricsPlugin struct {
+	name    string
+	count   *prometheus.CounterVec
+	seconds *prometheus.HistogramVec
+	shared  *prometheus.CounterVec
+	stale   *prometheus.CounterVec
+}
+
+func (m *MetricsPlugin) InterceptCallQuery(ctx context.Context, key string, loadQuery LoadingForQuery) (LoadingForQuery, bool, error) {
+	return func(ctx context.Context, key string, ttl time.Duration) (any, error) {
+		startTime := time.Now()
+		value, err := loadQuery(ctx, key, ttl)
+		isError := "0"
+		if err != nil {
+			isError = "1"
+		}
+
+		m.count.WithLabelValues(m.name, "1", isError).Inc()
+		m.seconds.WithLabelValues(m.name, "1", isError).Observe(time.Since(startTime).Seconds())
+
+		return value, err
+	}, true, nil
+}
+
+func (m *MetricsPlugin) InterceptCallCache(ctx context.Context, key string, loadCache LoadingForCache) (LoadingForCache, bool, error) {
+	return func(ctx context.Context, key string) (any, int, error) {
+		startTime := time.Now()
+		value, dataTime, err := loadCache(ctx, key)
+		isError := "0"
+		if err != nil {
+			isError = "1"
+		}
+		m.count.WithLabelValues(m.name, "0", isError).Inc()
+		m.seconds.WithLabelValues(m.name, "0", isError).Observe(time.Since(startTime).Seconds())
+
+		return value, dataTime, err
+	}, true, nil
+}
+
+// OnShared 记录 singleflight 合并的请求
+func (m *MetricsPlugin) OnShared(ctx context.Context, key string) {
+	m.shared.WithLabelValues(m.name).Inc()
+}
+
+// OnStaleServe 记录策略返回的过期数据
+func (m *MetricsPlugin) OnStaleServe(ctx context.Context, key string) {
+	m.stale.WithLabelValues(m.name).Inc()
+}
+
+// NewMetricsPlugin 创建指标插件, 指标不会注册到任何 registry
+func NewMetricsPlugin(name string) Plugin {
+	return &MetricsPlugin{
+		name:    name,
+		count:   _metricControllerCallCount,
+		seconds: _metricControllerCallSeconds,
+		shared:  _metricSingleflightShared,
+		stale:   _metricStaleServed,
+	}
+}
+
+// NewMetricsPluginWithRegistry 创建指标插件, 并把指标注册到 reg
+// 多个插件使用同一个 reg 时共享已经注册的指标, 不会因为重复注册 panic
+func NewMetricsPluginWithRegistry(name string, reg prometheus.Registerer) Plugin {
+	return &MetricsPlugin{
+		name:    name,
+		count:   mustRegister(reg, prometheus.NewCounterVec(_metricControllerCallCountOpts, _metricControllerLabels)),
+		seconds: mustRegister(reg, prometheus.NewHistogramVec(_metricControllerCallSecondsOpts, _metricControllerLabels)),
+		shared:  mustRegister(reg, prometheus.NewCounterVec(_metricSingleflightSharedOpts, _metricSharedLabels)),
+		stale:   mustRegister(reg, prometheus.NewCounterVec(_metricStaleServedOpts, _metricSharedLabels)),
+	}
+}
+
+// mustRegister 注册指标到 reg, 已经注册过时返回已注册的指标, 其他错误 panic
+func mustRegister[C prometheus.Collector](reg prometheus.Registerer, c C) C {
+	err := reg.Register(c)
+	if err == nil {
+		return c
+	}
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		if existing, ok := are.ExistingCollector.(C); ok {
+			return existing
+		}
+	}
+	panic(err)
+}
@@ -0,0 +1,272 @@
+package modecache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestMetricsPluginWithRegistry(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	plugin := NewMetricsPluginWithRegistry("test-metrics", reg)
+	// 重复注册不会 panic, 共享已注册的指标
+	plugin2 := NewMetricsPluginWithRegistry("test-metrics-2", reg)
+
+	ctx := context.Background()
+	ctr := NewCacheController[int]("test-metrics", NewMemoryStore(), WithPlugins[int](plugin))
+	ctr2 := NewCacheController[int]("test-metrics-2", NewMemoryStore(), WithPlugins[int](plugin2))
+
+	for i := 0; i < 2; i++ {
+		_, err := ctr.Wrap(ctx, "key", func(ctx context.Context) (int, error) { return 1, nil })
+		require.NoError(t, err)
+	}
+	_, err := ctr2.Wrap(ctx, "key", func(ctx context.Context) (int, error) { return 0, errors.New("test error") })
+	require.Error(t, err)
+
+	count := plugin.(*MetricsPlugin).count
+	require.Equal(t, float64(1), counterValue(t, count.WithLabelValues("test-metrics", "1", "0")))
+	require.Equal(t, float64(1), counterValue(t, count.WithLabelValues("test-metrics", "0", "0")))
+	require.Equal(t, float64(1), counterValue(t, count.WithLabelValues("test-metrics", "0", "1")))
+	require.Equal(t, float64(1), counterValue(t, count.WithLabelValues("test-metrics-2", "1", "1")))
+
+	// 指标注册到了 reg
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 2)
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	require.NoError(t, c.Write(m))
+	return m.GetCounter().GetValue()
+}
+
+// rejectPlugin 拒绝所有 query 的插件, 提前熔断插件链
+type rejectPlugin struct {
+	err error
+}
+
+func (r rejectPlugin) InterceptCallQuery(ctx context.Context, key string, loadQuery LoadingForQuery) (LoadingForQuery, bool, error) {
+	return func(ctx context.Context, key string, ttl time.Duration) (any, error) {
+		return nil, r.err
+	}, false, nil
+}
+
+func (r rejectPlugin) InterceptCallCache(ctx context.Context, key string, loadCache LoadingForCache) (LoadingForCache, bool, error) {
+	return loadCache, true, nil
+}
+
+// resultPlugin 记录最终结果的插件
+type resultPlugin struct {
+	values []any
+	errs   []error
+}
+
+func (r *resultPlugin) InterceptCallQuery(ctx context.Context, key string, loadQuery LoadingForQuery) (LoadingForQuery, bool, error) {
+	return loadQuery, true, nil
+}
+
+func (r *resultPlugin) InterceptCallCache(ctx context.Context, key string, loadCache LoadingForCache) (LoadingForCache, bool, error) {
+	return loadCache, true, nil
+}
+
+func (r *resultPlugin) ObserveResult(ctx context.Context, key string, value any, err error) {
+	r.values = append(r.values, value)
+	r.errs = append(r.errs, err)
+}
+
+func TestResultObserver(t *testing.T) {
+	ctx := context.Background()
+	testErr := errors.New("test error")
+	plugin := &resultPlugin{}
+	ctr := NewCacheController[int]("test-result-observer", NewMemoryStore(), WithPlugins[int](plugin))
+
+	_, _ = ctr.Wrap(ctx, "key", func(ctx context.Context) (int, error) { return 1, nil })
+	_, _ = ctr.Wrap(ctx, "key", func(ctx context.Context) (int, error) { return 2, nil })
+	_, _ = ctr.Wrap(ctx, "err", func(ctx context.Context) (int, error) { return 0, testErr })
+	require.Equal(t, []any{1, 1, 0}, plugin.values)
+	require.NoError(t, plugin.errs[0])
+	require.NoError(t, plugin.errs[1])
+	require.ErrorIs(t, plugin.errs[2], testErr)
+
+	// 观察到默认值
+	plugin = &resultPlugin{}
+	ctr = NewCacheController[int]("test-result-observer", NewMemoryStore(), WithPlugins[int](plugin),
+		WithFallback(func() int { return -1 }))
+	_, _ = ctr.Wrap(ctx, "err", func(ctx context.Context) (int, error) { return 0, testErr })
+	require.Equal(t, []any{-1}, plugin.values)
+	require.NoError(t, plugin.errs[0])
+}
+
+func TestControllerNameFromContext(t *testing.T) {
+	ctx := context.Background()
+	_, ok := ControllerNameFromContext(ctx)
+	require.False(t, ok)
+
+	var hookName, queryName string
+	ctr := NewCacheController[int]("test-ctx-name", NewMemoryStore(),
+		WithOnMiss[int](func(ctx context.Context, key string) { hookName, _ = ControllerNameFromContext(ctx) }))
+	_, err := ctr.Wrap(ctx, "key", func(ctx context.Context) (int, error) {
+		queryName, _ = ControllerNameFromContext(ctx)
+		return 1, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "test-ctx-name", hookName)
+	require.Equal(t, "test-ctx-name", queryName)
+}
+
+func TestControllerHooks(t *testing.T) {
+	ctx := context.Background()
+	var hit, miss, errCount int
+	var lastErr error
+	hooks := []Option[int]{
+		WithOnHit[int](func(ctx context.Context, key string) { hit++ }),
+		WithOnMiss[int](func(ctx context.Context, key string) { miss++ }),
+		WithOnError[int](func(ctx context.Context, key string, err error) {
+			errCount++
+			lastErr = err
+		}),
+	}
+
+	ctr := NewCacheController[int]("test-hooks", NewMemoryStore(), hooks...)
+	query := func(ctx context.Context) (int, error) { return 1, nil }
+	_, err := ctr.Wrap(ctx, "key", query)
+	require.NoError(t, err)
+	_, err = ctr.Wrap(ctx, "key", query)
+	require.NoError(t, err)
+	require.Equal(t, 1, hit)
+	require.Equal(t, 1, miss)
+	require.Equal(t, 0, errCount)
+
+	// 插件提前熔断时回调依然执行
+	testErr := errors.New("rejected")
+	ctr = NewCacheController[int]("test-hooks", NewMemoryStore(), append(hooks, WithPlugins[int](rejectPlugin{err: testErr}))...)
+	_, err = ctr.Wrap(ctx, "key", query)
+	require.ErrorIs(t, err, testErr)
+	require.Equal(t, 2, miss)
+	require.Equal(t, 1, errCount)
+	require.ErrorIs(t, lastErr, testErr)
+}
+
+func TestBypassPlugins(t *testing.T) {
+	ctx := context.Background()
+	testErr := errors.New("rejected")
+	ctr := NewCacheController[int]("test-bypass", NewMemoryStore(), WithPlugins[int](rejectPlugin{err: testErr}))
+	query := func(ctx context.Context) (int, error) { return 1, nil }
+
+	// 插件拒绝 query
+	_, err := ctr.Wrap(ctx, "key", query)
+	require.ErrorIs(t, err, testErr)
+
+	// 跳过插件链
+	res, err := ctr.Wrap(WithBypassPlugins(ctx), "key", query)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+}
+
+func TestControllerPlugins(t *testing.T) {
+	testErr := errors.New("rejected")
+	ctr := NewCacheController[int]("test-plugins", NewMemoryStore(), WithPlugins[int](rejectPlugin{err: testErr}))
+
+	plugins := ctr.Plugins()
+	require.Len(t, plugins, 1)
+	plugin, ok := plugins[0].(rejectPlugin)
+	require.True(t, ok)
+	require.ErrorIs(t, plugin.err, testErr)
+
+	// 修改副本不影响控制器
+	plugins[0] = nil
+	require.NotNil(t, ctr.Plugins()[0])
+}
+
+func TestSingleflightShared(t *testing.T) {
+	ctx := context.Background()
+	plugin := NewMetricsPluginWithRegistry("test-shared", prometheus.NewRegistry())
+	var shared atomic.Int64
+	ctr := NewCacheController[int]("test-shared", NewMemoryStore(), WithPlugins[int](plugin),
+		WithOnShared[int](func(ctx context.Context, key string) {
+			shared.Add(1)
+		}))
+
+	// 并发请求通过 singleflight 合并, 只执行一次 query
+	var (
+		wg      sync.WaitGroup
+		calls   atomic.Int64
+		release = make(chan struct{})
+	)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = ctr.Wrap(ctx, "key", func(ctx context.Context) (int, error) {
+				calls.Add(1)
+				<-release
+				return 1, nil
+			})
+		}()
+	}
+	require.Eventually(t, func() bool { return ctr.Stats().Misses == 5 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	require.Equal(t, int64(5), calls.Load()+shared.Load())
+	require.Equal(t, float64(shared.Load()), counterValue(t, plugin.(*MetricsPlugin).shared.WithLabelValues("test-shared")))
+	require.Equal(t, uint64(shared.Load()), ctr.Stats().SharedCalls)
+}
+
+func TestLimitQueryPluginWithMaxWait(t *testing.T) {
+	ctx := context.Background()
+	// 每秒 1 个令牌, 第二次 query 需要等待约 1 秒
+	ctr := NewCacheController[int]("test-limit-max-wait", NewMemoryStore(),
+		WithPlugins[int](NewLimitQueryPluginWithMaxWait(rate.Limit(1), 1, 10*time.Millisecond)))
+	query := func(ctx context.Context) (int, error) { return 1, nil }
+
+	res, err := ctr.Wrap(ctx, "key1", query)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+
+	start := time.Now()
+	_, err = ctr.Wrap(ctx, "key2", query)
+	require.ErrorIs(t, err, ErrRateLimited)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// 命中缓存不受限流影响
+	res, err = ctr.Wrap(ctx, "key1", query)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+}
+
+func TestStaleServed(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	plugin := NewMetricsPluginWithRegistry("test-stale", prometheus.NewRegistry())
+	var hooked atomic.Int64
+	ctr := NewCacheController[int]("test-stale", store,
+		WithPolicy[int](ReuseCachePloyIgnoreError(time.Minute)),
+		WithPlugins[int](plugin),
+		WithOnStaleServe[int](func(ctx context.Context, key string) { hooked.Add(1) }))
+	failed := func(ctx context.Context) (int, error) { return 0, errors.New("query failed") }
+
+	// 新鲜的缓存不记录
+	require.NoError(t, ctr.SetStore(ctx, "key", 1, KeepTTL))
+	_, err := ctr.Wrap(ctx, "key", failed)
+	require.NoError(t, err)
+	require.Zero(t, ctr.Stats().StaleServed)
+
+	// query 失败返回过期的缓存
+	require.NoError(t, store.Set(ctx, "key", &AbcBox[int]{T: 1, Timestamp: int(time.Now().Add(-time.Hour).Unix())}, KeepTTL))
+	res, err := ctr.Wrap(ctx, "key", failed)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+	require.Equal(t, uint64(1), ctr.Stats().StaleServed)
+	require.Equal(t, int64(1), hooked.Load())
+	require.Equal(t, float64(1), counterValue(t, plugin.(*MetricsPlugin).stale.WithLabelValues("test-stale")))
+}
@@ -1,95 +1,365 @@
-package modecache
-
-import (
-	"context"
-	"time"
-)
-
-// EasyPloy 创建简单策略模型
-// 该模式会先尝试访问缓存，如果缓存发生过期则尝试访问数据库，如果数据库也获取失败则返回错误。
-func EasyPloy(ttl time.Duration) Policy {
-	sg := SingleflightGroup{}
-
-	return func(ctx context.Context, key string, loadingQuery LoadingForQuery, loadingCache LoadingForCache) (any, error) {
-		value, _, qErr := loadingCache(ctx, key)
-		if qErr == nil {
-			return value, nil
-		}
-		value, err, _ := sg.Do(ctx, key, func() (any, error) {
-			return loadingQuery(ctx, key, ttl)
-		})
-		if err != nil {
-			return nil, err
-		}
-		return value, nil
-	}
-}
-
-// ReuseCachePloyIgnoreError 创建一个使用重用缓存的访问模式
-// 重用缓存模型，会把数据长时间的存储到缓存中，使用业务过期时间 expireTime 来控制缓存的过期，
-// 并且在 下游 query 接口无法调用成功的场景，使用缓存数据完成服务
-// # 注意如果命中缓存，那么当 query 执行失败时，这个策略会重复使用缓存数据，直到 query 执行成功为止。
-func ReuseCachePloyIgnoreError(expireTime time.Duration) Policy {
-	const ttl = KeepTTL // 默认存储 7 天
-	sg := SingleflightGroup{}
-
-	return func(ctx context.Context, key string, loadingQuery LoadingForQuery, loadingCache LoadingForCache) (any, error) {
-		var isReuse = false
-		result, timestamp, cErr := loadingCache(ctx, key)
-		if cErr == nil {
-			isReuse = true
-			if time.Now().Unix()-int64(timestamp) < int64(expireTime.Seconds()) {
-				return result, nil
-			}
-		}
-		value, qErr, _ := sg.Do(ctx, key, func() (any, error) {
-			return loadingQuery(ctx, key, ttl)
-		})
-		if qErr == nil {
-			return value, nil
-		}
-		if isReuse {
-			return result, nil
-		}
-		return nil, qErr
-	}
-}
-
-// FirstCachePolyIgnoreError 创建一个快速缓存模型
-// 快速缓存模型，会长时间保存缓存，并且优先使用缓存，使用业务过期时间 expireTime 来控制缓存是否过期，如果缓存过期会
-// 拉起一个单例携程来访问 query 异步刷新缓存，并且返回本次获取到的缓存中的数据，如果访问缓存失败，则退化为简单缓存模型
-// # 注意如果命中缓存，那么当 query 执行失败时，这个策略会重复使用缓存数据，直到 query 执行成功为止。
-func FirstCachePolyIgnoreError(expireTime time.Duration) Policy {
-	const ttl = KeepTTL
-	sg := SingleflightGroup{}
-	mu := Mutex128{}
-
-	return func(ctx context.Context, key string, loadingQuery LoadingForQuery, loadingCache LoadingForCache) (any, error) {
-		var isReuse bool
-		result, timestamp, cErr := loadingCache(ctx, key)
-		if cErr == nil {
-			isReuse = true
-			if time.Now().Unix()-int64(timestamp) < int64(expireTime.Seconds()) {
-				return result, nil
-			}
-		}
-		// 无法重用缓存, 降级为策略模式
-		if !isReuse {
-			value, err, _ := sg.Do(ctx, key, func() (interface{}, error) {
-				return loadingQuery(ctx, key, ttl)
-			})
-			return value, err
-		}
-		shard := hashCrc32ToUint(key)
-		if mu.TryLock(shard) {
-			GO(func() {
-				defer mu.Unlock(shard)
-				nCtx := context.WithoutCancel(ctx)
-				nCtx, cancel := context.WithTimeout(nCtx, expireTime)
-				defer cancel()
-				_, _ = loadingQuery(nCtx, key, ttl)
-			})
-		}
-		return result, nil
-	}
-}
+package modecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EasyPloy 创建简单策略模型
+// 该模式会先尝试访问缓存，如果缓存发生过期则尝试访问数据库，如果数据库也获取失败则返回错误。
+func EasyPloy(ttl time.Duration) Policy {
+	sg := SingleflightGroup{}
+
+	return func(ctx context.Context, key string, loadingQuery LoadingForQuery, loadingCache LoadingForCache) (any, error) {
+		value, _, qErr := loadingCache(ctx, key)
+		if qErr == nil {
+			return value, nil
+		}
+		value, err, _ := sg.Do(ctx, key, func() (any, error) {
+			return loadingQuery(ctx, key, ttl)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+}
+
+// ReuseCachePloyIgnoreError 创建一个使用重用缓存的访问模式
+// 重用缓存模型，会把数据长时间的存储到缓存中，使用业务过期时间 expireTime 来控制缓存的过期，
+// 并且在 下游 query 接口无法调用成功的场景，使用缓存数据完成服务
+// 可以通过 opts 使用 WithReuseTimeout 限制有缓存数据时同步等待 query 的时间, 使用 WithMaxStale 限制重用数据的最大时长
+// # 注意如果命中缓存，那么当 query 执行失败时，这个策略会重复使用缓存数据，直到 query 执行成功为止。
+func ReuseCachePloyIgnoreError(expireTime time.Duration, opts ...PolicyOption) Policy {
+	const ttl = KeepTTL // 默认存储 7 天
+	o := newPolicyOptions(opts...)
+	sg := SingleflightGroup{forgetAfter: o.forgetAfter}
+	var calls sync.Map // 有缓存数据时后台执行的 query, key -> *reuseCall
+
+	return func(ctx context.Context, key string, loadingQuery LoadingForQuery, loadingCache LoadingForCache) (any, error) {
+		var isReuse = false
+		result, createdMs, _, cErr := loadCacheMeta(ctx, key, loadingCache)
+		if cErr == nil {
+			if isFresh(createdMs, expireTime) {
+				return result, nil
+			}
+			// 超过最大时长的数据不再重用
+			isReuse = o.maxStale <= 0 || isFresh(createdMs, o.maxStale)
+		}
+		if isReuse && o.reuseTimeout > 0 {
+			return reuseWithTimeout(ctx, key, &sg, &calls, o, result, func(ctx context.Context) (any, error) {
+				return loadingQuery(ctx, key, ttl)
+			}), nil
+		}
+		value, qErr, _ := sg.Do(ctx, key, func() (any, error) {
+			return loadingQuery(ctx, key, ttl)
+		})
+		if qErr == nil {
+			return value, nil
+		}
+		if isReuse {
+			recordStaleErr(ctx, qErr)
+			recordStaleServed(ctx, key)
+			return result, nil
+		}
+		return nil, qErr
+	}
+}
+
+// errReuseAborted 后台 query 没有返回结果 (例如 panic)
+var errReuseAborted = errors.New("modecache: reuse query aborted")
+
+// reuseCall 有缓存数据时后台执行的 query, 相同 key 的调用等待同一次执行
+type reuseCall struct {
+	done  chan struct{} // 执行结束后关闭
+	value any
+	err   error
+}
+
+// reuseWithTimeout 在后台执行 query, 最多等待 reuseTimeout, query 失败、超时或者调用方取消时返回缓存数据 stale
+// 后台 query 脱离调用方的取消, 使用 refreshTimeout 作为超时时间, 超时返回后 query 依然会继续执行并更新缓存
+// 相同 key 同时只启动一个后台协程, 其他调用通过 calls 等待同一次执行, 不会为每次调用启动协程
+func reuseWithTimeout(ctx context.Context, key string, sg *SingleflightGroup, calls *sync.Map, o *policyOptions, stale any,
+	query func(ctx context.Context) (any, error)) any {
+	call := &reuseCall{done: make(chan struct{}), err: errReuseAborted}
+	if actual, loaded := calls.LoadOrStore(key, call); loaded {
+		call = actual.(*reuseCall)
+		if recorder, ok := ctx.Value(ctxSharedKey{}).(sharedRecorder); ok {
+			recorder.recordShared(ctx, key)
+		}
+	} else {
+		GO(func() {
+			defer func() {
+				calls.Delete(key)
+				close(call.done)
+			}()
+			nCtx, cancel := context.WithTimeout(detachContext(ctx), o.refreshTimeout)
+			defer cancel()
+			call.value, call.err, _ = sg.Do(nCtx, key, func() (any, error) {
+				return query(nCtx)
+			})
+		})
+	}
+
+	timer := time.NewTimer(o.reuseTimeout)
+	defer timer.Stop()
+	select {
+	case <-call.done:
+		if call.err == nil {
+			return call.value
+		}
+		recordStaleErr(ctx, call.err)
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	recordStaleServed(ctx, key)
+	return stale
+}
+
+// ReuseCachePloyWithTimeout 创建一个使用重用缓存的访问模式, 有缓存数据时最多同步等待 query queryTimeout
+// 超时后返回缓存中的数据, query 在后台继续执行并更新缓存
+// # 注意如果命中缓存，那么当 query 执行失败时，这个策略会重复使用缓存数据，直到 query 执行成功为止。
+func ReuseCachePloyWithTimeout(expireTime time.Duration, queryTimeout time.Duration) Policy {
+	return ReuseCachePloyIgnoreError(expireTime, WithReuseTimeout(queryTimeout))
+}
+
+// ReuseCachePloyWithMaxStale 创建一个使用重用缓存的访问模式, 缓存数据的时长超过 maxStale 后不再重用,
+// query 失败时返回 query 的错误, 保证故障期间返回的数据最多过期 maxStale, maxStale 应该大于 expireTime
+func ReuseCachePloyWithMaxStale(expireTime time.Duration, maxStale time.Duration) Policy {
+	return ReuseCachePloyIgnoreError(expireTime, WithMaxStale(maxStale))
+}
+
+// DefaultRefreshTimeout FirstCachePolyIgnoreError 后台刷新缓存的默认超时时间
+const DefaultRefreshTimeout = 5 * time.Second
+
+// FirstCachePolyIgnoreError 创建一个快速缓存模型
+// 快速缓存模型，会长时间保存缓存，并且优先使用缓存，使用业务过期时间 expireTime 来控制缓存是否过期，如果缓存过期会
+// 拉起一个单例携程来访问 query 异步刷新缓存，并且返回本次获取到的缓存中的数据，如果访问缓存失败，则退化为简单缓存模型
+// 后台刷新使用 DefaultRefreshTimeout 作为超时时间, 可以通过 opts 配置刷新超时时间、分片锁和刷新协程池
+// # 注意如果命中缓存，那么当 query 执行失败时，这个策略会重复使用缓存数据，直到 query 执行成功为止。
+func FirstCachePolyIgnoreError(expireTime time.Duration, opts ...PolicyOption) Policy {
+	const ttl = KeepTTL
+	o := newPolicyOptions(opts...)
+	sg := SingleflightGroup{forgetAfter: o.forgetAfter}
+	mu := NewShardedMutex(o.shards)
+	refreshTimeout := o.refreshTimeout
+	var pool *refreshPool
+	if o.refreshWorkers > 0 {
+		pool = newRefreshPool(o.refreshWorkers, o.refreshQueue)
+	}
+
+	return func(ctx context.Context, key string, loadingQuery LoadingForQuery, loadingCache LoadingForCache) (any, error) {
+		var isReuse bool
+		result, createdMs, _, cErr := loadCacheMeta(ctx, key, loadingCache)
+		if cErr == nil {
+			isReuse = true
+			if isFresh(createdMs, expireTime) {
+				return result, nil
+			}
+		}
+		// 无法重用缓存, 降级为策略模式
+		if !isReuse {
+			value, err, _ := sg.Do(ctx, key, func() (interface{}, error) {
+				return loadingQuery(ctx, key, ttl)
+			})
+			return value, err
+		}
+		shard := o.shardHasher(key)
+		if mu.TryLock(shard) {
+			refresh := func() {
+				defer mu.Unlock(shard)
+				nCtx := detachContext(ctx)
+				nCtx, cancel := context.WithTimeout(nCtx, refreshTimeout)
+				defer cancel()
+				if o.locker != nil {
+					unlock, ok, err := o.locker.TryLock(nCtx, key)
+					switch {
+					case err != nil && !o.lockFallback:
+						return
+					case err != nil:
+						// 分布式锁不可用, 退化为只使用本地分片锁
+					case !ok:
+						// 其他节点正在刷新
+						return
+					default:
+						defer unlock()
+					}
+				}
+				_, _ = loadingQuery(nCtx, key, ttl)
+			}
+			switch {
+			case pool == nil:
+				GO(refresh)
+			case !pool.TrySubmit(refresh):
+				// 任务池已满, 放弃本次刷新
+				mu.Unlock(shard)
+			}
+		}
+		recordStaleServed(ctx, key)
+		return result, nil
+	}
+}
+
+// FirstCachePolyIgnoreErrorWithTimeout 创建一个快速缓存模型, 使用 refreshTimeout 控制后台刷新的超时时间
+// 后台刷新期间会持有 key 对应的分片锁, 刷新超时后释放分片锁, 避免下游阻塞时长时间占用分片锁
+// # 注意如果命中缓存，那么当 query 执行失败时，这个策略会重复使用缓存数据，直到 query 执行成功为止。
+func FirstCachePolyIgnoreErrorWithTimeout(expireTime time.Duration, refreshTimeout time.Duration) Policy {
+	return FirstCachePolyIgnoreError(expireTime, WithRefreshTimeout(refreshTimeout))
+}
+
+// AsyncRefreshPloy 创建异步刷新策略模型, 适用于不能等待刷新的场景 (例如看板)
+// 有缓存时无论数据多旧都立即返回, 数据创建时间超过 ttl 时在后台通过 singleflight 刷新缓存; 只有缓存不存在时同步执行 query
+// 和 FirstCachePolyIgnoreError 的区别是后台刷新不使用分片锁, 同一个 key 的后台刷新和同步 query 通过 singleflight 合并
+// 后台刷新使用 DefaultRefreshTimeout 作为超时时间, 可以通过 WithRefreshTimeout 配置
+// # 注意后台刷新失败时继续返回缓存数据, 直到刷新成功为止。
+func AsyncRefreshPloy(ttl time.Duration, opts ...PolicyOption) Policy {
+	const storeTTL = KeepTTL
+	o := newPolicyOptions(opts...)
+	sg := SingleflightGroup{forgetAfter: o.forgetAfter}
+	var refreshing sync.Map // 正在后台刷新的 key
+
+	return func(ctx context.Context, key string, loadingQuery LoadingForQuery, loadingCache LoadingForCache) (any, error) {
+		result, createdMs, _, cErr := loadCacheMeta(ctx, key, loadingCache)
+		if cErr != nil {
+			value, err, _ := sg.Do(ctx, key, func() (any, error) {
+				return loadingQuery(ctx, key, storeTTL)
+			})
+			return value, err
+		}
+		if !isFresh(createdMs, ttl) {
+			recordStaleServed(ctx, key)
+			// 相同 key 正在刷新 (后台刷新或者同步 query) 时不再启动协程, 避免热点 key 堆积等待 singleflight 的协程
+			if _, ok := sg.inflight.Load(key); ok {
+				return result, nil
+			}
+			if _, loaded := refreshing.LoadOrStore(key, struct{}{}); loaded {
+				return result, nil
+			}
+			nCtx := detachContext(ctx)
+			GO(func() {
+				defer refreshing.Delete(key)
+				nCtx, cancel := context.WithTimeout(nCtx, o.refreshTimeout)
+				defer cancel()
+				_, _, _ = sg.Do(nCtx, key, func() (any, error) {
+					return loadingQuery(nCtx, key, storeTTL)
+				})
+			})
+		}
+		return result, nil
+	}
+}
+
+// ProbabilisticPloy 创建概率提前过期策略模型 (XFetch)
+// 该模式根据上次 query 的执行耗时 delta 和随机因子, 在缓存过期前概率性的提前刷新缓存,
+// 当 now - timestamp - delta * beta * ln(rand) >= ttl 时同步刷新缓存, 使不同节点在过期前的不同时间刷新, 避免缓存击穿。
+// beta 越大越倾向于提前刷新, 通常使用 1.0, 提前刷新失败时继续使用未过期的缓存数据。
+func ProbabilisticPloy(ttl time.Duration, beta float64) Policy {
+	sg := SingleflightGroup{}
+
+	return func(ctx context.Context, key string, loadingQuery LoadingForQuery, loadingCache LoadingForCache) (any, error) {
+		result, createdMs, meta, cErr := loadCacheMeta(ctx, key, loadingCache)
+		if cErr == nil && !xFetchShouldRefresh(createdMs, meta.ComputeMs, ttl, beta) {
+			return result, nil
+		}
+		value, qErr, _ := sg.Do(ctx, key, func() (any, error) {
+			return loadingQuery(ctx, key, ttl)
+		})
+		if qErr == nil {
+			return value, nil
+		}
+		if cErr == nil {
+			recordStaleErr(ctx, qErr)
+			if !isFresh(createdMs, ttl) {
+				recordStaleServed(ctx, key)
+			}
+			return result, nil
+		}
+		return nil, qErr
+	}
+}
+
+// loadCacheMeta 读取缓存并获取缓存的元信息, 返回毫秒精度的数据创建时间, 旧数据使用秒级时间戳
+func loadCacheMeta(ctx context.Context, key string, loadingCache LoadingForCache) (any, int64, *BoxMeta, error) {
+	meta := &BoxMeta{}
+	result, timestamp, err := loadingCache(WithBoxMeta(ctx, meta), key)
+	return result, meta.createdMs(timestamp), meta, err
+}
+
+// dataAge 计算数据的年龄, 数据时间戳晚于当前时间 (时钟回拨或者写入节点的时钟超前) 时返回 0
+// skewed 表示时间戳超前的时间不小于 limit, 这种数据的时间戳不可信, 应该视为过期, 避免在时钟追上之前一直被视为新鲜
+func dataAge(createdMs int64, limit time.Duration) (age time.Duration, skewed bool) {
+	age = now().Sub(time.UnixMilli(createdMs))
+	if age < 0 {
+		return 0, -age >= limit
+	}
+	return age, false
+}
+
+// recordStaleServed 策略返回超过业务过期时间的缓存时, 记录到上下文中的控制器 (统计, OnStaleServe 回调和 StaleObserver 插件)
+func recordStaleServed(ctx context.Context, key string) {
+	if recorder, ok := ctx.Value(ctxStaleServedKey{}).(staleRecorder); ok {
+		recorder.recordStaleServed(ctx, key)
+	}
+}
+
+type ctxStaleErrKey struct{}
+
+// detachContext 获取后台刷新使用的上下文, 脱离调用方的取消, 并移除只对发起请求的调用方生效的设置
+// TryWrap 的不等待和 WithQueryTimeout 的超时只约束调用方, 不能让共享的后台刷新立即返回 ErrQueryInFlight 或者提前超时
+func detachContext(ctx context.Context) context.Context {
+	ctx = context.WithValue(context.WithoutCancel(ctx), ctxNoWaitKey{}, false)
+	return context.WithValue(ctx, ctxQueryTimeoutKey{}, time.Duration(0))
+}
+
+// recordStaleErr 策略 query 失败并返回旧数据时, 记录 query 的错误, 由 WrapOrError 返回给调用方
+func recordStaleErr(ctx context.Context, err error) {
+	if staleErr, ok := ctx.Value(ctxStaleErrKey{}).(*error); ok {
+		*staleErr = err
+	}
+}
+
+// isFresh 判断数据是否在业务过期时间 expireTime 内, 时间戳超前不小于 expireTime 的数据视为过期
+func isFresh(createdMs int64, expireTime time.Duration) bool {
+	age, skewed := dataAge(createdMs, expireTime)
+	return !skewed && age < expireTime
+}
+
+// xFetchShouldRefresh 判断是否需要提前刷新缓存
+func xFetchShouldRefresh(createdMs int64, computeMs int64, ttl time.Duration, beta float64) bool {
+	age, skewed := dataAge(createdMs, ttl)
+	if skewed {
+		return true
+	}
+	gap := time.Duration(-float64(computeMs) * beta * math.Log(1-rand.Float64()) * float64(time.Millisecond))
+	return age+gap >= ttl
+}
+
+// 策略名称, 用于 PolicyByName
+const (
+	PolicyNameEasy  = "easy"  // EasyPloy
+	PolicyNameReuse = "reuse" // ReuseCachePloyIgnoreError
+	PolicyNameFirst = "first" // FirstCachePolyIgnoreError
+)
+
+// PolicyByName 根据名称创建策略, 适用于通过配置文件选择策略, 名称不区分大小写
+// ttl 对应策略构造方法的第一个参数: easy 为缓存过期时间, reuse/first 为业务过期时间
+// 未知的名称返回 ErrUnknownPolicy 错误
+func PolicyByName(name string, ttl time.Duration) (Policy, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case PolicyNameEasy:
+		return EasyPloy(ttl), nil
+	case PolicyNameReuse:
+		return ReuseCachePloyIgnoreError(ttl), nil
+	case PolicyNameFirst:
+		return FirstCachePolyIgnoreError(ttl), nil
+	default:
+		return nil, fmt.Errorf("%w: %q, want one of %q, %q, %q",
+			ErrUnknownPolicy, name, PolicyNameEasy, PolicyNameReuse, PolicyNameFirst)
+	}
+}
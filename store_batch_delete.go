@@ -0,0 +1,97 @@
+package modecache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBatchDeleteInterval = 100 * time.Millisecond // DefaultBatchDeleteInterval 合并删除的默认间隔
+	batchDeleteTimeout         = 5 * time.Second        // 单次批量删除的超时时间
+)
+
+// batchDeleter 合并删除请求的后台删除器
+type batchDeleter struct {
+	store    Store
+	maxBatch int
+	keys     chan string
+	pending  map[string]struct{} // 等待删除的 key, 相同 key 只删除一次
+	stopped  chan struct{}
+	once     sync.Once
+}
+
+// flush 删除等待删除的 key, 存储实现 BatchDeleter 时一次删除, 删除失败的 key 会被丢弃
+// 每次删除使用 batchDeleteTimeout 超时, 避免存储阻塞时后台协程和关闭方法一直等待
+func (b *batchDeleter) flush() {
+	if len(b.pending) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), batchDeleteTimeout)
+	defer cancel()
+	keys := make([]string, 0, len(b.pending))
+	for key := range b.pending {
+		keys = append(keys, key)
+	}
+	clear(b.pending)
+
+	if deleter, ok := b.store.(BatchDeleter); ok {
+		_ = deleter.DelMulti(ctx, keys)
+		return
+	}
+	for _, key := range keys {
+		_ = b.store.Del(ctx, key)
+	}
+}
+
+func (b *batchDeleter) run(interval time.Duration) {
+	defer close(b.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case key, ok := <-b.keys:
+			if !ok {
+				b.flush()
+				return
+			}
+			b.pending[key] = struct{}{}
+			if b.maxBatch > 0 && len(b.pending) >= b.maxBatch {
+				b.flush()
+			}
+		case <-ticker.C:
+			b.flush()
+		}
+	}
+}
+
+// close 关闭 key 通道, 等待剩余的 key 删除完成
+func (b *batchDeleter) close() {
+	b.once.Do(func() {
+		close(b.keys)
+		<-b.stopped
+	})
+}
+
+// NewBatchDeleter 创建合并删除的后台删除器, 适用于消费变更消息等大量失效缓存的场景, 生产者不需要等待存储删除
+// 写入通道的 key 在后台合并, 每 interval 或者达到 maxBatch 个 key 时批量删除, 存储实现 BatchDeleter 时使用一次批量删除
+// interval <= 0 时使用 DefaultBatchDeleteInterval
+// return: 写入需要删除的 key 的通道, 关闭方法 (删除剩余的 key 并停止后台协程)
+// # 注意删除失败的 key 会被丢弃; 关闭方法会关闭通道, 调用前需要停止所有生产者, 关闭后写入通道会 panic
+func NewBatchDeleter(store Store, interval time.Duration, maxBatch int) (chan<- string, func()) {
+	if interval <= 0 {
+		interval = DefaultBatchDeleteInterval
+	}
+	b := &batchDeleter{
+		store:    store,
+		maxBatch: maxBatch,
+		keys:     make(chan string, max(maxBatch, 1)),
+		pending:  make(map[string]struct{}),
+		stopped:  make(chan struct{}),
+	}
+	GO(func() {
+		b.run(interval)
+	})
+	return b.keys, b.close
+}
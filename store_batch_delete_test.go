@@ -0,0 +1,94 @@
+package modecache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// batchCountStore 记录批量删除的存储
+type batchCountStore struct {
+	Store
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (b *batchCountStore) DelMulti(ctx context.Context, keys []string) error {
+	b.mu.Lock()
+	b.batches = append(b.batches, keys)
+	b.mu.Unlock()
+	for _, key := range keys {
+		_ = b.Store.Del(ctx, key)
+	}
+	return nil
+}
+
+func (b *batchCountStore) Batches() [][]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.batches
+}
+
+func TestBatchDeleter(t *testing.T) {
+	ctx := context.Background()
+	store := &batchCountStore{Store: NewMemoryStore()}
+	for _, key := range []string{"a", "b", "c"} {
+		assert.NoError(t, store.Set(ctx, key, 1, time.Minute))
+	}
+
+	// 达到 maxBatch 时批量删除, 相同 key 合并
+	keys, closer := NewBatchDeleter(store, time.Hour, 2)
+	keys <- "a"
+	keys <- "a"
+	keys <- "b"
+	assert.Eventually(t, func() bool { return len(store.Batches()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.ElementsMatch(t, []string{"a", "b"}, store.Batches()[0])
+
+	// 关闭时删除剩余的 key
+	keys <- "c"
+	closer()
+	closer()
+	assert.Len(t, store.Batches(), 2)
+	_, err := store.Get(ctx, "c")
+	assert.ErrorIs(t, err, ErrKeyNonExistent)
+}
+
+func TestBatchDeleter_Interval(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	assert.NoError(t, store.Set(ctx, "key", 1, time.Minute))
+
+	// 未实现 BatchDeleter 的存储逐个删除
+	keys, closer := NewBatchDeleter(store, 10*time.Millisecond, 0)
+	defer closer()
+	keys <- "key"
+	assert.Eventually(t, func() bool {
+		_, err := store.Get(ctx, "key")
+		return err != nil
+	}, time.Second, 5*time.Millisecond)
+}
+
+// deadlineStore 记录删除使用的上下文是否设置了超时
+type deadlineStore struct {
+	Store
+	hasDeadline chan bool
+}
+
+func (d *deadlineStore) Del(ctx context.Context, key string) error {
+	_, ok := ctx.Deadline()
+	d.hasDeadline <- ok
+	return d.Store.Del(ctx, key)
+}
+
+func TestBatchDeleter_DefaultInterval(t *testing.T) {
+	store := &deadlineStore{Store: NewMemoryStore(), hasDeadline: make(chan bool, 1)}
+
+	// 非正数的间隔使用默认值, 不会 panic; 删除使用有超时的上下文
+	keys, closer := NewBatchDeleter(store, 0, 0)
+	keys <- "key"
+	closer()
+	assert.True(t, <-store.hasDeadline)
+}
@@ -1,47 +1,165 @@
-package modecache
-
-import (
-	"context"
-	"time"
-
-	"github.com/patrickmn/go-cache"
-)
-
-type cacheStore struct {
-	libCache *cache.Cache
-}
-
-// Get 获取缓存。当缓存键不存在时返回 ErrKeyNonExistent 错误。
-// return: 数据，数据创建时间，错误
-func (c cacheStore) Get(ctx context.Context, key string) (any, error) {
-	value, ok := c.libCache.Get(key)
-	if !ok {
-		return nil, ErrKeyNonExistent
-	}
-
-	return value, nil
-}
-
-// Set 设置缓存。
-func (c cacheStore) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
-	if ttl == KeepTTL {
-		c.libCache.Set(key, data, KeepTTL)
-		return nil
-	}
-	c.libCache.Set(key, data, ttl)
-	return nil
-}
-
-// Del 删除缓存。
-func (c cacheStore) Del(ctx context.Context, key string) error {
-	c.libCache.Delete(key)
-	return nil
-}
-
-func (c cacheStore) IsDirectStore() bool {
-	return true
-}
-
-func NewCacheStore(c *cache.Cache) Store {
-	return cacheStore{libCache: c}
-}
+package modecache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+type cacheStore struct {
+	libCache *cache.Cache
+	mu       *Mutex128 // 条件写入的分片锁
+}
+
+// Get 获取缓存。当缓存键不存在时返回 ErrKeyNonExistent 错误。
+// return: 数据，数据创建时间，错误
+func (c cacheStore) Get(ctx context.Context, key string) (any, error) {
+	value, ok := c.libCache.Get(key)
+	if !ok {
+		return nil, ErrKeyNonExistent
+	}
+
+	return value, nil
+}
+
+// Set 设置缓存。
+// 只有 KeepTTL 表示永久存储, 其余 <= 0 的 ttl 返回 ErrInvalidTTL 错误
+func (c cacheStore) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
+	if ttl == KeepTTL {
+		c.libCache.Set(key, data, cache.NoExpiration)
+		return nil
+	}
+	if ttl <= 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidTTL, ttl)
+	}
+	c.libCache.Set(key, data, ttl)
+	return nil
+}
+
+// Exists 判断缓存是否存在, 过期未清理的缓存视为不存在
+func (c cacheStore) Exists(ctx context.Context, key string) (bool, error) {
+	_, ok := c.libCache.Get(key)
+	return ok, nil
+}
+
+// Del 删除缓存。
+func (c cacheStore) Del(ctx context.Context, key string) error {
+	c.libCache.Delete(key)
+	return nil
+}
+
+// DelMulti 删除 keys 中的全部缓存
+func (c cacheStore) DelMulti(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		c.libCache.Delete(key)
+	}
+	return nil
+}
+
+// Touch 延长缓存的过期时间, 使用原有数据和新的过期时间重新写入
+// # 注意读取和写入不是原子操作, 并发写入时可能覆盖新数据
+func (c cacheStore) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	value, ok := c.libCache.Get(key)
+	if !ok {
+		return ErrKeyNonExistent
+	}
+	return c.Set(ctx, key, value, ttl)
+}
+
+// SetIfNewer 只有缓存中的数据不比 timestampMs 更新时才写入, 使用分片锁保证比较和写入的原子性
+// # 注意只和 SetIfNewer 互斥, 并发的 Set 仍然可能覆盖
+func (c cacheStore) SetIfNewer(ctx context.Context, key string, data any, timestampMs int64, ttl time.Duration) (bool, error) {
+	shard := hashCrc32ToUint(key)
+	c.mu.Lock(shard)
+	defer c.mu.Unlock(shard)
+
+	if value, ok := c.libCache.Get(key); ok {
+		if box, ok := value.(boxTimestamp); ok && box.boxTimestampMs() > timestampMs {
+			return false, nil
+		}
+	}
+	return true, c.Set(ctx, key, data, ttl)
+}
+
+// TTL 获取缓存的剩余过期时间, 永不过期时返回 KeepTTL
+func (c cacheStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	_, expiration, ok := c.libCache.GetWithExpiration(key)
+	if !ok {
+		return 0, ErrKeyNonExistent
+	}
+	if expiration.IsZero() {
+		return KeepTTL, nil
+	}
+	return max(time.Until(expiration), 0), nil
+}
+
+// Clear 清空本地缓存
+func (c cacheStore) Clear(ctx context.Context) error {
+	c.libCache.Flush()
+	return nil
+}
+
+// Keys 列出未过期的缓存键, 最多返回 limit 个
+func (c cacheStore) Keys(ctx context.Context, limit int) ([]string, error) {
+	items, err := c.Dump(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Dump 列出未过期的缓存数据, 最多返回 limit 个
+// # 注意 go-cache 的 Items 会复制全部缓存, 不受 limit 限制
+func (c cacheStore) Dump(ctx context.Context, limit int) (map[string]any, error) {
+	res := make(map[string]any)
+	for key, item := range c.libCache.Items() {
+		if len(res) >= limit {
+			break
+		}
+		res[key] = item.Object
+	}
+	return res, nil
+}
+
+// DelByPrefix 删除 key 以 prefix 开头的缓存, 返回删除的数量, 实现 PatternDeleter
+// 遍历 go-cache 的 Items 副本, 不会和并发写入冲突; 遍历期间写入的 key 不会被删除
+// 每次删除前检查 ctx, ctx 取消时返回已经删除的数量和 ctx 的错误
+func (c cacheStore) DelByPrefix(ctx context.Context, prefix string) (int, error) {
+	var deleted int
+	for key := range c.libCache.Items() {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+		c.libCache.Delete(key)
+		deleted++
+	}
+	return deleted, nil
+}
+
+func (c cacheStore) IsDirectStore() bool {
+	return true
+}
+
+func NewCacheStore(c *cache.Cache) Store {
+	return cacheStore{libCache: c, mu: &Mutex128{}}
+}
+
+// NewCacheStoreWithEviction 创建本地缓存, 并注册 go-cache 的 OnEvicted 回调, 缓存被删除或者过期清理时调用 onEvict
+// value 为 Store 中保存的数据, 控制器写入的数据为 *AbcBox[T]
+// # 注意 go-cache 在执行删除的协程中同步调用回调 (Del 的调用方或者 janitor 协程), 回调可能被并发调用, 需要快速返回并保证并发安全
+// # 注意回调在 go-cache 释放锁之后调用, 可以访问缓存; 但过期数据只在 janitor 清理时触发回调, 覆盖写入和 Flush (Clear) 不会触发
+// # 注意 go-cache 只支持一个回调, 会覆盖 c 上已经注册的 OnEvicted
+func NewCacheStoreWithEviction(c *cache.Cache, onEvict func(key string, value any)) Store {
+	c.OnEvicted(onEvict)
+	return NewCacheStore(c)
+}
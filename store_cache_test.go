@@ -1,74 +1,190 @@
-package modecache
-
-import (
-	"context"
-	"testing"
-	"time"
-
-	"github.com/stretchr/testify/assert"
-)
-
-func TestCacheStore_Get(t *testing.T) {
-	// 创建缓存对象
-	cache := getTestLocalCache()
-	// 创建 cacheStore 对象
-	store := NewCacheStore(cache)
-
-	// 设置缓存
-	err := store.Set(context.Background(), "key", 123, time.Hour)
-	assert.NoError(t, err)
-
-	// 获取缓存
-	value, err := store.Get(context.Background(), "key")
-	assert.NoError(t, err)
-	assert.Equal(t, 123, value)
-}
-
-func TestCacheStore_Get_NonExistent(t *testing.T) {
-	// 创建缓存对象
-	cache := getTestLocalCache()
-	// 创建 cacheStore 对象
-	store := NewCacheStore(cache)
-
-	// 获取不存在的缓存
-	value, err := store.Get(context.Background(), "key")
-	assert.EqualError(t, err, ErrKeyNonExistent.Error())
-	assert.Zero(t, value)
-}
-
-func TestCacheStore_Set(t *testing.T) {
-	// 创建缓存对象
-	cache := getTestLocalCache()
-
-	// 创建 cacheStore 对象
-	store := NewCacheStore(cache)
-
-	// 设置缓存
-	err := store.Set(context.Background(), "key", 123, time.Hour)
-	assert.NoError(t, err)
-
-	// 验证缓存是否设置成功
-	value, ok := cache.Get("key")
-	assert.True(t, ok)
-	assert.Equal(t, 123, value)
-}
-
-func TestCacheStore_Del(t *testing.T) {
-	// 创建缓存对象
-	cache := getTestLocalCache()
-
-	// 创建 cacheStore 对象
-	store := NewCacheStore(cache)
-
-	// 设置缓存
-	err := store.Set(context.Background(), "key", 123, time.Hour)
-	assert.NoError(t, err)
-
-	// 删除缓存
-	err = store.Del(context.Background(), "key")
-	assert.NoError(t, err)
-
-	// 验证缓存是否删除成功
-	_, ok := cache.Get("key")
-	assert.False(t, ok)
-}
+package modecache
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheStore_Get(t *testing.T) {
+	// 创建缓存对象
+	cache := getTestLocalCache()
+	// 创建 cacheStore 对象
+	store := NewCacheStore(cache)
+
+	// 设置缓存
+	err := store.Set(context.Background(), "key", 123, time.Hour)
+	assert.NoError(t, err)
+
+	// 获取缓存
+	value, err := store.Get(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.Equal(t, 123, value)
+}
+
+func TestCacheStore_Get_NonExistent(t *testing.T) {
+	// 创建缓存对象
+	cache := getTestLocalCache()
+	// 创建 cacheStore 对象
+	store := NewCacheStore(cache)
+
+	// 获取不存在的缓存
+	value, err := store.Get(context.Background(), "key")
+	assert.EqualError(t, err, ErrKeyNonExistent.Error())
+	assert.Zero(t, value)
+}
+
+func TestCacheStore_Set(t *testing.T) {
+	// 创建缓存对象
+	cache := getTestLocalCache()
+
+	// 创建 cacheStore 对象
+	store := NewCacheStore(cache)
+
+	// 设置缓存
+	err := store.Set(context.Background(), "key", 123, time.Hour)
+	assert.NoError(t, err)
+
+	// 验证缓存是否设置成功
+	value, ok := cache.Get("key")
+	assert.True(t, ok)
+	assert.Equal(t, 123, value)
+}
+
+func TestCacheStore_Del(t *testing.T) {
+	// 创建缓存对象
+	cache := getTestLocalCache()
+
+	// 创建 cacheStore 对象
+	store := NewCacheStore(cache)
+
+	// 设置缓存
+	err := store.Set(context.Background(), "key", 123, time.Hour)
+	assert.NoError(t, err)
+
+	// 删除缓存
+	err = store.Del(context.Background(), "key")
+	assert.NoError(t, err)
+
+	// 验证缓存是否删除成功
+	_, ok := cache.Get("key")
+	assert.False(t, ok)
+}
+
+func TestCacheStore_DelByPrefix(t *testing.T) {
+	ctx := context.Background()
+	store := NewCacheStore(getTestLocalCache())
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, store.Set(ctx, "user:"+strconv.Itoa(i), i, time.Hour))
+	}
+	assert.NoError(t, store.Set(ctx, "order:1", 1, time.Hour))
+
+	// ctx 取消时中止删除
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	deleted, err := ScanDel(cancelCtx, store, "user:")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, deleted)
+
+	deleted, err = ScanDel(ctx, store, "user:")
+	assert.NoError(t, err)
+	assert.Equal(t, 10, deleted)
+	_, err = store.Get(ctx, "user:1")
+	assert.ErrorIs(t, err, ErrKeyNonExistent)
+	_, err = store.Get(ctx, "order:1")
+	assert.NoError(t, err)
+}
+
+func TestCacheStore_Set_InvalidTTL(t *testing.T) {
+	// 创建缓存对象
+	cache := getTestLocalCache()
+	// 创建 cacheStore 对象
+	store := NewCacheStore(cache)
+
+	// ttl = 0 非法
+	err := store.Set(context.Background(), "key", 123, 0)
+	assert.ErrorIs(t, err, ErrInvalidTTL)
+
+	// ttl = -5s 非法
+	err = store.Set(context.Background(), "key", 123, -5*time.Second)
+	assert.ErrorIs(t, err, ErrInvalidTTL)
+
+	// 非法 ttl 不会写入缓存
+	_, ok := cache.Get("key")
+	assert.False(t, ok)
+
+	// KeepTTL 永久存储
+	err = store.Set(context.Background(), "key", 123, KeepTTL)
+	assert.NoError(t, err)
+	_, expiration, ok := cache.GetWithExpiration("key")
+	assert.True(t, ok)
+	assert.True(t, expiration.IsZero())
+}
+
+func TestCacheStore_SetIfNewer(t *testing.T) {
+	cStore := NewCacheStore(getTestLocalCache())
+	store := cStore.(ConditionalStore)
+
+	// 缓存不存在时写入
+	ok, err := store.SetIfNewer(context.Background(), "key", &AbcBox[int]{Timestamp: 100, TimestampMs: 100500, T: 1}, 100500, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// 同一秒内更旧的数据不会覆盖新数据
+	ok, err = store.SetIfNewer(context.Background(), "key", &AbcBox[int]{Timestamp: 100, TimestampMs: 100200, T: 2}, 100200, time.Hour)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// 相同或者更新的时间戳写入
+	ok, err = store.SetIfNewer(context.Background(), "key", &AbcBox[int]{Timestamp: 100, TimestampMs: 100800, T: 3}, 100800, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	value, err := cStore.Get(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, value.(*AbcBox[int]).T)
+}
+
+func TestCacheStore_Eviction(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		evicted = map[string]any{}
+	)
+	store := NewCacheStoreWithEviction(cache.New(cache.NoExpiration, 10*time.Millisecond), func(key string, value any) {
+		mu.Lock()
+		defer mu.Unlock()
+		evicted[key] = value
+	})
+
+	// 删除触发回调
+	assert.NoError(t, store.Set(context.Background(), "del", 1, time.Hour))
+	assert.NoError(t, store.Del(context.Background(), "del"))
+
+	// 过期清理触发回调
+	assert.NoError(t, store.Set(context.Background(), "expire", 2, 20*time.Millisecond))
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return evicted["del"] == 1 && evicted["expire"] == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCacheStore_Dump(t *testing.T) {
+	store := NewCacheStore(getTestLocalCache())
+	for i, key := range []string{"a", "b", "c"} {
+		assert.NoError(t, store.Set(context.Background(), key, i, time.Hour))
+	}
+
+	items, err := DumpStore(context.Background(), store, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{"a": 0, "b": 1, "c": 2}, items)
+
+	keys, err := store.(Dumpable).Keys(context.Background(), 2)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+}
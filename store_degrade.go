@@ -0,0 +1,195 @@
+package modecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	degradeProbeInterval = 5 * time.Second           // 降级后探测主存储的间隔
+	degradeProbeKey      = "modecache:degrade:probe" // 探测主存储时读取的 key
+	degradeProbeTimeout  = time.Second               // 单次探测的超时时间
+)
+
+// 主存储不可用时降级到本地存储的缓存装饰器
+// 主存储返回 ErrStoreUnavailable 时切换到 fallback 读写, 降级期间的请求按间隔触发一次探测, 主存储恢复后切换回主存储并清空 fallback
+// 探测由请求触发并且有超时, 没有常驻的后台协程, 控制器不再使用时无需关闭
+type degradeStore struct {
+	primary       Store
+	fallback      Store
+	probeInterval time.Duration
+	degraded      atomic.Bool
+	lastProbe     atomic.Int64 // 上次探测 (或者降级) 的时间, UnixNano
+}
+
+// shouldDegrade 判断主存储的错误是否需要降级
+// 调用方上下文取消或者超时时 redis 的错误同样使用 ErrStoreUnavailable 包装, 这些错误不代表主存储不可用, 不降级
+func (s *degradeStore) shouldDegrade(ctx context.Context, err error) bool {
+	return errors.Is(err, ErrStoreUnavailable) && ctx.Err() == nil && !errors.Is(err, context.Canceled)
+}
+
+// degrade 切换到 fallback
+func (s *degradeStore) degrade() {
+	if s.degraded.CompareAndSwap(false, true) {
+		s.lastProbe.Store(time.Now().UnixNano())
+	}
+}
+
+// useFallback 判断是否使用 fallback, 降级期间距离上次探测超过间隔时触发一次探测
+func (s *degradeStore) useFallback() bool {
+	if !s.degraded.Load() {
+		return false
+	}
+	last := s.lastProbe.Load()
+	if time.Since(time.Unix(0, last)) >= s.probeInterval && s.lastProbe.CompareAndSwap(last, time.Now().UnixNano()) {
+		GO(s.probe)
+	}
+	return true
+}
+
+// probe 探测主存储, 读取成功或者不存在时视为恢复
+func (s *degradeStore) probe() {
+	ctx, cancel := context.WithTimeout(context.Background(), degradeProbeTimeout)
+	defer cancel()
+	_, err := s.primary.Get(ctx, degradeProbeKey)
+	if err != nil && !errors.Is(err, ErrKeyNonExistent) {
+		return
+	}
+	// 清空降级期间的数据, 避免下次降级时读取到主存储中已经失效的旧数据
+	if clearable, ok := s.fallback.(Clearable); ok {
+		_ = clearable.Clear(ctx)
+	}
+	s.degraded.Store(false)
+}
+
+// do 在主存储上执行 primary, 主存储不可用时降级并执行 fallback
+func (s *degradeStore) do(ctx context.Context, primary, fallback func() error) error {
+	if !s.useFallback() {
+		err := primary()
+		if !s.shouldDegrade(ctx, err) {
+			return err
+		}
+		s.degrade()
+	}
+	return fallback()
+}
+
+func (s *degradeStore) Get(ctx context.Context, key string) (data any, err error) {
+	err = s.do(ctx, func() error {
+		data, err = s.primary.Get(ctx, key)
+		return err
+	}, func() error {
+		data, err = s.fallback.Get(ctx, key)
+		return err
+	})
+	return data, err
+}
+
+func (s *degradeStore) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
+	return s.do(ctx, func() error {
+		return s.primary.Set(ctx, key, data, ttl)
+	}, func() error {
+		return s.fallback.Set(ctx, key, data, ttl)
+	})
+}
+
+func (s *degradeStore) Del(ctx context.Context, key string) error {
+	return s.do(ctx, func() error {
+		return s.primary.Del(ctx, key)
+	}, func() error {
+		return s.fallback.Del(ctx, key)
+	})
+}
+
+// SetIfNewer 使用当前存储的 ConditionalStore, 未实现时直接写入
+func (s *degradeStore) SetIfNewer(ctx context.Context, key string, data any, timestampMs int64, ttl time.Duration) (ok bool, err error) {
+	setIfNewer := func(store Store) func() error {
+		return func() error {
+			if cStore, isCond := store.(ConditionalStore); isCond {
+				ok, err = cStore.SetIfNewer(ctx, key, data, timestampMs, ttl)
+				return err
+			}
+			ok, err = true, store.Set(ctx, key, data, ttl)
+			return err
+		}
+	}
+	err = s.do(ctx, setIfNewer(s.primary), setIfNewer(s.fallback))
+	return ok, err
+}
+
+// DelAndSet 使用当前存储的 Transactional, 未实现时依次删除和写入 (不保证原子性)
+func (s *degradeStore) DelAndSet(ctx context.Context, del []string, key string, data any, ttl time.Duration) error {
+	delAndSet := func(store Store) func() error {
+		return func() error {
+			if tx, ok := store.(Transactional); ok {
+				return tx.DelAndSet(ctx, del, key, data, ttl)
+			}
+			for _, k := range del {
+				if err := store.Del(ctx, k); err != nil {
+					return err
+				}
+			}
+			return store.Set(ctx, key, data, ttl)
+		}
+	}
+	return s.do(ctx, delAndSet(s.primary), delAndSet(s.fallback))
+}
+
+// Exists 使用当前存储的 Exister, 未实现时读取数据判断
+func (s *degradeStore) Exists(ctx context.Context, key string) (ok bool, err error) {
+	exists := func(store Store) func() error {
+		return func() error {
+			if exister, isExister := store.(Exister); isExister {
+				ok, err = exister.Exists(ctx, key)
+				return err
+			}
+			_, err = store.Get(ctx, key)
+			if errors.Is(err, ErrKeyNonExistent) {
+				ok, err = false, nil
+				return nil
+			}
+			ok = err == nil
+			return err
+		}
+	}
+	err = s.do(ctx, exists(s.primary), exists(s.fallback))
+	return ok, err
+}
+
+// TTL 使用当前存储的 TTLReader, 未实现时返回 ErrUnsupported 错误
+func (s *degradeStore) TTL(ctx context.Context, key string) (ttl time.Duration, err error) {
+	ttlOf := func(store Store) func() error {
+		return func() error {
+			reader, ok := store.(TTLReader)
+			if !ok {
+				return fmt.Errorf("%w: %T does not implement TTLReader", ErrUnsupported, store)
+			}
+			ttl, err = reader.TTL(ctx, key)
+			return err
+		}
+	}
+	err = s.do(ctx, ttlOf(s.primary), ttlOf(s.fallback))
+	return ttl, err
+}
+
+// Touch 使用当前存储的 TTLExtender, 未实现时返回 ErrUnsupported 错误
+func (s *degradeStore) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	touch := func(store Store) func() error {
+		return func() error {
+			extender, ok := store.(TTLExtender)
+			if !ok {
+				return fmt.Errorf("%w: %T does not implement TTLExtender", ErrUnsupported, store)
+			}
+			return extender.Touch(ctx, key, ttl)
+		}
+	}
+	return s.do(ctx, touch(s.primary), touch(s.fallback))
+}
+
+// IsDirectStore 使用主存储的值, 非直接存储降级时 fallback 保存编码后的数据
+func (s *degradeStore) IsDirectStore() bool {
+	return s.primary.IsDirectStore()
+}
@@ -0,0 +1,104 @@
+package modecache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithDegradeToLocal(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	primary := NewRedisStore(redis.NewClient(&redis.Options{Addr: s.Addr(), MaxRetries: -1}))
+	local := NewMemoryStore()
+	ctr := NewCacheController[int]("test-degrade", primary, WithDegradeToLocal[int](local))
+	store := ctr.store.(*degradeStore)
+	store.probeInterval = 10 * time.Millisecond
+
+	query := func(value int) Query[int] {
+		return func(ctx context.Context) (int, error) { return value, nil }
+	}
+	res, err := ctr.Wrap(ctx, "key", query(1))
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+	_, err = local.Get(ctx, "key")
+	require.ErrorIs(t, err, ErrKeyNonExistent)
+
+	// redis 不可用时降级到本地存储
+	s.Close()
+	res, err = ctr.Wrap(ctx, "other", query(2))
+	require.NoError(t, err)
+	require.Equal(t, 2, res)
+	require.True(t, store.degraded.Load())
+	res, _, err = ctr.GetStore(ctx, "other")
+	require.NoError(t, err)
+	require.Equal(t, 2, res)
+
+	// redis 恢复后切换回主存储, 并清空本地存储
+	require.NoError(t, s.Restart())
+	require.Eventually(t, func() bool {
+		// 降级期间由请求触发探测
+		_, _, _ = ctr.GetStore(ctx, "other")
+		return !store.degraded.Load()
+	}, time.Second, 10*time.Millisecond)
+	_, err = local.Get(ctx, "other")
+	require.ErrorIs(t, err, ErrKeyNonExistent)
+	res, _, err = ctr.GetStore(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+}
+
+func TestWithDegradeToLocal_ContextCanceled(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	primary := NewRedisStore(redis.NewClient(&redis.Options{Addr: s.Addr()}))
+	ctr := NewCacheController[int]("test-degrade-cancel", primary, WithDegradeToLocal[int](NewMemoryStore()))
+	require.NoError(t, ctr.SetStore(ctx, "key", 1, time.Minute))
+
+	// 调用方取消的请求不降级
+	cCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, _, err := ctr.GetStore(cCtx, "key")
+	require.Error(t, err)
+	require.False(t, ctr.store.(*degradeStore).degraded.Load())
+	res, _, err := ctr.GetStore(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+}
+
+func TestWithDegradeToLocal_OptionalInterfaces(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	primary := NewRedisStore(redis.NewClient(&redis.Options{Addr: s.Addr(), MaxRetries: -1}))
+	ctr := NewCacheController[int]("test-degrade-optional", primary, WithDegradeToLocal[int](NewMemoryStore()))
+	store := ctr.store.(*degradeStore)
+
+	// 主存储正常时使用主存储的可选接口
+	require.NoError(t, ctr.SetStore(ctx, "key", 1, time.Minute))
+	ttl, err := ctr.RemainingTTL(ctx, "key")
+	require.NoError(t, err)
+	require.InDelta(t, time.Minute, ttl, float64(time.Second))
+	require.NoError(t, ctr.Touch(ctx, "key", time.Hour))
+	require.Equal(t, time.Hour, s.TTL("key"))
+	ok, err := ctr.Exists(ctx, "key")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NoError(t, ctr.DelAndSet(ctx, "other", 2, time.Minute, "key"))
+	require.False(t, s.Exists("key"))
+	require.True(t, s.Exists("other"))
+
+	// 降级后使用 fallback
+	s.Close()
+	require.NoError(t, ctr.SetStore(ctx, "local", 3, time.Minute))
+	require.True(t, store.degraded.Load())
+	ok, err = ctr.Exists(ctx, "local")
+	require.NoError(t, err)
+	require.True(t, ok)
+	ttl, err = ctr.RemainingTTL(ctx, "local")
+	require.NoError(t, err)
+	require.InDelta(t, time.Minute, ttl, float64(time.Second))
+}
@@ -0,0 +1,68 @@
+package modecache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// 主存储未命中时读取备用存储的缓存, 用于读取链路的迁移
+type readFallbackStore struct {
+	primary  Store
+	fallback Store
+	reader   TTLReader // 备用存储的过期时间, 只在回填时使用
+	backfill bool
+}
+
+// Get 优先读取主存储, 主存储未命中时读取备用存储, backfill 时使用备用存储的剩余过期时间回填主存储
+// 主存储的其他错误直接返回, 不读取备用存储
+func (s *readFallbackStore) Get(ctx context.Context, key string) (any, error) {
+	data, err := s.primary.Get(ctx, key)
+	if !errors.Is(err, ErrKeyNonExistent) {
+		return data, err
+	}
+	data, err = s.fallback.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if s.backfill {
+		if ttl, err := s.reader.TTL(ctx, key); err == nil {
+			_ = s.primary.Set(ctx, key, data, ttl)
+		}
+	}
+	return data, nil
+}
+
+// Set 只写入主存储
+func (s *readFallbackStore) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
+	return s.primary.Set(ctx, key, data, ttl)
+}
+
+// Del 只删除主存储, 备用存储中的数据保留到过期
+func (s *readFallbackStore) Del(ctx context.Context, key string) error {
+	return s.primary.Del(ctx, key)
+}
+
+func (s *readFallbackStore) IsDirectStore() bool {
+	return s.primary.IsDirectStore()
+}
+
+// NewReadFallbackStore 创建主存储未命中时读取备用存储的缓存, 适用于读取链路迁移和排空旧存储
+// 写入和删除只访问主存储; backfill 为 true 时把备用存储读取到的数据使用剩余过期时间回填到主存储, 为 false 时不回填
+// 和分层缓存不同, 备用存储不会被写入, 随着缓存过期逐渐排空
+// # 注意删除不会删除备用存储, 主存储删除后仍然可能读取到备用存储中的旧数据, 直到备用存储中的数据过期
+// # 注意 primary 和 fallback 的 IsDirectStore 必须一致, backfill 时 fallback 必须实现 TTLReader, 否则 panic
+func NewReadFallbackStore(primary, fallback Store, backfill bool) Store {
+	if primary.IsDirectStore() != fallback.IsDirectStore() {
+		panic("modecache: read fallback store primary and fallback IsDirectStore mismatch")
+	}
+	s := &readFallbackStore{primary: primary, fallback: fallback, backfill: backfill}
+	if backfill {
+		reader, ok := fallback.(TTLReader)
+		if !ok {
+			panic("modecache: read fallback store backfill need fallback implement TTLReader")
+		}
+		s.reader = reader
+	}
+	return s
+}
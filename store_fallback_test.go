@@ -0,0 +1,63 @@
+package modecache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadFallbackStore(t *testing.T) {
+	ctx := context.Background()
+	primary, fallback := NewMemoryStore(), NewMemoryStore()
+	store := NewReadFallbackStore(primary, fallback, false)
+	assert.NoError(t, fallback.Set(ctx, "old", 1, time.Minute))
+
+	// 主存储未命中读取备用存储, 不回填
+	value, err := store.Get(ctx, "old")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, value)
+	_, err = primary.Get(ctx, "old")
+	assert.ErrorIs(t, err, ErrKeyNonExistent)
+
+	// 写入只访问主存储
+	assert.NoError(t, store.Set(ctx, "new", 2, time.Minute))
+	_, err = fallback.Get(ctx, "new")
+	assert.ErrorIs(t, err, ErrKeyNonExistent)
+
+	// 删除只访问主存储
+	assert.NoError(t, store.Set(ctx, "old", 3, time.Minute))
+	assert.NoError(t, store.Del(ctx, "old"))
+	_, err = primary.Get(ctx, "old")
+	assert.ErrorIs(t, err, ErrKeyNonExistent)
+	value, err = fallback.Get(ctx, "old")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, value)
+}
+
+func TestReadFallbackStore_Backfill(t *testing.T) {
+	ctx := context.Background()
+	primary, fallback := NewMemoryStore(), NewMemoryStore()
+	store := NewReadFallbackStore(primary, fallback, true)
+	assert.NoError(t, fallback.Set(ctx, "old", 1, time.Minute))
+
+	value, err := store.Get(ctx, "old")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, value)
+	value, err = primary.Get(ctx, "old")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, value)
+	ttl, err := primary.(TTLReader).TTL(ctx, "old")
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, ttl, time.Minute)
+
+	assert.Panics(t, func() {
+		NewReadFallbackStore(primary, failStore{Store: NewMemoryStore()}, true)
+	})
+	rds, closer := getRedis()
+	defer closer()
+	assert.Panics(t, func() {
+		NewReadFallbackStore(primary, rds, false)
+	})
+}
@@ -0,0 +1,129 @@
+package modecache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// memoryItem 内存缓存项, expireAt 为 0 表示永久存储
+type memoryItem struct {
+	data     any
+	expireAt int64
+}
+
+func (i memoryItem) expired(now int64) bool {
+	return i.expireAt > 0 && now >= i.expireAt
+}
+
+// 使用 sync.Map 实现的无依赖内存缓存, 过期的键在读取时惰性删除
+type memoryStore struct {
+	mp *sync.Map
+}
+
+// Get 获取缓存。当缓存键不存在或已过期时返回 ErrKeyNonExistent 错误。
+func (m memoryStore) Get(ctx context.Context, key string) (any, error) {
+	value, ok := m.mp.Load(key)
+	if !ok {
+		return nil, ErrKeyNonExistent
+	}
+	item := value.(memoryItem)
+	if item.expired(time.Now().UnixNano()) {
+		m.mp.CompareAndDelete(key, value)
+		return nil, ErrKeyNonExistent
+	}
+	return item.data, nil
+}
+
+// Set 设置缓存。
+// 只有 KeepTTL 表示永久存储, 其余 <= 0 的 ttl 返回 ErrInvalidTTL 错误
+func (m memoryStore) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
+	item := memoryItem{data: data}
+	switch {
+	case ttl == KeepTTL:
+	case ttl <= 0:
+		return fmt.Errorf("%w: %s", ErrInvalidTTL, ttl)
+	default:
+		item.expireAt = time.Now().Add(ttl).UnixNano()
+	}
+	m.mp.Store(key, item)
+	return nil
+}
+
+// Del 删除缓存。
+func (m memoryStore) Del(ctx context.Context, key string) error {
+	m.mp.Delete(key)
+	return nil
+}
+
+// Touch 延长缓存的过期时间, 使用原有数据和新的过期时间重新写入
+func (m memoryStore) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	value, err := m.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	return m.Set(ctx, key, value, ttl)
+}
+
+// TTL 获取缓存的剩余过期时间, 永不过期时返回 KeepTTL
+func (m memoryStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	value, ok := m.mp.Load(key)
+	if !ok {
+		return 0, ErrKeyNonExistent
+	}
+	item := value.(memoryItem)
+	now := time.Now().UnixNano()
+	switch {
+	case item.expired(now):
+		return 0, ErrKeyNonExistent
+	case item.expireAt == 0:
+		return KeepTTL, nil
+	default:
+		return time.Duration(item.expireAt - now), nil
+	}
+}
+
+// Clear 清空内存缓存
+func (m memoryStore) Clear(ctx context.Context) error {
+	m.mp.Clear()
+	return nil
+}
+
+// Keys 列出未过期的缓存键, 最多返回 limit 个
+func (m memoryStore) Keys(ctx context.Context, limit int) ([]string, error) {
+	items, err := m.Dump(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Dump 列出未过期的缓存数据, 最多返回 limit 个
+func (m memoryStore) Dump(ctx context.Context, limit int) (map[string]any, error) {
+	res := make(map[string]any)
+	now := time.Now().UnixNano()
+	m.mp.Range(func(key, value any) bool {
+		if len(res) >= limit {
+			return false
+		}
+		if item := value.(memoryItem); !item.expired(now) {
+			res[key.(string)] = item.data
+		}
+		return true
+	})
+	return res, nil
+}
+
+func (m memoryStore) IsDirectStore() bool {
+	return true
+}
+
+// NewMemoryStore 创建基于 sync.Map 的内存缓存, 不依赖第三方库, 适用于单元测试和基准测试
+func NewMemoryStore() Store {
+	return memoryStore{mp: &sync.Map{}}
+}
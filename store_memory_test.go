@@ -0,0 +1,84 @@
+package modecache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore_Get(t *testing.T) {
+	store := NewMemoryStore()
+
+	// 设置缓存
+	err := store.Set(context.Background(), "key", 123, time.Hour)
+	assert.NoError(t, err)
+
+	// 获取缓存
+	value, err := store.Get(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.Equal(t, 123, value)
+
+	// 获取不存在的缓存
+	value, err = store.Get(context.Background(), "key_not_is")
+	assert.ErrorIs(t, err, ErrKeyNonExistent)
+	assert.Zero(t, value)
+}
+
+func TestMemoryStore_Expire(t *testing.T) {
+	store := NewMemoryStore()
+
+	// 设置短过期时间
+	err := store.Set(context.Background(), "key", 123, 20*time.Millisecond)
+	assert.NoError(t, err)
+	time.Sleep(30 * time.Millisecond)
+
+	_, err = store.Get(context.Background(), "key")
+	assert.ErrorIs(t, err, ErrKeyNonExistent)
+
+	// KeepTTL 永久存储
+	err = store.Set(context.Background(), "key", 123, KeepTTL)
+	assert.NoError(t, err)
+	value, err := store.Get(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.Equal(t, 123, value)
+
+	// 非法 ttl
+	err = store.Set(context.Background(), "key", 123, 0)
+	assert.ErrorIs(t, err, ErrInvalidTTL)
+}
+
+func TestMemoryStore_Del(t *testing.T) {
+	store := NewMemoryStore()
+
+	err := store.Set(context.Background(), "key", 123, time.Hour)
+	assert.NoError(t, err)
+
+	// 删除缓存
+	err = store.Del(context.Background(), "key")
+	assert.NoError(t, err)
+
+	_, err = store.Get(context.Background(), "key")
+	assert.ErrorIs(t, err, ErrKeyNonExistent)
+}
+
+func TestMemoryStore_Dump(t *testing.T) {
+	store := NewMemoryStore()
+	assert.NoError(t, store.Set(context.Background(), "a", 1, time.Hour))
+	assert.NoError(t, store.Set(context.Background(), "expired", 2, time.Nanosecond))
+	time.Sleep(time.Millisecond)
+
+	items, err := DumpStore(context.Background(), store, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{"a": 1}, items)
+	for _, limit := range []int{0, -1} {
+		items, err = store.(Dumpable).Dump(context.Background(), limit)
+		assert.NoError(t, err)
+		assert.Empty(t, items)
+	}
+
+	// 不支持的存储
+	_, err = DumpStore(context.Background(), NewNoopStore(), 10)
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
@@ -0,0 +1,115 @@
+package modecache
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// MirrorMode 镜像缓存的权威存储
+type MirrorMode int32
+
+const (
+	MirrorPrimary   MirrorMode = iota // primary 为权威存储, 同步读写 primary, 异步写入 secondary
+	MirrorSecondary                   // secondary 为权威存储, 同步读写 secondary, 异步写入 primary
+)
+
+// MirrorErrorHandler 镜像存储写入失败的回调
+type MirrorErrorHandler func(ctx context.Context, key string, err error)
+
+// MirrorStore 双写的镜像缓存, 用于缓存迁移
+// 读取只访问权威存储, 写入和删除同步访问权威存储, 并尽力异步写入另一个存储, 通过 SetMode 在运行时切换权威存储
+type MirrorStore struct {
+	primary   Store
+	secondary Store
+	mode      atomic.Int32
+	onError   atomic.Pointer[MirrorErrorHandler] // 为空时使用 log 输出
+}
+
+// stores 获取当前的权威存储和镜像存储
+func (m *MirrorStore) stores() (Store, Store) {
+	if MirrorMode(m.mode.Load()) == MirrorSecondary {
+		return m.secondary, m.primary
+	}
+	return m.primary, m.secondary
+}
+
+// mirror 异步执行镜像存储的写入, 失败时调用错误回调, 没有设置回调时使用 log 输出
+// 异步写入不保证顺序, 同一个 key 的并发写入在镜像存储中可能乱序
+func (m *MirrorStore) mirror(ctx context.Context, key string, fn func(ctx context.Context) error) {
+	ctx = context.WithoutCancel(ctx)
+	GO(func() {
+		err := fn(ctx)
+		if err == nil {
+			return
+		}
+		if handler := m.onError.Load(); handler != nil {
+			(*handler)(ctx, key, err)
+			return
+		}
+		log.Printf("modecache: mirror store write key %s fail: %v", key, err)
+	})
+}
+
+func (m *MirrorStore) Get(ctx context.Context, key string) (any, error) {
+	authority, _ := m.stores()
+	return authority.Get(ctx, key)
+}
+
+// Set 同步写入权威存储, 成功后异步写入镜像存储
+func (m *MirrorStore) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
+	authority, mirror := m.stores()
+	if err := authority.Set(ctx, key, data, ttl); err != nil {
+		return err
+	}
+	m.mirror(ctx, key, func(ctx context.Context) error {
+		return mirror.Set(ctx, key, data, ttl)
+	})
+	return nil
+}
+
+// Del 同步删除权威存储, 并异步删除镜像存储, 避免切换权威存储后读取到已经删除的数据
+func (m *MirrorStore) Del(ctx context.Context, key string) error {
+	authority, mirror := m.stores()
+	if err := authority.Del(ctx, key); err != nil {
+		return err
+	}
+	m.mirror(ctx, key, func(ctx context.Context) error {
+		return mirror.Del(ctx, key)
+	})
+	return nil
+}
+
+func (m *MirrorStore) IsDirectStore() bool {
+	return m.primary.IsDirectStore()
+}
+
+// SetMode 切换权威存储, 可以在运行时调用
+func (m *MirrorStore) SetMode(mode MirrorMode) {
+	m.mode.Store(int32(mode))
+}
+
+// Mode 获取当前的权威存储
+func (m *MirrorStore) Mode() MirrorMode {
+	return MirrorMode(m.mode.Load())
+}
+
+// SetErrorHandler 设置镜像存储写入失败的回调, 可以在运行时调用
+// 默认使用标准库 log 输出失败的 key 和错误, fn 为空时恢复默认处理
+func (m *MirrorStore) SetErrorHandler(fn MirrorErrorHandler) {
+	if fn == nil {
+		m.onError.Store(nil)
+		return
+	}
+	m.onError.Store(&fn)
+}
+
+// NewMirrorStore 创建双写的镜像缓存, 默认 primary 为权威存储
+// # 注意 primary 和 secondary 的 IsDirectStore 必须一致, 否则 panic
+func NewMirrorStore(primary Store, secondary Store) *MirrorStore {
+	if primary.IsDirectStore() != secondary.IsDirectStore() {
+		panic("modecache: mirror store primary and secondary IsDirectStore mismatch")
+	}
+	return &MirrorStore{primary: primary, secondary: secondary}
+}
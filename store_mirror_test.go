@@ -0,0 +1,102 @@
+package modecache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failStore 写入总是失败的缓存
+type failStore struct {
+	Store
+	err error
+}
+
+func (f failStore) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
+	return f.err
+}
+
+func TestMirrorStore(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := NewMemoryStore(), NewMemoryStore()
+	store := NewMirrorStore(primary, secondary)
+
+	// 同步写入 primary, 异步写入 secondary
+	assert.NoError(t, store.Set(ctx, "key", 1, time.Minute))
+	value, err := primary.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, value)
+	assert.Eventually(t, func() bool {
+		value, err := secondary.Get(ctx, "key")
+		return err == nil && value == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// 切换权威存储, 读取 secondary
+	_ = secondary.Set(ctx, "only", 2, time.Minute)
+	store.SetMode(MirrorSecondary)
+	assert.Equal(t, MirrorSecondary, store.Mode())
+	value, err = store.Get(ctx, "only")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, value)
+
+	// 删除同时删除两个存储
+	assert.NoError(t, store.Del(ctx, "key"))
+	_, err = secondary.Get(ctx, "key")
+	assert.ErrorIs(t, err, ErrKeyNonExistent)
+	assert.Eventually(t, func() bool {
+		_, err := primary.Get(ctx, "key")
+		return errors.Is(err, ErrKeyNonExistent)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMirrorStore_MirrorError(t *testing.T) {
+	ctx := context.Background()
+	testErr := errors.New("mirror failed")
+	store := NewMirrorStore(NewMemoryStore(), failStore{Store: NewMemoryStore(), err: testErr})
+
+	errCh := make(chan error, 1)
+	store.SetErrorHandler(func(ctx context.Context, key string, err error) {
+		errCh <- err
+	})
+
+	// 镜像写入失败不影响结果
+	assert.NoError(t, store.Set(ctx, "key", 1, time.Minute))
+	select {
+	case err := <-errCh:
+		assert.ErrorIs(t, err, testErr)
+	case <-time.After(time.Second):
+		t.Fatal("mirror error handler not called")
+	}
+
+	// 没有设置回调时使用 log 输出
+	store.SetErrorHandler(nil)
+	logCh := make(chan string, 1)
+	log.SetOutput(chanWriter(logCh))
+	defer log.SetOutput(os.Stderr)
+	assert.NoError(t, store.Set(ctx, "key", 2, time.Minute))
+	select {
+	case msg := <-logCh:
+		assert.Contains(t, msg, "mirror failed")
+	case <-time.After(time.Second):
+		t.Fatal("mirror error not logged")
+	}
+
+	rds, closer := getRedis()
+	defer closer()
+	assert.Panics(t, func() {
+		NewMirrorStore(NewMemoryStore(), rds)
+	})
+}
+
+// chanWriter 把写入的内容发送到通道
+type chanWriter chan string
+
+func (w chanWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
@@ -0,0 +1,33 @@
+package modecache
+
+import (
+	"context"
+	"time"
+)
+
+// 不存储任何数据的缓存, 用于运行时关闭缓存, 每次 Wrap 都会执行 query, 插件和策略依然生效
+type noopStore struct{}
+
+// Get 获取缓存, 总是返回 ErrKeyNonExistent 错误。
+func (n noopStore) Get(ctx context.Context, key string) (any, error) {
+	return nil, ErrKeyNonExistent
+}
+
+// Set 设置缓存, 不做任何操作。
+func (n noopStore) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
+	return nil
+}
+
+// Del 删除缓存, 不做任何操作。
+func (n noopStore) Del(ctx context.Context, key string) error {
+	return nil
+}
+
+func (n noopStore) IsDirectStore() bool {
+	return true
+}
+
+// NewNoopStore 创建不存储任何数据的缓存, 用于调试或者评估无缓存时的数据库压力
+func NewNoopStore() Store {
+	return noopStore{}
+}
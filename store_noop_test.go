@@ -0,0 +1,34 @@
+package modecache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNoopStore(t *testing.T) {
+	store := NewNoopStore()
+
+	err := store.Set(context.Background(), "key", 123, time.Hour)
+	assert.NoError(t, err)
+
+	// 总是未命中缓存
+	value, err := store.Get(context.Background(), "key")
+	assert.ErrorIs(t, err, ErrKeyNonExistent)
+	assert.Nil(t, value)
+
+	// 每次 Wrap 都执行 query
+	queryCount := 0
+	ctr := NewCacheController[int]("test-noop", store)
+	for i := 0; i < 3; i++ {
+		res, err := ctr.Wrap(context.Background(), "key", func(ctx context.Context) (int, error) {
+			queryCount++
+			return 1, nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, res)
+	}
+	assert.Equal(t, 3, queryCount)
+}
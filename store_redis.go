@@ -1,143 +1,494 @@
-package modecache
-
-import (
-	"context"
-	"errors"
-	"time"
-
-	"github.com/redis/go-redis/v9"
-	"github.com/spf13/cast"
-)
-
-// 影子链路方案使用 redis 实现
-type redisStore struct {
-	rds *redis.Client
-}
-
-func (r redisStore) Get(ctx context.Context, key string) (any, error) {
-	cmd := r.rds.Do(ctx, "get", key)
-	res, err := cmd.Result()
-	switch {
-	case err == nil:
-	case errors.Is(err, redis.Nil):
-		return nil, ErrKeyNonExistent
-	default:
-		return nil, err
-	}
-
-	return cast.ToString(res), nil
-}
-
-// Set 设置缓存。
-func (r redisStore) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
-	//nolint:mnd
-	args := make([]any, 3, 5)
-	args[0] = "set"
-	args[1] = key
-	args[2] = data
-
-	// 过期时间设置
-	if ttl > 0 {
-		if usePrecise(ttl) {
-			args = append(args, "px", formatMs(ttl))
-		} else {
-			args = append(args, "ex", formatSec(ttl))
-		}
-	}
-
-	cmd := r.rds.Do(ctx, args...)
-	return cmd.Err()
-}
-
-// Del 删除缓存。
-func (r redisStore) Del(ctx context.Context, key string) error {
-	cmd := r.rds.Do(ctx, "del", key)
-	return cmd.Err()
-}
-
-func (r redisStore) IsDirectStore() bool {
-	return false
-}
-
-// NewRedisCache 新创建应该 redis cache
-func NewRedisStore(rd *redis.Client) Store {
-	return redisStore{rds: rd}
-}
-
-// 显示实现接口
-var _ Store = (*RedisHashStore)(nil)
-
-// NewRedisHashStore 创建 redis hash cache
-// 注意 NewHashStore 设置过期时间会对整个 hash 进行设置
-type RedisHashStore struct {
-	rds     *redis.Client
-	rdsKey  string
-	hashKey string
-}
-
-// Get 获取缓存, 使用外部给定的 rds key 作为存储 key，避免和 modecache_key 冲突
-func (r *RedisHashStore) Get(ctx context.Context, _ string) (any, error) {
-	cmd := r.rds.Do(ctx, "hget", r.rdsKey, r.hashKey)
-	res, err := cmd.Result()
-	switch {
-	case err == nil:
-	case errors.Is(err, redis.Nil):
-		return nil, ErrKeyNonExistent
-	default:
-		return nil, err
-	}
-	return cast.ToString(res), nil
-}
-
-// Set 设置缓存。
-func (r *RedisHashStore) Set(ctx context.Context, _ string, data any, ttl time.Duration) error {
-	//nolint:mnd
-	args := make([]any, 4)
-	args[0] = "hset"
-	args[1] = r.rdsKey
-	args[2] = r.hashKey
-	args[3] = data
-	cmd := r.rds.Do(ctx, args...)
-	if cmd.Err() != nil {
-		return cmd.Err()
-	}
-	// 过期时间设置
-	// hash 类型无法直接设置过期时间，这里需要单独设置整个 hash 的过期时间
-	if ttl > 0 {
-		if usePrecise(ttl) {
-			_ = r.rds.Do(ctx, "pexpire", r.rdsKey, formatMs(ttl)).Err()
-		} else {
-			_ = r.rds.Do(ctx, "expire", r.rdsKey, formatSec(ttl)).Err()
-		}
-	}
-	return nil
-}
-
-func (r *RedisHashStore) Del(ctx context.Context, _ string) error {
-	cmd := r.rds.Do(ctx, "hdel", r.rdsKey, r.hashKey)
-	return cmd.Err()
-}
-
-// IsDirectStore 判断是否是直接存储
-func (r *RedisHashStore) IsDirectStore() bool {
-	return false
-}
-
-// DelAll 删除整个 hash
-func (r *RedisHashStore) DelAll(ctx context.Context) error {
-	cmd := r.rds.Do(ctx, "del", r.rdsKey)
-	return cmd.Err()
-}
-
-// NewRedisHashStoreWithPrefix 新创建 hashKey redis 其中
-// key: redis key, 最后存储的 redis key 注意这里不应该使用 modecache_key
-// hashKey: redis hash key,注意不是 redis key
-// return: ctx 需要向下传递用来替换默认的 storage
-func NewRedisHashStore(ctx context.Context, rd *redis.Client, rdsKey string, rdsHashKey string) (context.Context, *RedisHashStore) {
-	if rdsKey == "" || rdsHashKey == "" {
-		panic("redis key or hash key is empty")
-	}
-	store := &RedisHashStore{rds: rd, hashKey: rdsHashKey, rdsKey: rdsKey}
-	ctx = context.WithValue(ctx, CtxStorageKey{}, store)
-	return ctx, store
-}
+package modecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/cast"
+)
+
+// storeUnavailable 使用 ErrStoreUnavailable 包装 redis 错误, 保留原始错误
+func storeUnavailable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+}
+
+// 影子链路方案使用 redis 实现
+type redisStore struct {
+	rds *redis.Client
+}
+
+func (r redisStore) Get(ctx context.Context, key string) (any, error) {
+	cmd := r.rds.Do(ctx, "get", key)
+	res, err := cmd.Result()
+	switch {
+	case err == nil:
+	case errors.Is(err, redis.Nil):
+		return nil, ErrKeyNonExistent
+	default:
+		return nil, storeUnavailable(err)
+	}
+
+	return cast.ToString(res), nil
+}
+
+// Set 设置缓存。
+// KeepTTL 表示永久存储, 使用不带过期时间的 SET 移除之前的过期时间;
+// # 注意不能直接传递 KeepTTL, go-redis 的 redis.KeepTTL 同为 -1, 表示保留原有的过期时间
+func (r redisStore) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
+	if ttl < 0 {
+		ttl = 0
+	}
+	return storeUnavailable(r.rds.Set(ctx, key, data, ttl).Err())
+}
+
+// TTL 使用 PTTL 获取缓存的剩余过期时间, 永不过期时返回 KeepTTL
+func (r redisStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return pttl(ctx, r.rds, key)
+}
+
+// pttl 获取 redis key 的剩余过期时间, PTTL 返回 -2 表示不存在, -1 表示永不过期
+func pttl(ctx context.Context, rds *redis.Client, key string) (time.Duration, error) {
+	ms, err := rds.Do(ctx, "pttl", key).Int64()
+	if err != nil {
+		return 0, storeUnavailable(err)
+	}
+	switch {
+	case ms == -2: //nolint:mnd
+		return 0, ErrKeyNonExistent
+	case ms < 0:
+		return KeepTTL, nil
+	default:
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+}
+
+// setIfNewerScript 读取缓存中的毫秒时间戳, 只有缓存中的数据不比 ARGV[2] 更新时才写入
+// 原始编码和 proto 编码的数据从第 3 个字节开始读取头部的 zigzag varint (秒级时间戳, query 耗时, 毫秒时间戳),
+// JSON 编码的数据匹配 TimestampMs 字段; 没有毫秒时间戳的旧数据使用秒级时间戳 * 1000
+// KEYS[1]: key, ARGV[1]: 数据, ARGV[2]: 毫秒时间戳, ARGV[3]: 过期时间 (毫秒, <= 0 表示永久存储)
+var setIfNewerScript = redis.NewScript(`
+local function varint(s, i, e)
+	local v, mul = 0, 1
+	while i <= e do
+		local b = string.byte(s, i)
+		v, i = v + (b % 128) * mul, i + 1
+		if b < 128 then
+			return (v % 2 == 0) and v / 2 or -(v + 1) / 2, i
+		end
+		mul = mul * 128
+	end
+	return nil, i
+end
+
+local old = redis.call('get', KEYS[1])
+if old then
+	local ts
+	if string.byte(old, 1) <= 1 then
+		local e = 2 + (string.byte(old, 2) or 0)
+		local sec, i = varint(old, 3, e)
+		if sec then
+			ts = sec * 1000
+			local _, j = varint(old, i, e)
+			local ms = varint(old, j, e)
+			if ms and ms > 0 then
+				ts = ms
+			end
+		end
+	else
+		local ms = tonumber(string.match(old, '"TimestampMs":(%-?%d+)') or '')
+		local sec = tonumber(string.match(old, '"Timestamp":(%-?%d+)') or '')
+		if ms and ms > 0 then
+			ts = ms
+		elseif sec then
+			ts = sec * 1000
+		end
+	end
+	if ts and ts > tonumber(ARGV[2]) then
+		return 0
+	end
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call('set', KEYS[1], ARGV[1], 'px', ARGV[3])
+else
+	redis.call('set', KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+// SetIfNewer 只有缓存中的数据不比 timestampMs 更新时才写入, 使用 lua 脚本保证比较和写入的原子性
+func (r redisStore) SetIfNewer(ctx context.Context, key string, data any, timestampMs int64, ttl time.Duration) (bool, error) {
+	var ms int64
+	if ttl > 0 {
+		ms = formatMs(ttl)
+	}
+	ok, err := setIfNewerScript.Run(ctx, r.rds, []string{key}, data, timestampMs, ms).Bool()
+	if err != nil {
+		return false, storeUnavailable(err)
+	}
+	return ok, nil
+}
+
+// DelAndSet 使用 MULTI/EXEC 在一个事务中删除 del 中的键并写入 key
+func (r redisStore) DelAndSet(ctx context.Context, del []string, key string, data any, ttl time.Duration) error {
+	if ttl < 0 {
+		ttl = 0
+	}
+	_, err := r.rds.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(del) > 0 {
+			pipe.Del(ctx, del...)
+		}
+		pipe.Set(ctx, key, data, ttl)
+		return nil
+	})
+	return storeUnavailable(err)
+}
+
+// Exists 使用 EXISTS 判断缓存是否存在
+func (r redisStore) Exists(ctx context.Context, key string) (bool, error) {
+	n, err := r.rds.Exists(ctx, key).Result()
+	if err != nil {
+		return false, storeUnavailable(err)
+	}
+	return n > 0, nil
+}
+
+// Del 删除缓存。
+func (r redisStore) Del(ctx context.Context, key string) error {
+	cmd := r.rds.Do(ctx, "del", key)
+	return storeUnavailable(cmd.Err())
+}
+
+// DelMulti 使用一次 DEL 命令删除 keys 中的全部缓存
+func (r redisStore) DelMulti(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return storeUnavailable(r.rds.Del(ctx, keys...).Err())
+}
+
+// Touch 延长缓存的过期时间, KeepTTL 使用 PERSIST 移除过期时间
+func (r redisStore) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	var cmd *redis.Cmd
+	switch {
+	case ttl == KeepTTL:
+		// PERSIST 对没有过期时间的键返回 0, 需要单独检查键是否存在
+		exists, err := r.rds.Do(ctx, "exists", key).Int()
+		if err != nil {
+			return storeUnavailable(err)
+		}
+		if exists == 0 {
+			return ErrKeyNonExistent
+		}
+		return storeUnavailable(r.rds.Do(ctx, "persist", key).Err())
+	case ttl <= 0:
+		return fmt.Errorf("%w: %s", ErrInvalidTTL, ttl)
+	case usePrecise(ttl):
+		cmd = r.rds.Do(ctx, "pexpire", key, formatMs(ttl))
+	default:
+		cmd = r.rds.Do(ctx, "expire", key, formatSec(ttl))
+	}
+	ok, err := cmd.Bool()
+	if err != nil {
+		return storeUnavailable(err)
+	}
+	if !ok {
+		return ErrKeyNonExistent
+	}
+	return nil
+}
+
+// dumpScanCount 每次 SCAN 的数量
+const dumpScanCount = 100
+
+// globEscaper 转义 redis glob 模式中的特殊字符
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// DelByPrefix 使用 SCAN 查找 key 以 prefix 开头的键并分批删除, 返回删除的数量
+// 每次 SCAN 前检查 ctx, ctx 取消时中止删除, 返回已经删除的数量和 ctx 的错误
+// # 注意 SCAN 遍历期间写入的键可能不会被删除
+func (r redisStore) DelByPrefix(ctx context.Context, prefix string) (int, error) {
+	match := globEscaper.Replace(prefix) + "*"
+	var (
+		deleted int
+		cursor  uint64
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+		batch, next, err := r.rds.Scan(ctx, cursor, match, dumpScanCount).Result()
+		if err != nil {
+			return deleted, storeUnavailable(err)
+		}
+		if len(batch) > 0 {
+			n, err := r.rds.Del(ctx, batch...).Result()
+			deleted += int(n)
+			if err != nil {
+				return deleted, storeUnavailable(err)
+			}
+		}
+		if next == 0 {
+			return deleted, nil
+		}
+		cursor = next
+	}
+}
+
+// Keys 使用 SCAN 列出 redis 中的键, 最多返回 limit 个
+// # 注意 redis 中的全部键都会被列出, 包含不是 modecache 写入的键
+func (r redisStore) Keys(ctx context.Context, limit int) ([]string, error) {
+	if limit <= 0 {
+		return []string{}, nil
+	}
+	keys := make([]string, 0, min(limit, dumpScanCount))
+	var cursor uint64
+	for {
+		batch, next, err := r.rds.Scan(ctx, cursor, "", dumpScanCount).Result()
+		if err != nil {
+			return nil, storeUnavailable(err)
+		}
+		keys = append(keys, batch...)
+		if len(keys) >= limit {
+			return keys[:limit], nil
+		}
+		if next == 0 {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
+// Dump 使用 SCAN 和 MGET 列出 redis 中的数据, 最多返回 limit 个, 不是 string 类型的键会被忽略
+func (r redisStore) Dump(ctx context.Context, limit int) (map[string]any, error) {
+	keys, err := r.Keys(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]any, len(keys))
+	for start := 0; start < len(keys); start += dumpScanCount {
+		batch := keys[start:min(start+dumpScanCount, len(keys))]
+		values, err := r.rds.MGet(ctx, batch...).Result()
+		if err != nil {
+			return nil, storeUnavailable(err)
+		}
+		for i, value := range values {
+			if value != nil {
+				res[batch[i]] = cast.ToString(value)
+			}
+		}
+	}
+	return res, nil
+}
+
+func (r redisStore) IsDirectStore() bool {
+	return false
+}
+
+// NewRedisCache 新创建应该 redis cache
+func NewRedisStore(rd *redis.Client) Store {
+	return redisStore{rds: rd}
+}
+
+// 显示实现接口
+var (
+	_ Store     = (*RedisHashStore)(nil)
+	_ Clearable = (*RedisHashStore)(nil)
+)
+
+// NewRedisHashStore 创建 redis hash cache
+// 注意 NewHashStore 设置过期时间会对整个 hash 进行设置
+type RedisHashStore struct {
+	rds     *redis.Client
+	rdsKey  string
+	hashKey string      // 固定的 hash field, 为空时使用 modecache key 作为 hash field
+	ttlMode HashTTLMode // 整个 hash 过期时间的设置方式
+}
+
+// HashTTLMode RedisHashStore 写入 field 时设置整个 hash 过期时间的方式
+type HashTTLMode int
+
+const (
+	// HashTTLRefresh 每次写入都重置整个 hash 的过期时间 (默认), 适用于滑动过期,
+	// 只要有 field 持续写入, 所有 field 都不会过期
+	HashTTLRefresh HashTTLMode = iota
+	// HashTTLOnCreate 只在 hash 没有过期时间 (新创建) 时设置过期时间, 后续写入不会延长, 适用于绝对过期,
+	// 整个 hash 在第一次写入后的 ttl 过期
+	HashTTLOnCreate
+	// HashTTLMax 使用剩余过期时间和本次 ttl 中较大的值, 保证本次写入的 field 至少保存 ttl, 但不会缩短其他 field 的过期时间,
+	// 已经存在并且没有过期时间 (例如 KeepTTL 写入) 的 hash 保持永不过期
+	HashTTLMax
+)
+
+// hashTTLScript 按照模式设置 hash 的过期时间
+// KEYS[1]: hash key, ARGV[1]: 过期时间 (毫秒), ARGV[2]: 模式, ARGV[3]: 本次 HSET 新增的 field 数量
+// hash 的 field 数量大于新增数量时 hash 在本次写入前已经存在, max 模式下已经存在并且永不过期的 hash 不设置过期时间
+var hashTTLScript = redis.NewScript(`
+local pttl = redis.call('pttl', KEYS[1])
+if pttl == -1 and ARGV[2] == 'max' and redis.call('hlen', KEYS[1]) > tonumber(ARGV[3]) then
+	return 0
+end
+if pttl == -1 or (ARGV[2] == 'max' and pttl < tonumber(ARGV[1])) then
+	redis.call('pexpire', KEYS[1], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+// SetTTLMode 设置整个 hash 过期时间的设置方式, 默认 HashTTLRefresh, 需要在使用前设置
+// # 注意 KeepTTL 写入总是移除整个 hash 的过期时间, HashTTLOnCreate 模式下之后的写入会重新设置过期时间
+func (r *RedisHashStore) SetTTLMode(mode HashTTLMode) *RedisHashStore {
+	r.ttlMode = mode
+	return r
+}
+
+// field 获取本次操作的 hash field
+func (r *RedisHashStore) field(key string) string {
+	if r.hashKey != "" {
+		return r.hashKey
+	}
+	return key
+}
+
+// Get 获取缓存, 使用外部给定的 rds key 作为存储 key，避免和 modecache_key 冲突
+func (r *RedisHashStore) Get(ctx context.Context, key string) (any, error) {
+	cmd := r.rds.Do(ctx, "hget", r.rdsKey, r.field(key))
+	res, err := cmd.Result()
+	switch {
+	case err == nil:
+	case errors.Is(err, redis.Nil):
+		return nil, ErrKeyNonExistent
+	default:
+		return nil, storeUnavailable(err)
+	}
+	return cast.ToString(res), nil
+}
+
+// Set 设置缓存。
+func (r *RedisHashStore) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
+	//nolint:mnd
+	args := make([]any, 4)
+	args[0] = "hset"
+	args[1] = r.rdsKey
+	args[2] = r.field(key)
+	args[3] = data
+	cmd := r.rds.Do(ctx, args...)
+	if cmd.Err() != nil {
+		return storeUnavailable(cmd.Err())
+	}
+	added, _ := cmd.Int64()
+	r.expire(ctx, ttl, added)
+	return nil
+}
+
+// SetFields 使用一次 HSET 写入多个 field, 并且只设置一次整个 hash 的过期时间, fields 为 hash field 到数据的映射
+// 多个 field 在同一个命令中写入, 读取时不会看到部分写入的数据, fields 为空时不执行任何操作
+// # 注意数据直接写入 hash, 不会经过控制器装箱, 使用控制器读取的 field 需要写入装箱编码后的数据
+func (r *RedisHashStore) SetFields(ctx context.Context, fields map[string]any, ttl time.Duration) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	//nolint:mnd
+	args := make([]any, 0, 2+len(fields)*2)
+	args = append(args, "hset", r.rdsKey)
+	for field, data := range fields {
+		args = append(args, field, data)
+	}
+	added, err := r.rds.Do(ctx, args...).Int64()
+	if err != nil {
+		return storeUnavailable(err)
+	}
+	r.expire(ctx, ttl, added)
+	return nil
+}
+
+// expire 设置整个 hash 的过期时间, 按照 ttlMode 处理, added 为本次 HSET 新增的 field 数量
+func (r *RedisHashStore) expire(ctx context.Context, ttl time.Duration, added int64) {
+	// hash 类型无法直接设置过期时间，这里需要单独设置整个 hash 的过期时间
+	// KeepTTL 使用 PERSIST 移除整个 hash 之前设置的过期时间
+	switch {
+	case ttl == KeepTTL:
+		_ = r.rds.Do(ctx, "persist", r.rdsKey).Err()
+	case ttl > 0 && r.ttlMode == HashTTLOnCreate:
+		_ = hashTTLScript.Run(ctx, r.rds, []string{r.rdsKey}, formatMs(ttl), "create", added).Err()
+	case ttl > 0 && r.ttlMode == HashTTLMax:
+		_ = hashTTLScript.Run(ctx, r.rds, []string{r.rdsKey}, formatMs(ttl), "max", added).Err()
+	case ttl > 0:
+		if usePrecise(ttl) {
+			_ = r.rds.Do(ctx, "pexpire", r.rdsKey, formatMs(ttl)).Err()
+		} else {
+			_ = r.rds.Do(ctx, "expire", r.rdsKey, formatSec(ttl)).Err()
+		}
+	}
+}
+
+// Exists 使用 HEXISTS 判断 field 是否存在
+func (r *RedisHashStore) Exists(ctx context.Context, key string) (bool, error) {
+	exists, err := r.rds.HExists(ctx, r.rdsKey, r.field(key)).Result()
+	if err != nil {
+		return false, storeUnavailable(err)
+	}
+	return exists, nil
+}
+
+// TTL 获取整个 hash 的剩余过期时间, field 不存在时返回 ErrKeyNonExistent
+func (r *RedisHashStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	exists, err := r.rds.HExists(ctx, r.rdsKey, r.field(key)).Result()
+	if err != nil {
+		return 0, storeUnavailable(err)
+	}
+	if !exists {
+		return 0, ErrKeyNonExistent
+	}
+	return pttl(ctx, r.rds, r.rdsKey)
+}
+
+func (r *RedisHashStore) Del(ctx context.Context, key string) error {
+	cmd := r.rds.Do(ctx, "hdel", r.rdsKey, r.field(key))
+	return storeUnavailable(cmd.Err())
+}
+
+// IsDirectStore 判断是否是直接存储
+func (r *RedisHashStore) IsDirectStore() bool {
+	return false
+}
+
+// DelAll 删除整个 hash
+func (r *RedisHashStore) DelAll(ctx context.Context) error {
+	cmd := r.rds.Do(ctx, "del", r.rdsKey)
+	return storeUnavailable(cmd.Err())
+}
+
+// Clear 清空整个 hash, 同 DelAll
+func (r *RedisHashStore) Clear(ctx context.Context) error {
+	return r.DelAll(ctx)
+}
+
+// NewRedisHashStoreWithPrefix 新创建 hashKey redis 其中
+// key: redis key, 最后存储的 redis key 注意这里不应该使用 modecache_key
+// hashKey: redis hash key,注意不是 redis key
+// return: ctx 需要向下传递用来替换默认的 storage
+func NewRedisHashStore(ctx context.Context, rd *redis.Client, rdsKey string, rdsHashKey string) (context.Context, *RedisHashStore) {
+	if rdsKey == "" || rdsHashKey == "" {
+		panic("redis key or hash key is empty")
+	}
+	store := &RedisHashStore{rds: rd, hashKey: rdsHashKey, rdsKey: rdsKey}
+	ctx = context.WithValue(ctx, CtxStorageKey{}, store)
+	return ctx, store
+}
+
+// NewRedisHashFieldStore 新创建 hash field 由 modecache key 决定的 redis hash cache
+// 一个 store 绑定一个 hash, 使用 modecache key 作为 hash field, 可以作为控制器的默认 store 服务多个 field
+// key: redis key, 最后存储的 redis key 注意这里不应该使用 modecache_key
+func NewRedisHashFieldStore(rd *redis.Client, rdsKey string) *RedisHashStore {
+	if rdsKey == "" {
+		panic("redis key is empty")
+	}
+	return &RedisHashStore{rds: rd, rdsKey: rdsKey}
+}
@@ -0,0 +1,112 @@
+package modecache
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/cast"
+)
+
+// redisJSONRawPrefix 非 JSON 数据 (原始编码, proto 编码) 使用 base64 包装为 JSON 对象存储
+const redisJSONRawPrefix = `{"modecache_raw":"`
+
+// 使用 RedisJSON 模块 (JSON.SET/JSON.GET) 实现的缓存
+type redisJSONStore struct {
+	rds  *redis.Client
+	path string
+}
+
+// jsonStoreUnavailable 使用 ErrStoreUnavailable 包装 RedisJSON 错误, 未加载 RedisJSON 模块时返回明确的错误信息
+func jsonStoreUnavailable(err error) error {
+	if err != nil && strings.Contains(strings.ToLower(err.Error()), "unknown command") {
+		return fmt.Errorf("%w: RedisJSON module not loaded, %w", ErrStoreUnavailable, err)
+	}
+	return storeUnavailable(err)
+}
+
+// encodeJSONDoc 编码写入 RedisJSON 的文档, 合法的 JSON 直接写入, 其他数据使用 base64 包装
+func encodeJSONDoc(data any) string {
+	doc := cast.ToString(data)
+	if json.Valid([]byte(doc)) {
+		return doc
+	}
+	return redisJSONRawPrefix + base64.StdEncoding.EncodeToString([]byte(doc)) + `"}`
+}
+
+// decodeJSONDoc 解码 JSON.GET 读取的文档, $ 开头的 JSONPath 返回匹配结果的数组, 取第一个结果
+func decodeJSONDoc(doc string, path string) (string, error) {
+	if strings.HasPrefix(path, "$") {
+		var matches []json.RawMessage
+		if err := json.Unmarshal([]byte(doc), &matches); err != nil {
+			return "", fmt.Errorf("%w: decode RedisJSON result, %w", ErrUnpackingFailed, err)
+		}
+		if len(matches) == 0 {
+			return "", ErrKeyNonExistent
+		}
+		doc = string(matches[0])
+	}
+	if raw, ok := strings.CutPrefix(doc, redisJSONRawPrefix); ok {
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(raw, `"}`))
+		if err != nil {
+			return "", fmt.Errorf("%w: decode RedisJSON raw value, %w", ErrUnpackingFailed, err)
+		}
+		return string(data), nil
+	}
+	return doc, nil
+}
+
+func (r redisJSONStore) Get(ctx context.Context, key string) (any, error) {
+	doc, err := r.rds.Do(ctx, "JSON.GET", key, r.path).Text()
+	switch {
+	case err == nil:
+	case errors.Is(err, redis.Nil):
+		return nil, ErrKeyNonExistent
+	default:
+		return nil, jsonStoreUnavailable(err)
+	}
+	return decodeJSONDoc(doc, r.path)
+}
+
+// Set 使用 JSON.SET 写入 path, 在一个事务中设置过期时间, KeepTTL 使用 PERSIST 移除之前的过期时间
+func (r redisJSONStore) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
+	_, err := r.rds.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Do(ctx, "JSON.SET", key, r.path, encodeJSONDoc(data))
+		if ttl > 0 {
+			pipe.PExpire(ctx, key, ttl)
+		} else {
+			pipe.Persist(ctx, key)
+		}
+		return nil
+	})
+	return jsonStoreUnavailable(err)
+}
+
+func (r redisJSONStore) Del(ctx context.Context, key string) error {
+	return storeUnavailable(r.rds.Del(ctx, key).Err())
+}
+
+// TTL 使用 PTTL 获取缓存的剩余过期时间, 永不过期时返回 KeepTTL
+func (r redisJSONStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return pttl(ctx, r.rds, key)
+}
+
+func (r redisJSONStore) IsDirectStore() bool {
+	return false
+}
+
+// NewRedisJSONStore 创建使用 RedisJSON 模块的缓存, 使用 JSON.SET/JSON.GET 读写 path (例如 "$"), 之后可以在服务端读取或者修改单个字段
+// 控制器的 JSON 编码数据直接写入, 原始编码和 proto 编码的数据使用 base64 包装为 JSON 对象写入
+// # 注意 redis 需要加载 RedisJSON 模块, 否则读写返回 ErrStoreUnavailable 错误;
+// 非根路径的 path 需要 key 中已经存在文档, 这是 RedisJSON 的限制
+func NewRedisJSONStore(rds *redis.Client, path string) Store {
+	if path == "" {
+		path = "$"
+	}
+	return redisJSONStore{rds: rds, path: path}
+}
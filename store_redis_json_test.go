@@ -0,0 +1,109 @@
+package modecache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisJSONDoc(t *testing.T) {
+	// JSON 数据直接写入, 其他数据使用 base64 包装
+	for _, data := range []string{`{"Timestamp":1,"T":{"a":1}}`, "\x00\x02binary", "not json"} {
+		doc := encodeJSONDoc(data)
+		got, err := decodeJSONDoc(doc, ".")
+		assert.NoError(t, err)
+		assert.Equal(t, data, got)
+
+		// $ 路径返回匹配结果的数组
+		got, err = decodeJSONDoc("["+doc+"]", "$")
+		assert.NoError(t, err)
+		assert.Equal(t, data, got)
+	}
+	assert.Equal(t, `{"a":1}`, encodeJSONDoc(`{"a":1}`))
+
+	_, err := decodeJSONDoc("[]", "$")
+	assert.ErrorIs(t, err, ErrKeyNonExistent)
+	_, err = decodeJSONDoc("{", "$")
+	assert.ErrorIs(t, err, ErrUnpackingFailed)
+}
+
+func TestRedisJSONStore_ModuleNotLoaded(t *testing.T) {
+	s := miniredis.RunT(t)
+	store := NewRedisJSONStore(redis.NewClient(&redis.Options{Addr: s.Addr()}), "")
+	assert.False(t, store.IsDirectStore())
+
+	// miniredis 没有 RedisJSON 模块, miniredis 在事务中执行未知命令时不返回, 只测试读取
+	_, err := store.Get(context.Background(), "key")
+	assert.ErrorIs(t, err, ErrStoreUnavailable)
+	assert.ErrorContains(t, err, "RedisJSON module not loaded")
+}
+
+// recordHook 记录发送的命令并返回固定的结果, 不访问 redis
+type recordHook struct {
+	mu    sync.Mutex
+	cmds  [][]any
+	reply any // 单个命令返回的结果
+}
+
+func (h *recordHook) record(cmd redis.Cmder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cmds = append(h.cmds, cmd.Args())
+	if c, ok := cmd.(*redis.Cmd); ok && h.reply != nil {
+		c.SetVal(h.reply)
+	}
+}
+
+func (h *recordHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *recordHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		h.record(cmd)
+		return nil
+	}
+}
+
+func (h *recordHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			h.record(cmd)
+		}
+		return nil
+	}
+}
+
+func TestRedisJSONStore_Commands(t *testing.T) {
+	ctx := context.Background()
+	hook := &recordHook{}
+	rds := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"})
+	rds.AddHook(hook)
+	store := NewRedisJSONStore(rds, "")
+
+	// 写入在事务中使用 JSON.SET 和 PEXPIRE, KeepTTL 使用 PERSIST
+	assert.NoError(t, store.Set(ctx, "key", `{"a":1}`, time.Minute))
+	assert.NoError(t, store.Set(ctx, "key", "\x00raw", KeepTTL))
+	assert.Equal(t, [][]any{
+		{"multi"},
+		{"JSON.SET", "key", "$", `{"a":1}`},
+		{"pexpire", "key", int64(60000)},
+		{"exec"},
+		{"multi"},
+		{"JSON.SET", "key", "$", encodeJSONDoc("\x00raw")},
+		{"persist", "key"},
+		{"exec"},
+	}, hook.cmds)
+
+	// 读取使用 JSON.GET, $ 路径取第一个匹配结果
+	hook.cmds, hook.reply = nil, `[{"a":1}]`
+	data, err := store.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":1}`, data)
+	assert.Equal(t, [][]any{{"JSON.GET", "key", "$"}}, hook.cmds)
+}
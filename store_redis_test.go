@@ -1,214 +1,536 @@
-package modecache
-
-import (
-	"context"
-	"testing"
-	"time"
-
-	"github.com/alicebob/miniredis/v2"
-	"github.com/redis/go-redis/v9"
-	"github.com/stretchr/testify/assert"
-)
-
-func getRedis() (Store, func()) {
-	s, err := miniredis.Run()
-	if err != nil {
-		panic(err)
-	}
-	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
-
-	return NewRedisStore(client), func() {
-		client.Close()
-		s.Close()
-	}
-}
-
-func getTestRedis() (*redis.Client, func()) {
-	s, err := miniredis.Run()
-	if err != nil {
-		panic(err)
-	}
-	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
-
-	return client, func() {
-		client.Close()
-		s.Close()
-	}
-}
-
-func TestRedisStore_Get(t *testing.T) {
-	// 创建 cacheStore 对象
-	store, cleanup := getRedis()
-	defer cleanup()
-
-	// 设置缓存
-	err := store.Set(context.Background(), "key", 123, time.Hour)
-	assert.NoError(t, err)
-
-	// 获取缓存
-	value, err := store.Get(context.Background(), "key")
-	assert.NoError(t, err)
-	assert.Equal(t, "123", value)
-
-	// 设置缓存 String
-	err = store.Set(context.Background(), "key", "123", time.Hour)
-	assert.NoError(t, err)
-
-	// 获取缓存
-	value, err = store.Get(context.Background(), "key")
-	assert.NoError(t, err)
-	assert.Equal(t, "123", value)
-
-	// 设置缓存使用 KeepTTL
-	err = store.Set(context.Background(), "key", "123", KeepTTL)
-	assert.NoError(t, err)
-
-	// 获取缓存
-	value, err = store.Get(context.Background(), "key")
-	assert.NoError(t, err)
-	assert.Equal(t, "123", value)
-}
-
-func TestRedisStore_Get_NonExistent(t *testing.T) {
-	// 创建 cacheStore 对象
-	store, cleanup := getRedis()
-	defer cleanup()
-
-	// 获取不存在的缓存
-	value, err := store.Get(context.Background(), "key_not_is")
-	assert.EqualError(t, err, ErrKeyNonExistent.Error())
-	assert.Zero(t, value)
-}
-
-func TestRedisStore_Del(t *testing.T) {
-	// 创建缓存对象
-	store, cleanup := getRedis()
-	defer cleanup()
-
-	// 设置缓存
-	err := store.Set(context.Background(), "key", 123, time.Hour)
-	assert.NoError(t, err)
-
-	// 删除缓存
-	err = store.Del(context.Background(), "key")
-	assert.NoError(t, err)
-
-	// 验证缓存是否删除成功
-	_, err = store.Get(context.Background(), "key")
-	assert.Error(t, err, ErrKeyNonExistent)
-}
-
-func TestRedisStore_Set(t *testing.T) {
-	// 创建 cacheStore 对象
-	rds, cleanup := getTestRedis()
-	defer cleanup()
-
-	// 设置缓存并验证
-	_, store := NewRedisHashStore(context.Background(), rds, "key", "hashKey")
-	err := store.Set(context.Background(), "key-str", "value", time.Hour)
-	assert.NoError(t, err)
-	// 获取缓存并验证
-	value, err := store.Get(context.Background(), "key")
-	assert.NoError(t, err)
-	assert.Equal(t, "value", value)
-
-	// 设置缓存为整数并验证
-	err = store.Set(context.Background(), "key-int", 123, time.Hour)
-	assert.NoError(t, err)
-
-	value, err = store.Get(context.Background(), "key")
-	assert.NoError(t, err)
-	assert.Equal(t, "123", value)
-
-	// 设置缓存为浮点数并验证
-	err = store.Set(context.Background(), "key-float", 123.45, time.Hour)
-	assert.NoError(t, err)
-
-	value, err = store.Get(context.Background(), "key")
-	assert.NoError(t, err)
-	assert.Equal(t, "123.45", value)
-
-	// 设置缓存为布尔值并验证
-	err = store.Set(context.Background(), "key-bool", true, time.Hour)
-	assert.NoError(t, err)
-
-	value, err = store.Get(context.Background(), "key")
-	assert.NoError(t, err)
-	assert.Equal(t, "1", value)
-
-	// 验证过期
-	// err = store.Set(context.Background(), "key-ext", "value-ext", 1*time.Second)
-	// assert.NoError(t, err)
-	// // 查询
-	// value, err = store.Get(context.Background(), "key-ext")
-	// assert.NoError(t, err)
-	// assert.Equal(t, "value-ext", value)
-	// time.Sleep(2 * time.Second)
-	// // 查询
-	// _, err = store.Get(context.Background(), "key-ext")
-	// assert.EqualError(t, err, ErrKeyNonExistent.Error())
-}
-
-func TestRedisHashStore_Get(t *testing.T) {
-	// 创建 hashStore 对象
-	rds, cleanup := getTestRedis()
-	defer cleanup()
-
-	// 设置缓存
-	rdsKey := "library-hash-key"
-	_, store := NewRedisHashStore(context.Background(), rds, rdsKey, "field")
-
-	err := store.Set(context.Background(), rdsKey, "value", time.Hour)
-	assert.NoError(t, err)
-
-	// 获取缓存并验证
-	value, err := store.Get(context.Background(), rdsKey)
-	assert.NoError(t, err)
-	assert.Equal(t, "value", value)
-
-	// 获取不存在的缓存
-	_, store = NewRedisHashStore(context.Background(), rds, rdsKey, "nonexistent_field")
-	value, err = store.Get(context.Background(), rdsKey)
-	assert.EqualError(t, err, ErrKeyNonExistent.Error())
-	assert.Zero(t, value)
-}
-
-func TestRedisHashStore_Del(t *testing.T) {
-	// 创建 hashStore 对象
-	rds, cleanup := getTestRedis()
-	defer cleanup()
-
-	// 设置缓存
-	rdsKey := "library-hash-key"
-	_, store := NewRedisHashStore(context.Background(), rds, rdsKey, "field")
-	err := store.Set(context.Background(), rdsKey, "value", time.Hour)
-	assert.NoError(t, err)
-
-	// 删除缓存
-	err = store.Del(context.Background(), rdsKey)
-	assert.NoError(t, err)
-
-	// 验证缓存是否删除成功
-	_, err = store.Get(context.Background(), "field")
-	assert.EqualError(t, err, ErrKeyNonExistent.Error())
-}
-
-func TestRedisHashStore_DelAll(t *testing.T) {
-	// 创建 hashStore 对象
-	rds, cleanup := getTestRedis()
-	defer cleanup()
-
-	// 设置缓存
-	rdsKey := "library-hash-key"
-	_, store := NewRedisHashStore(context.Background(), rds, rdsKey, "field")
-	err := store.Set(context.Background(), rdsKey, "value", time.Hour)
-	assert.NoError(t, err)
-
-	// 删除缓存
-	err = store.DelAll(context.Background())
-	assert.NoError(t, err)
-
-	// 验证缓存是否删除成功
-	_, err = store.Get(context.Background(), "field")
-	assert.EqualError(t, err, ErrKeyNonExistent.Error())
-}
+package modecache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func getRedis() (Store, func()) {
+	s, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+
+	return NewRedisStore(client), func() {
+		client.Close()
+		s.Close()
+	}
+}
+
+// getClosedRedis 获取连接已经关闭的 redis 缓存, 读写都返回 ErrStoreUnavailable
+func getClosedRedis(t *testing.T) Store {
+	s := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: s.Addr(), MaxRetries: -1})
+	s.Close()
+	t.Cleanup(func() { _ = client.Close() })
+	return NewRedisStore(client)
+}
+
+func getTestRedis() (*redis.Client, func()) {
+	s, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+
+	return client, func() {
+		client.Close()
+		s.Close()
+	}
+}
+
+func TestRedisStore_Get(t *testing.T) {
+	// 创建 cacheStore 对象
+	store, cleanup := getRedis()
+	defer cleanup()
+
+	// 设置缓存
+	err := store.Set(context.Background(), "key", 123, time.Hour)
+	assert.NoError(t, err)
+
+	// 获取缓存
+	value, err := store.Get(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "123", value)
+
+	// 设置缓存 String
+	err = store.Set(context.Background(), "key", "123", time.Hour)
+	assert.NoError(t, err)
+
+	// 获取缓存
+	value, err = store.Get(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "123", value)
+
+	// 设置缓存使用 KeepTTL
+	err = store.Set(context.Background(), "key", "123", KeepTTL)
+	assert.NoError(t, err)
+
+	// 获取缓存
+	value, err = store.Get(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "123", value)
+}
+
+func TestRedisStore_SetExpiration(t *testing.T) {
+	rds, cleanup := getTestRedis()
+	defer cleanup()
+	ctx := context.Background()
+	store := NewRedisStore(rds)
+
+	// 秒级和毫秒级过期时间
+	assert.NoError(t, store.Set(ctx, "key", "1", time.Hour))
+	assert.Equal(t, time.Hour, rds.TTL(ctx, "key").Val())
+	assert.NoError(t, store.Set(ctx, "key", "1", 1500*time.Millisecond))
+	assert.Equal(t, 1500*time.Millisecond, rds.PTTL(ctx, "key").Val())
+
+	// KeepTTL 永久存储, 移除之前的过期时间
+	assert.NoError(t, store.Set(ctx, "key", "1", KeepTTL))
+	assert.Equal(t, time.Duration(-1), rds.TTL(ctx, "key").Val())
+
+	// 数值和布尔值由 go-redis 编码
+	assert.NoError(t, store.Set(ctx, "bool", true, time.Hour))
+	value, err := store.Get(ctx, "bool")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", value)
+}
+
+func TestRedisStore_Get_NonExistent(t *testing.T) {
+	// 创建 cacheStore 对象
+	store, cleanup := getRedis()
+	defer cleanup()
+
+	// 获取不存在的缓存
+	value, err := store.Get(context.Background(), "key_not_is")
+	assert.EqualError(t, err, ErrKeyNonExistent.Error())
+	assert.Zero(t, value)
+}
+
+func TestRedisStore_Del(t *testing.T) {
+	// 创建缓存对象
+	store, cleanup := getRedis()
+	defer cleanup()
+
+	// 设置缓存
+	err := store.Set(context.Background(), "key", 123, time.Hour)
+	assert.NoError(t, err)
+
+	// 删除缓存
+	err = store.Del(context.Background(), "key")
+	assert.NoError(t, err)
+
+	// 验证缓存是否删除成功
+	_, err = store.Get(context.Background(), "key")
+	assert.Error(t, err, ErrKeyNonExistent)
+}
+
+func TestRedisStore_Set(t *testing.T) {
+	// 创建 cacheStore 对象
+	rds, cleanup := getTestRedis()
+	defer cleanup()
+
+	// 设置缓存并验证
+	_, store := NewRedisHashStore(context.Background(), rds, "key", "hashKey")
+	err := store.Set(context.Background(), "key-str", "value", time.Hour)
+	assert.NoError(t, err)
+	// 获取缓存并验证
+	value, err := store.Get(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	// 设置缓存为整数并验证
+	err = store.Set(context.Background(), "key-int", 123, time.Hour)
+	assert.NoError(t, err)
+
+	value, err = store.Get(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "123", value)
+
+	// 设置缓存为浮点数并验证
+	err = store.Set(context.Background(), "key-float", 123.45, time.Hour)
+	assert.NoError(t, err)
+
+	value, err = store.Get(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "123.45", value)
+
+	// 设置缓存为布尔值并验证
+	err = store.Set(context.Background(), "key-bool", true, time.Hour)
+	assert.NoError(t, err)
+
+	value, err = store.Get(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", value)
+
+	// 验证过期
+	// err = store.Set(context.Background(), "key-ext", "value-ext", 1*time.Second)
+	// assert.NoError(t, err)
+	// // 查询
+	// value, err = store.Get(context.Background(), "key-ext")
+	// assert.NoError(t, err)
+	// assert.Equal(t, "value-ext", value)
+	// time.Sleep(2 * time.Second)
+	// // 查询
+	// _, err = store.Get(context.Background(), "key-ext")
+	// assert.EqualError(t, err, ErrKeyNonExistent.Error())
+}
+
+func TestRedisHashStore_Get(t *testing.T) {
+	// 创建 hashStore 对象
+	rds, cleanup := getTestRedis()
+	defer cleanup()
+
+	// 设置缓存
+	rdsKey := "library-hash-key"
+	_, store := NewRedisHashStore(context.Background(), rds, rdsKey, "field")
+
+	err := store.Set(context.Background(), rdsKey, "value", time.Hour)
+	assert.NoError(t, err)
+
+	// 获取缓存并验证
+	value, err := store.Get(context.Background(), rdsKey)
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	// 获取不存在的缓存
+	_, store = NewRedisHashStore(context.Background(), rds, rdsKey, "nonexistent_field")
+	value, err = store.Get(context.Background(), rdsKey)
+	assert.EqualError(t, err, ErrKeyNonExistent.Error())
+	assert.Zero(t, value)
+}
+
+func TestRedisHashStore_Del(t *testing.T) {
+	// 创建 hashStore 对象
+	rds, cleanup := getTestRedis()
+	defer cleanup()
+
+	// 设置缓存
+	rdsKey := "library-hash-key"
+	_, store := NewRedisHashStore(context.Background(), rds, rdsKey, "field")
+	err := store.Set(context.Background(), rdsKey, "value", time.Hour)
+	assert.NoError(t, err)
+
+	// 删除缓存
+	err = store.Del(context.Background(), rdsKey)
+	assert.NoError(t, err)
+
+	// 验证缓存是否删除成功
+	_, err = store.Get(context.Background(), "field")
+	assert.EqualError(t, err, ErrKeyNonExistent.Error())
+}
+
+func TestRedisHashStore_DelAll(t *testing.T) {
+	// 创建 hashStore 对象
+	rds, cleanup := getTestRedis()
+	defer cleanup()
+
+	// 设置缓存
+	rdsKey := "library-hash-key"
+	_, store := NewRedisHashStore(context.Background(), rds, rdsKey, "field")
+	err := store.Set(context.Background(), rdsKey, "value", time.Hour)
+	assert.NoError(t, err)
+
+	// 删除缓存
+	err = store.DelAll(context.Background())
+	assert.NoError(t, err)
+
+	// 验证缓存是否删除成功
+	_, err = store.Get(context.Background(), "field")
+	assert.EqualError(t, err, ErrKeyNonExistent.Error())
+}
+
+func TestRedisStore_Unavailable(t *testing.T) {
+	s, err := miniredis.Run()
+	assert.NoError(t, err)
+	client := redis.NewClient(&redis.Options{Addr: s.Addr(), MaxRetries: -1})
+	defer client.Close()
+
+	store := NewRedisStore(client)
+	_, hashStore := NewRedisHashStore(context.Background(), client, "key", "hashKey")
+
+	// redis 不可用
+	s.Close()
+
+	// 连接错误使用 ErrStoreUnavailable 包装
+	_, err = store.Get(context.Background(), "key")
+	assert.ErrorIs(t, err, ErrStoreUnavailable)
+	assert.NotErrorIs(t, err, ErrKeyNonExistent)
+	assert.ErrorIs(t, store.Set(context.Background(), "key", "value", time.Hour), ErrStoreUnavailable)
+	assert.ErrorIs(t, store.Del(context.Background(), "key"), ErrStoreUnavailable)
+
+	_, err = hashStore.Get(context.Background(), "key")
+	assert.ErrorIs(t, err, ErrStoreUnavailable)
+	assert.ErrorIs(t, hashStore.Set(context.Background(), "key", "value", time.Hour), ErrStoreUnavailable)
+	assert.ErrorIs(t, hashStore.Del(context.Background(), "key"), ErrStoreUnavailable)
+	assert.ErrorIs(t, hashStore.DelAll(context.Background()), ErrStoreUnavailable)
+}
+
+func TestRedisHashFieldStore(t *testing.T) {
+	rds, cleanup := getTestRedis()
+	defer cleanup()
+
+	rdsKey := "library-hash-field-key"
+	store := NewRedisHashFieldStore(rds, rdsKey)
+
+	// 不同的 modecache key 存储在同一个 hash 的不同 field
+	assert.NoError(t, store.Set(context.Background(), "field-a", "a", time.Hour))
+	assert.NoError(t, store.Set(context.Background(), "field-b", "b", time.Hour))
+
+	value, err := store.Get(context.Background(), "field-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", value)
+	value, err = store.Get(context.Background(), "field-b")
+	assert.NoError(t, err)
+	assert.Equal(t, "b", value)
+	assert.Equal(t, int64(2), rds.HLen(context.Background(), rdsKey).Val())
+
+	// 删除单个 field
+	assert.NoError(t, store.Del(context.Background(), "field-a"))
+	_, err = store.Get(context.Background(), "field-a")
+	assert.EqualError(t, err, ErrKeyNonExistent.Error())
+	_, err = store.Get(context.Background(), "field-b")
+	assert.NoError(t, err)
+
+	// 作为控制器的默认 store
+	ctr := NewCacheController[int]("test-hash-field", store)
+	for i := 0; i < 3; i++ {
+		res, err := ctr.Wrap(context.Background(), fmt.Sprintf("ctr-%d", i), func(ctx context.Context) (int, error) {
+			return i, nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, i, res)
+	}
+	assert.Equal(t, int64(4), rds.HLen(context.Background(), rdsKey).Val())
+}
+
+func TestRedisStore_SetIfNewer(t *testing.T) {
+	store, closer := getRedis()
+	defer closer()
+	cStore := store.(ConditionalStore)
+	ctx := context.Background()
+
+	// JSON 编码的数据, 同一秒内的写入使用毫秒时间戳比较
+	ok, err := cStore.SetIfNewer(ctx, "json", `{"Timestamp":100,"TimestampMs":100500,"T":1}`, 100500, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = cStore.SetIfNewer(ctx, "json", `{"Timestamp":100,"TimestampMs":100200,"T":2}`, 100200, time.Hour)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = cStore.SetIfNewer(ctx, "json", `{"Timestamp":100,"TimestampMs":100800,"T":3}`, 100800, KeepTTL)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	value, err := store.Get(ctx, "json")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"Timestamp":100,"TimestampMs":100800,"T":3}`, value)
+
+	// 没有毫秒时间戳的旧数据使用秒级时间戳
+	assert.NoError(t, store.Set(ctx, "legacy", `{"Timestamp":100,"T":1}`, time.Hour))
+	ok, err = cStore.SetIfNewer(ctx, "legacy", `{"Timestamp":99,"TimestampMs":99999,"T":2}`, 99999, time.Hour)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = cStore.SetIfNewer(ctx, "legacy", `{"Timestamp":100,"TimestampMs":100001,"T":3}`, 100001, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// 原始编码的数据, 时间戳需要多个字节的 varint, 同一秒内使用头部的毫秒时间戳比较
+	raw, _ := encodeRawBox(&AbcBox[string]{Timestamp: 1700000000, TimestampMs: 1700000000500, T: "new"})
+	ok, err = cStore.SetIfNewer(ctx, "raw", raw, 1700000000500, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	old, _ := encodeRawBox(&AbcBox[string]{Timestamp: 1700000000, TimestampMs: 1700000000200, T: "old"})
+	ok, err = cStore.SetIfNewer(ctx, "raw", old, 1700000000200, time.Hour)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	value, err = store.Get(ctx, "raw")
+	assert.NoError(t, err)
+	assert.Equal(t, raw, value)
+
+	// 没有毫秒时间戳的原始编码旧数据
+	legacy, _ := encodeRawBox(&AbcBox[string]{Timestamp: 1700000000, T: "legacy"})
+	assert.NoError(t, store.Set(ctx, "raw-legacy", legacy, time.Hour))
+	ok, err = cStore.SetIfNewer(ctx, "raw-legacy", old, 1699999999999, time.Hour)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = cStore.SetIfNewer(ctx, "raw-legacy", raw, 1700000000500, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestRedisHashStore_KeepTTL(t *testing.T) {
+	s := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer rds.Close()
+
+	rdsKey := "library-hash-key"
+	store := NewRedisHashFieldStore(rds, rdsKey)
+
+	// 短过期时间写入后永久写入, 移除 hash 的过期时间
+	assert.NoError(t, store.Set(context.Background(), "a", "1", time.Second))
+	assert.NoError(t, store.Set(context.Background(), "b", "2", KeepTTL))
+	s.FastForward(2 * time.Second)
+
+	value, err := store.Get(context.Background(), "a")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", value)
+	assert.Equal(t, time.Duration(-1), rds.TTL(context.Background(), rdsKey).Val())
+}
+
+func TestRedisHashStore_TTLMode(t *testing.T) {
+	s := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer rds.Close()
+	ctx := context.Background()
+
+	// 默认每次写入重置过期时间
+	store := NewRedisHashFieldStore(rds, "refresh")
+	assert.NoError(t, store.Set(ctx, "a", "1", time.Hour))
+	assert.NoError(t, store.Set(ctx, "b", "2", time.Minute))
+	assert.Equal(t, time.Minute, rds.TTL(ctx, "refresh").Val())
+
+	// 只在创建时设置过期时间
+	store = NewRedisHashFieldStore(rds, "create").SetTTLMode(HashTTLOnCreate)
+	assert.NoError(t, store.Set(ctx, "a", "1", time.Minute))
+	assert.NoError(t, store.Set(ctx, "b", "2", time.Hour))
+	assert.Equal(t, time.Minute, rds.TTL(ctx, "create").Val())
+
+	// 使用较大的过期时间
+	store = NewRedisHashFieldStore(rds, "max").SetTTLMode(HashTTLMax)
+	assert.NoError(t, store.Set(ctx, "a", "1", time.Hour))
+	assert.NoError(t, store.Set(ctx, "b", "2", time.Minute))
+	assert.Equal(t, time.Hour, rds.TTL(ctx, "max").Val())
+	assert.NoError(t, store.Set(ctx, "c", "3", 2*time.Hour))
+	assert.Equal(t, 2*time.Hour, rds.TTL(ctx, "max").Val())
+
+	// 永不过期的 hash 保持永不过期
+	assert.NoError(t, store.Set(ctx, "d", "4", KeepTTL))
+	assert.NoError(t, store.Set(ctx, "e", "5", time.Minute))
+	assert.Equal(t, time.Duration(-1), rds.TTL(ctx, "max").Val())
+	store = NewRedisHashFieldStore(rds, "max-new").SetTTLMode(HashTTLMax)
+	assert.NoError(t, store.SetFields(ctx, map[string]any{"a": "1", "b": "2"}, time.Minute))
+	assert.Equal(t, time.Minute, rds.TTL(ctx, "max-new").Val())
+}
+
+func TestRedisHashStore_SetFields(t *testing.T) {
+	s := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer rds.Close()
+	ctx := context.Background()
+
+	store := NewRedisHashFieldStore(rds, "object")
+	assert.NoError(t, store.SetFields(ctx, map[string]any{"a": "1", "b": "2", "c": "3"}, time.Minute))
+	assert.Equal(t, map[string]string{"a": "1", "b": "2", "c": "3"}, rds.HGetAll(ctx, "object").Val())
+	assert.Equal(t, time.Minute, rds.TTL(ctx, "object").Val())
+
+	value, err := store.Get(ctx, "b")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", value)
+
+	// 空 fields 不执行任何操作
+	assert.NoError(t, store.SetFields(ctx, nil, time.Hour))
+	assert.Equal(t, time.Minute, rds.TTL(ctx, "object").Val())
+}
+
+func TestRedisStore_Dump(t *testing.T) {
+	store, closer := getRedis()
+	defer closer()
+	ctx := context.Background()
+
+	for i := 0; i < 250; i++ {
+		assert.NoError(t, store.Set(ctx, fmt.Sprintf("key%d", i), i, time.Hour))
+	}
+
+	// 分批 SCAN 和 MGET
+	items, err := DumpStore(ctx, store, 1000)
+	assert.NoError(t, err)
+	assert.Len(t, items, 250)
+	assert.Equal(t, "7", items["key7"])
+
+	keys, err := store.(Dumpable).Keys(ctx, 120)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 120)
+
+	// 非正数的 limit 不返回数据
+	for _, limit := range []int{0, -1} {
+		keys, err = store.(Dumpable).Keys(ctx, limit)
+		assert.NoError(t, err)
+		assert.Empty(t, keys)
+	}
+}
+
+func TestRedisStore_ScanDel(t *testing.T) {
+	store, closer := getRedis()
+	defer closer()
+	ctx := context.Background()
+
+	for i := 0; i < 50; i++ {
+		assert.NoError(t, store.Set(ctx, fmt.Sprintf("user:%d", i), i, time.Hour))
+	}
+	assert.NoError(t, store.Set(ctx, "order:1", 1, time.Hour))
+	assert.NoError(t, store.Set(ctx, "user*", 1, time.Hour))
+
+	// ctx 取消时中止删除
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	deleted, err := ScanDel(cancelCtx, store, "user:")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, deleted)
+
+	// 前缀中的 glob 字符按字面匹配
+	deleted, err = ScanDel(ctx, store, "user*")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	deleted, err = ScanDel(ctx, store, "user:")
+	assert.NoError(t, err)
+	assert.Equal(t, 50, deleted)
+	_, err = store.Get(ctx, "order:1")
+	assert.NoError(t, err)
+
+	_, err = ScanDel(ctx, NewNoopStore(), "user:")
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestRedisStore_TTL(t *testing.T) {
+	rds, cleanup := getTestRedis()
+	defer cleanup()
+	ctx := context.Background()
+
+	for _, store := range []Store{NewRedisStore(rds), NewRedisHashFieldStore(rds, "hash")} {
+		reader := store.(TTLReader)
+		_, err := reader.TTL(ctx, "key")
+		assert.ErrorIs(t, err, ErrKeyNonExistent)
+
+		assert.NoError(t, store.Set(ctx, "key", "1", time.Minute))
+		ttl, err := reader.TTL(ctx, "key")
+		assert.NoError(t, err)
+		assert.Equal(t, time.Minute, ttl)
+
+		assert.NoError(t, store.Set(ctx, "key", "1", KeepTTL))
+		ttl, err = reader.TTL(ctx, "key")
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(KeepTTL), ttl)
+	}
+}
+
+func TestRedisStore_DelMulti(t *testing.T) {
+	ctx := context.Background()
+	store, closer := getRedis()
+	defer closer()
+
+	deleter := store.(BatchDeleter)
+	assert.NoError(t, store.Set(ctx, "a", "1", time.Minute))
+	assert.NoError(t, store.Set(ctx, "b", "2", time.Minute))
+	assert.NoError(t, deleter.DelMulti(ctx, []string{"a", "b", "missing"}))
+	assert.NoError(t, deleter.DelMulti(ctx, nil))
+	for _, key := range []string{"a", "b"} {
+		_, err := store.Get(ctx, key)
+		assert.ErrorIs(t, err, ErrKeyNonExistent)
+	}
+}
@@ -0,0 +1,89 @@
+package modecache
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// DefaultShardReplicas 一致性哈希环上每个分片的默认虚拟节点数量
+const DefaultShardReplicas = 100
+
+// shardNode 一致性哈希环上的虚拟节点
+type shardNode struct {
+	hash  uint
+	shard int
+}
+
+// 使用一致性哈希把 key 路由到多个 Store 的分片缓存
+type shardedStore struct {
+	shards []Store
+	ring   []shardNode // 按 hash 排序的虚拟节点
+	direct bool
+}
+
+// pick 选择 key 所在的分片, 顺时针查找第一个虚拟节点
+func (s *shardedStore) pick(key string) Store {
+	hash := HashFnv1aToUint(key)
+	i, _ := slices.BinarySearchFunc(s.ring, hash, func(node shardNode, hash uint) int {
+		return cmp.Compare(node.hash, hash)
+	})
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.shards[s.ring[i].shard]
+}
+
+func (s *shardedStore) Get(ctx context.Context, key string) (any, error) {
+	return s.pick(key).Get(ctx, key)
+}
+
+func (s *shardedStore) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
+	return s.pick(key).Set(ctx, key, data, ttl)
+}
+
+func (s *shardedStore) Del(ctx context.Context, key string) error {
+	return s.pick(key).Del(ctx, key)
+}
+
+func (s *shardedStore) IsDirectStore() bool {
+	return s.direct
+}
+
+// NewShardedStore 创建使用一致性哈希路由的分片缓存, 例如把缓存分散到多个 redis 实例
+// 每个分片在哈希环上有 replicas 个虚拟节点, replicas <= 0 时使用 DefaultShardReplicas, 增加或者删除分片只会迁移部分 key
+// # 注意分片使用在 shards 中的位置标识, 扩容时应该把新的分片追加到末尾, 不要调整已有分片的顺序
+// # 注意 shards 为空或者 IsDirectStore 不一致时 panic
+func NewShardedStore(shards []Store, replicas int) Store {
+	if len(shards) == 0 {
+		panic("modecache: sharded store need at least one shard")
+	}
+	if replicas <= 0 {
+		replicas = DefaultShardReplicas
+	}
+
+	s := &shardedStore{
+		shards: shards,
+		ring:   make([]shardNode, 0, len(shards)*replicas),
+		direct: shards[0].IsDirectStore(),
+	}
+	for i, shard := range shards {
+		if shard.IsDirectStore() != s.direct {
+			panic(fmt.Sprintf("modecache: sharded store shard %d IsDirectStore %t, but shard 0 is %t",
+				i, shard.IsDirectStore(), s.direct))
+		}
+		for r := 0; r < replicas; r++ {
+			s.ring = append(s.ring, shardNode{
+				hash:  HashFnv1aToUint(strconv.Itoa(i) + "#" + strconv.Itoa(r)),
+				shard: i,
+			})
+		}
+	}
+	slices.SortFunc(s.ring, func(a, b shardNode) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+	return s
+}
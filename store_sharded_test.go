@@ -0,0 +1,67 @@
+package modecache
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedStore(t *testing.T) {
+	shards := []Store{NewMemoryStore(), NewMemoryStore(), NewMemoryStore()}
+	store := NewShardedStore(shards, 0)
+	assert.True(t, store.IsDirectStore())
+
+	// 每个 key 只写入一个分片
+	for i := 0; i < 300; i++ {
+		key := "key" + strconv.Itoa(i)
+		assert.NoError(t, store.Set(context.Background(), key, i, time.Minute))
+		value, err := store.Get(context.Background(), key)
+		assert.NoError(t, err)
+		assert.Equal(t, i, value)
+
+		found := 0
+		for _, shard := range shards {
+			if _, err := shard.Get(context.Background(), key); err == nil {
+				found++
+			}
+		}
+		assert.Equal(t, 1, found)
+	}
+
+	// 删除缓存
+	assert.NoError(t, store.Del(context.Background(), "key1"))
+	_, err := store.Get(context.Background(), "key1")
+	assert.ErrorIs(t, err, ErrKeyNonExistent)
+}
+
+func TestShardedStore_Remap(t *testing.T) {
+	before := NewShardedStore([]Store{NewMemoryStore(), NewMemoryStore(), NewMemoryStore()}, 0).(*shardedStore)
+	after := NewShardedStore(append(before.shards[:3:3], NewMemoryStore()), 0).(*shardedStore)
+
+	// 增加一个分片只迁移部分 key, 未迁移的 key 保持原来的分片
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		if after.pick(key) != before.pick(key) {
+			moved++
+			assert.Equal(t, after.shards[3], after.pick(key))
+		}
+	}
+	assert.Greater(t, moved, 0)
+	assert.Less(t, moved, 500)
+}
+
+func TestShardedStore_Invalid(t *testing.T) {
+	assert.Panics(t, func() {
+		NewShardedStore(nil, 0)
+	})
+
+	store, closer := getRedis()
+	defer closer()
+	assert.Panics(t, func() {
+		NewShardedStore([]Store{NewMemoryStore(), store}, 0)
+	})
+}
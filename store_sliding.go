@@ -0,0 +1,80 @@
+package modecache
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SlidingStore 滑动过期的缓存装饰器, 缓存被读取时延长过期时间, 适用于会话类数据
+// 每次 Get 成功后读取剩余过期时间, 低于阈值时通过 TTLExtender 把过期时间重置为 ttl, 永不过期 (KeepTTL) 的缓存不处理
+// # 注意延长过期时间失败不影响 Get 的结果
+type SlidingStore struct {
+	inner     Store
+	reader    TTLReader
+	extender  TTLExtender
+	ttl       time.Duration
+	threshold time.Duration
+}
+
+// Get 获取缓存, 成功后按需延长过期时间
+func (s *SlidingStore) Get(ctx context.Context, key string) (any, error) {
+	data, err := s.inner.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	s.slide(ctx, key)
+	return data, nil
+}
+
+// slide 剩余过期时间低于阈值时延长过期时间
+func (s *SlidingStore) slide(ctx context.Context, key string) {
+	remaining, err := s.reader.TTL(ctx, key)
+	if err != nil || remaining == KeepTTL || remaining >= s.threshold {
+		return
+	}
+	_ = s.extender.Touch(ctx, key, s.ttl)
+}
+
+func (s *SlidingStore) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
+	return s.inner.Set(ctx, key, data, ttl)
+}
+
+func (s *SlidingStore) Del(ctx context.Context, key string) error {
+	return s.inner.Del(ctx, key)
+}
+
+func (s *SlidingStore) IsDirectStore() bool {
+	return s.inner.IsDirectStore()
+}
+
+// TTL 获取缓存的剩余过期时间, 永不过期时返回 KeepTTL
+func (s *SlidingStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return s.reader.TTL(ctx, key)
+}
+
+// Touch 延长缓存的过期时间
+func (s *SlidingStore) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	return s.extender.Touch(ctx, key, ttl)
+}
+
+// NewSlidingStore 创建滑动过期的缓存装饰器, 读取时剩余过期时间低于 threshold 才把过期时间重置为 ttl,
+// 避免写入代价高的存储每次读取都写入, threshold <= 0 或者大于 ttl 时使用 ttl, 即每次读取都延长过期时间
+// # 注意 inner 必须实现 TTLReader 和 TTLExtender, ttl 必须大于 0, 否则 panic
+func NewSlidingStore(inner Store, ttl time.Duration, threshold time.Duration) *SlidingStore {
+	reader, ok := inner.(TTLReader)
+	if !ok {
+		panic(fmt.Sprintf("modecache: sliding store %T does not implement TTLReader", inner))
+	}
+	extender, ok := inner.(TTLExtender)
+	if !ok {
+		panic(fmt.Sprintf("modecache: sliding store %T does not implement TTLExtender", inner))
+	}
+	if ttl <= 0 {
+		panic(fmt.Sprintf("modecache: sliding store invalid ttl %s", ttl))
+	}
+	if threshold <= 0 || threshold > ttl {
+		threshold = ttl
+	}
+	return &SlidingStore{inner: inner, reader: reader, extender: extender, ttl: ttl, threshold: threshold}
+}
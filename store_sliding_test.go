@@ -0,0 +1,52 @@
+package modecache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlidingStore(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStore()
+	store := NewSlidingStore(inner, time.Minute, 30*time.Second)
+
+	// 剩余过期时间高于阈值时不延长
+	assert.NoError(t, store.Set(ctx, "fresh", 1, 50*time.Second))
+	_, err := store.Get(ctx, "fresh")
+	assert.NoError(t, err)
+	ttl, err := store.TTL(ctx, "fresh")
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, ttl, 50*time.Second)
+
+	// 剩余过期时间低于阈值时重置为 ttl
+	assert.NoError(t, store.Set(ctx, "stale", 1, 10*time.Second))
+	value, err := store.Get(ctx, "stale")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, value)
+	ttl, err = store.TTL(ctx, "stale")
+	assert.NoError(t, err)
+	assert.Greater(t, ttl, 50*time.Second)
+
+	// 永不过期的缓存不处理
+	assert.NoError(t, store.Set(ctx, "keep", 1, KeepTTL))
+	_, err = store.Get(ctx, "keep")
+	assert.NoError(t, err)
+	ttl, err = store.TTL(ctx, "keep")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(KeepTTL), ttl)
+
+	_, err = store.Get(ctx, "missing")
+	assert.ErrorIs(t, err, ErrKeyNonExistent)
+}
+
+func TestSlidingStore_Invalid(t *testing.T) {
+	assert.Panics(t, func() {
+		NewSlidingStore(failStore{}, time.Minute, 0)
+	})
+	assert.Panics(t, func() {
+		NewSlidingStore(NewMemoryStore(), 0, 0)
+	})
+}
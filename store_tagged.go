@@ -0,0 +1,150 @@
+package modecache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type ctxCacheTagsKey struct{}
+
+// WithCacheTags 在上下文中设置本次写入缓存的标签, TaggedStore 写入时把 key 记录到每个标签下
+// 控制器 query 回填缓存和 SetStore 都会使用上下文写入, 可以直接配合 Wrap 使用
+func WithCacheTags(ctx context.Context, tags ...string) context.Context {
+	return context.WithValue(ctx, ctxCacheTagsKey{}, tags)
+}
+
+// TagIndex 标签到缓存 key 的反向索引
+type TagIndex interface {
+	// AddTags 把 key 记录到每个标签下
+	AddTags(ctx context.Context, key string, tags []string) error
+	// PopTag 获取标签下的所有 key 并删除标签
+	PopTag(ctx context.Context, tag string) ([]string, error)
+}
+
+// TaggedStore 支持按标签批量删除的缓存装饰器
+// # 注意标签索引不会随缓存过期或者 Del 清理, 只在 InvalidateTag 时删除, 标签数量需要有上限
+type TaggedStore struct {
+	inner Store
+	index TagIndex
+}
+
+func (s *TaggedStore) Get(ctx context.Context, key string) (any, error) {
+	return s.inner.Get(ctx, key)
+}
+
+// Set 设置缓存, 并记录上下文中 WithCacheTags 设置的标签
+func (s *TaggedStore) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
+	tags, _ := ctx.Value(ctxCacheTagsKey{}).([]string)
+	return s.SetWithTags(ctx, key, data, ttl, tags...)
+}
+
+// SetWithTags 设置缓存, 并把 key 记录到每个标签下
+// 先记录索引再写入缓存, 避免写入成功但是索引失败导致 InvalidateTag 遗漏
+func (s *TaggedStore) SetWithTags(ctx context.Context, key string, data any, ttl time.Duration, tags ...string) error {
+	if len(tags) > 0 {
+		if err := s.index.AddTags(ctx, key, tags); err != nil {
+			return err
+		}
+	}
+	return s.inner.Set(ctx, key, data, ttl)
+}
+
+func (s *TaggedStore) Del(ctx context.Context, key string) error {
+	return s.inner.Del(ctx, key)
+}
+
+func (s *TaggedStore) IsDirectStore() bool {
+	return s.inner.IsDirectStore()
+}
+
+// InvalidateTag 删除标签下的所有缓存和标签索引
+// 删除失败时返回第一个错误, 索引已经删除, 失败的 key 需要等待缓存过期
+func (s *TaggedStore) InvalidateTag(ctx context.Context, tag string) error {
+	keys, err := s.index.PopTag(ctx, tag)
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, key := range keys {
+		if err := s.inner.Del(ctx, key); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// NewTaggedStore 创建支持按标签批量删除的缓存装饰器
+func NewTaggedStore(inner Store, index TagIndex) *TaggedStore {
+	return &TaggedStore{inner: inner, index: index}
+}
+
+// 内存标签索引, 适用于本地缓存
+type memoryTagIndex struct {
+	mu   sync.Mutex
+	tags map[string]map[string]struct{}
+}
+
+func (m *memoryTagIndex) AddTags(ctx context.Context, key string, tags []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, tag := range tags {
+		keys, ok := m.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			m.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+	return nil
+}
+
+func (m *memoryTagIndex) PopTag(ctx context.Context, tag string) ([]string, error) {
+	m.mu.Lock()
+	keys := m.tags[tag]
+	delete(m.tags, tag)
+	m.mu.Unlock()
+
+	res := make([]string, 0, len(keys))
+	for key := range keys {
+		res = append(res, key)
+	}
+	return res, nil
+}
+
+// NewMemoryTagIndex 创建内存标签索引, 适用于本地缓存
+func NewMemoryTagIndex() TagIndex {
+	return &memoryTagIndex{tags: make(map[string]map[string]struct{})}
+}
+
+// redis 标签索引, 每个标签使用一个 SET 保存 key
+type redisTagIndex struct {
+	rds    *redis.Client
+	prefix string
+}
+
+func (r *redisTagIndex) AddTags(ctx context.Context, key string, tags []string) error {
+	pipe := r.rds.Pipeline()
+	for _, tag := range tags {
+		pipe.SAdd(ctx, r.prefix+tag, key)
+	}
+	_, err := pipe.Exec(ctx)
+	return storeUnavailable(err)
+}
+
+func (r *redisTagIndex) PopTag(ctx context.Context, tag string) ([]string, error) {
+	pipe := r.rds.TxPipeline()
+	members := pipe.SMembers(ctx, r.prefix+tag)
+	pipe.Del(ctx, r.prefix+tag)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, storeUnavailable(err)
+	}
+	return members.Val(), nil
+}
+
+// NewRedisTagIndex 创建 redis 标签索引, 标签 tag 的索引保存在 prefix+tag 的 SET 中
+func NewRedisTagIndex(rd *redis.Client, prefix string) TagIndex {
+	return &redisTagIndex{rds: rd, prefix: prefix}
+}
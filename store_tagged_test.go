@@ -0,0 +1,59 @@
+package modecache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testTaggedStore(t *testing.T, store *TaggedStore) {
+	ctx := context.Background()
+
+	// 通过上下文和 SetWithTags 设置标签
+	assert.NoError(t, store.Set(WithCacheTags(ctx, "user:42"), "profile", "1", time.Minute))
+	assert.NoError(t, store.SetWithTags(ctx, "orders", "2", time.Minute, "user:42", "orders"))
+	assert.NoError(t, store.Set(ctx, "other", "3", time.Minute))
+
+	// 删除标签下的所有缓存
+	assert.NoError(t, store.InvalidateTag(ctx, "user:42"))
+	for _, key := range []string{"profile", "orders"} {
+		_, err := store.Get(ctx, key)
+		assert.ErrorIs(t, err, ErrKeyNonExistent)
+	}
+	value, err := store.Get(ctx, "other")
+	assert.NoError(t, err)
+	assert.Equal(t, "3", value)
+
+	// 标签索引已经删除, 重复删除不会报错
+	assert.NoError(t, store.InvalidateTag(ctx, "user:42"))
+	assert.NoError(t, store.InvalidateTag(ctx, "not-exist"))
+}
+
+func TestTaggedStore_Memory(t *testing.T) {
+	testTaggedStore(t, NewTaggedStore(NewMemoryStore(), NewMemoryTagIndex()))
+}
+
+func TestTaggedStore_Redis(t *testing.T) {
+	rds, cleanup := getTestRedis()
+	defer cleanup()
+
+	testTaggedStore(t, NewTaggedStore(NewRedisStore(rds), NewRedisTagIndex(rds, "tag:")))
+	assert.Equal(t, int64(1), rds.SCard(context.Background(), "tag:orders").Val())
+}
+
+func TestTaggedStore_Controller(t *testing.T) {
+	ctx := context.Background()
+	store := NewTaggedStore(NewMemoryStore(), NewMemoryTagIndex())
+	ctr := NewCacheController[int]("test-tagged", store)
+
+	// query 回填缓存时记录上下文中的标签
+	res, err := ctr.Wrap(WithCacheTags(ctx, "user:42"), "key", func(ctx context.Context) (int, error) { return 1, nil })
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res)
+
+	assert.NoError(t, store.InvalidateTag(ctx, "user:42"))
+	_, _, err = ctr.GetStore(ctx, "key")
+	assert.ErrorIs(t, err, ErrKeyNonExistent)
+}
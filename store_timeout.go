@@ -0,0 +1,59 @@
+package modecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 单次操作超时的缓存装饰器, 每次 Get/Set/Del 使用 opTimeout 派生的上下文访问 inner
+type timeoutStore struct {
+	inner     Store
+	opTimeout time.Duration
+}
+
+// timeoutErr 操作超时 (而不是调用方上下文取消) 时使用 ErrStoreUnavailable 包装错误, 保留 context.DeadlineExceeded
+func (s *timeoutStore) timeoutErr(ctx, opCtx context.Context, op string, key string, err error) error {
+	if err == nil || ctx.Err() != nil || !errors.Is(opCtx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	if errors.Is(err, ErrStoreUnavailable) {
+		return err
+	}
+	return fmt.Errorf("%w: %s %s timeout after %s, %w", ErrStoreUnavailable, op, key, s.opTimeout, err)
+}
+
+func (s *timeoutStore) Get(ctx context.Context, key string) (any, error) {
+	opCtx, cancel := context.WithTimeout(ctx, s.opTimeout)
+	defer cancel()
+	data, err := s.inner.Get(opCtx, key)
+	return data, s.timeoutErr(ctx, opCtx, "get", key, err)
+}
+
+func (s *timeoutStore) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
+	opCtx, cancel := context.WithTimeout(ctx, s.opTimeout)
+	defer cancel()
+	return s.timeoutErr(ctx, opCtx, "set", key, s.inner.Set(opCtx, key, data, ttl))
+}
+
+func (s *timeoutStore) Del(ctx context.Context, key string) error {
+	opCtx, cancel := context.WithTimeout(ctx, s.opTimeout)
+	defer cancel()
+	return s.timeoutErr(ctx, opCtx, "del", key, s.inner.Del(opCtx, key))
+}
+
+func (s *timeoutStore) IsDirectStore() bool {
+	return s.inner.IsDirectStore()
+}
+
+// NewTimeoutStore 创建单次操作超时的缓存装饰器, 每次 Get/Set/Del 最多执行 opTimeout,
+// 避免存储卡住时阻塞请求的整个截止时间, 超时返回 ErrStoreUnavailable 错误 (同时包装 context.DeadlineExceeded)
+// opTimeout <= 0 时直接返回 inner
+// # 注意只对响应上下文取消的存储 (例如 redis) 生效, 不会包装 inner 的其他可选接口
+func NewTimeoutStore(inner Store, opTimeout time.Duration) Store {
+	if opTimeout <= 0 {
+		return inner
+	}
+	return &timeoutStore{inner: inner, opTimeout: opTimeout}
+}
@@ -0,0 +1,57 @@
+package modecache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingStore 阻塞到上下文取消的存储
+type blockingStore struct {
+	Store
+}
+
+func (b blockingStore) Get(ctx context.Context, key string) (any, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (b blockingStore) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestTimeoutStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewTimeoutStore(blockingStore{Store: NewMemoryStore()}, 20*time.Millisecond)
+
+	start := time.Now()
+	_, err := store.Get(ctx, "key")
+	assert.ErrorIs(t, err, ErrStoreUnavailable)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.ErrorIs(t, store.Set(ctx, "key", 1, time.Minute), ErrStoreUnavailable)
+
+	// 调用方上下文取消时返回原始错误
+	cCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = store.Get(cCtx, "key")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NotErrorIs(t, err, ErrStoreUnavailable)
+
+	// 正常的操作不受影响
+	store = NewTimeoutStore(NewMemoryStore(), time.Second)
+	assert.NoError(t, store.Set(ctx, "key", 1, time.Minute))
+	value, err := store.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, value)
+	assert.NoError(t, store.Del(ctx, "key"))
+	_, err = store.Get(ctx, "key")
+	assert.ErrorIs(t, err, ErrKeyNonExistent)
+	assert.True(t, store.IsDirectStore())
+
+	inner := NewMemoryStore()
+	assert.Equal(t, inner, NewTimeoutStore(inner, 0))
+}
@@ -0,0 +1,158 @@
+package modecache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultWriteBehindInterval 异步批量写入的默认刷新间隔
+const DefaultWriteBehindInterval = time.Second
+
+// writeBehindItem 等待刷新的缓存项
+type writeBehindItem struct {
+	data any
+	ttl  time.Duration
+}
+
+// 异步批量写入的缓存装饰器, Set 写入内存缓冲区, 后台协程定时或者缓冲区满时批量写入 inner
+type writeBehindStore struct {
+	inner    Store
+	maxBatch int
+
+	mu       sync.RWMutex
+	closed   bool                       // 已经关闭, 之后的写入直接写入 inner
+	pending  map[string]writeBehindItem // 等待刷新的缓存, 相同 key 只保留最新的数据
+	flushing map[string]writeBehindItem // 正在刷新的缓存
+
+	flushMu sync.Mutex // 串行化刷新和删除, 避免删除后被正在刷新的旧数据覆盖
+	notify  chan struct{}
+	done    chan struct{}
+	stopped sync.WaitGroup
+	once    sync.Once
+}
+
+// Get 获取缓存, 优先读取未刷新的缓冲区, 避免读取到 inner 中的旧数据
+func (w *writeBehindStore) Get(ctx context.Context, key string) (any, error) {
+	w.mu.RLock()
+	item, ok := w.pending[key]
+	if !ok {
+		item, ok = w.flushing[key]
+	}
+	w.mu.RUnlock()
+	if ok {
+		return item.data, nil
+	}
+	return w.inner.Get(ctx, key)
+}
+
+// Set 设置缓存, 写入缓冲区后立即返回, 关闭后直接写入 inner
+func (w *writeBehindStore) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return w.inner.Set(ctx, key, data, ttl)
+	}
+	w.pending[key] = writeBehindItem{data: data, ttl: ttl}
+	full := w.maxBatch > 0 && len(w.pending) >= w.maxBatch
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.notify <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Del 删除缓存, 同时删除缓冲区和 inner 中的数据
+func (w *writeBehindStore) Del(ctx context.Context, key string) error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	delete(w.pending, key)
+	w.mu.Unlock()
+	return w.inner.Del(ctx, key)
+}
+
+func (w *writeBehindStore) IsDirectStore() bool {
+	return w.inner.IsDirectStore()
+}
+
+// flush 把缓冲区的数据写入 inner, 写入失败的数据会被丢弃
+func (w *writeBehindStore) flush(ctx context.Context) {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	if len(w.pending) == 0 {
+		w.mu.Unlock()
+		return
+	}
+	w.flushing, w.pending = w.pending, make(map[string]writeBehindItem, len(w.pending))
+	w.mu.Unlock()
+
+	for key, item := range w.flushing {
+		_ = w.inner.Set(ctx, key, item.data, item.ttl)
+	}
+
+	w.mu.Lock()
+	w.flushing = nil
+	w.mu.Unlock()
+}
+
+func (w *writeBehindStore) run(flushInterval time.Duration) {
+	defer w.stopped.Done()
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	ctx := context.Background()
+	for {
+		select {
+		case <-ticker.C:
+			w.flush(ctx)
+		case <-w.notify:
+			w.flush(ctx)
+		case <-w.done:
+			w.flush(ctx)
+			return
+		}
+	}
+}
+
+// close 停止后台协程并刷新剩余的数据, 之后的写入直接写入 inner
+func (w *writeBehindStore) close() {
+	w.once.Do(func() {
+		// 先标记关闭再停止后台协程, 标记前写入缓冲区的数据由最后一次刷新写入 inner
+		w.mu.Lock()
+		w.closed = true
+		w.mu.Unlock()
+		close(w.done)
+		w.stopped.Wait()
+	})
+}
+
+// NewWriteBehindStore 创建异步批量写入的缓存装饰器
+// Set 只写入内存缓冲区, 后台协程每 flushInterval 或者缓冲区达到 maxBatch 个 key 时批量写入 inner, 相同 key 只写入最新的数据
+// flushInterval <= 0 时使用 DefaultWriteBehindInterval
+// return: 缓存, 关闭方法 (刷新剩余数据并停止后台协程, 关闭后的写入同步写入 inner)
+// # 注意刷新写入 inner 失败的数据会被丢弃, 进程退出前需要调用关闭方法避免丢失数据
+func NewWriteBehindStore(inner Store, flushInterval time.Duration, maxBatch int) (Store, func()) {
+	if flushInterval <= 0 {
+		flushInterval = DefaultWriteBehindInterval
+	}
+	w := &writeBehindStore{
+		inner:    inner,
+		maxBatch: maxBatch,
+		pending:  make(map[string]writeBehindItem),
+		notify:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	w.stopped.Add(1)
+	GO(func() {
+		w.run(flushInterval)
+	})
+	return w, w.close
+}
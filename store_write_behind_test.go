@@ -0,0 +1,89 @@
+package modecache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteBehindStore(t *testing.T) {
+	inner := NewMemoryStore()
+	store, closer := NewWriteBehindStore(inner, time.Hour, 0)
+	defer closer()
+
+	// 写入缓冲区, 还未刷新到 inner
+	assert.NoError(t, store.Set(context.Background(), "key", 1, time.Minute))
+	assert.NoError(t, store.Set(context.Background(), "key", 2, time.Minute))
+	_, err := inner.Get(context.Background(), "key")
+	assert.ErrorIs(t, err, ErrKeyNonExistent)
+
+	// 读取缓冲区中最新的数据
+	value, err := store.Get(context.Background(), "key")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, value)
+
+	// 删除同时清理缓冲区
+	assert.NoError(t, store.Del(context.Background(), "key"))
+	_, err = store.Get(context.Background(), "key")
+	assert.ErrorIs(t, err, ErrKeyNonExistent)
+	assert.True(t, store.IsDirectStore())
+}
+
+func TestWriteBehindStore_Flush(t *testing.T) {
+	inner := NewMemoryStore()
+
+	// 缓冲区满时刷新
+	store, closer := NewWriteBehindStore(inner, time.Hour, 2)
+	defer closer()
+	assert.NoError(t, store.Set(context.Background(), "a", 1, time.Minute))
+	assert.NoError(t, store.Set(context.Background(), "b", 2, time.Minute))
+	assert.Eventually(t, func() bool {
+		value, err := inner.Get(context.Background(), "b")
+		return err == nil && value == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// 定时刷新
+	store, closer = NewWriteBehindStore(inner, 20*time.Millisecond, 0)
+	defer closer()
+	assert.NoError(t, store.Set(context.Background(), "c", 3, time.Minute))
+	assert.Eventually(t, func() bool {
+		value, err := inner.Get(context.Background(), "c")
+		return err == nil && value == 3
+	}, time.Second, 10*time.Millisecond)
+
+	// 关闭时刷新剩余数据
+	store, closer = NewWriteBehindStore(inner, time.Hour, 0)
+	assert.NoError(t, store.Set(context.Background(), "d", 4, time.Minute))
+	closer()
+	closer()
+	value, err := inner.Get(context.Background(), "d")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, value)
+}
+
+func TestWriteBehindStore_DefaultInterval(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStore()
+	// 非正数的刷新间隔使用默认值, 不会 panic
+	store, closer := NewWriteBehindStore(inner, 0, 0)
+	assert.NoError(t, store.Set(ctx, "key", 1, time.Minute))
+	closer()
+	data, err := inner.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, data)
+}
+
+func TestWriteBehindStore_SetAfterClose(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStore()
+	store, closer := NewWriteBehindStore(inner, time.Hour, 0)
+	closer()
+
+	// 关闭后直接写入 inner, 不会丢失
+	assert.NoError(t, store.Set(ctx, "key", 1, time.Minute))
+	data, err := inner.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, data)
+}
@@ -1,39 +1,150 @@
-package modecache
-
-import (
-	"context"
-	"sync"
-
-	"golang.org/x/sync/singleflight"
-)
-
-const Mutex128Shards = 128
-
-type Mutex128 struct {
-	mu [Mutex128Shards]sync.Mutex
-}
-
-// Lock locks rw for writing. If the lock is already locked for reading or writing,
-// then Lock blocks until the lock is available.
-func (rw *Mutex128) Lock(shard uint) {
-	rw.mu[shard%Mutex128Shards].Lock()
-}
-
-// Unlock unlocks rw for writing. It is a run-time error if rw is not locked for
-// writing on entry to Unlock.
-func (rw *Mutex128) Unlock(shard uint) {
-	rw.mu[shard%Mutex128Shards].Unlock()
-}
-
-func (rw *Mutex128) TryLock(shard uint) bool {
-	return rw.mu[shard%Mutex128Shards].TryLock()
-}
-
-type SingleflightGroup struct {
-	singleflight.Group
-}
-
-// Do 影子链路支持
-func (s *SingleflightGroup) Do(ctx context.Context, key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
-	return s.Group.Do(key, fn)
-}
+package modecache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const Mutex128Shards = 128
+
+type Mutex128 struct {
+	mu [Mutex128Shards]sync.Mutex
+}
+
+// Lock locks rw for writing. If the lock is already locked for reading or writing,
+// then Lock blocks until the lock is available.
+func (rw *Mutex128) Lock(shard uint) {
+	rw.mu[shard%Mutex128Shards].Lock()
+}
+
+// Unlock unlocks rw for writing. It is a run-time error if rw is not locked for
+// writing on entry to Unlock.
+func (rw *Mutex128) Unlock(shard uint) {
+	rw.mu[shard%Mutex128Shards].Unlock()
+}
+
+func (rw *Mutex128) TryLock(shard uint) bool {
+	return rw.mu[shard%Mutex128Shards].TryLock()
+}
+
+// ShardedMutex 分片数量可配置的分片锁
+type ShardedMutex struct {
+	mu []sync.Mutex
+}
+
+// NewShardedMutex 创建分片锁, shards <= 0 时使用 Mutex128Shards
+func NewShardedMutex(shards int) *ShardedMutex {
+	if shards <= 0 {
+		shards = Mutex128Shards
+	}
+	return &ShardedMutex{mu: make([]sync.Mutex, shards)}
+}
+
+func (rw *ShardedMutex) Lock(shard uint) {
+	rw.mu[shard%uint(len(rw.mu))].Lock()
+}
+
+func (rw *ShardedMutex) Unlock(shard uint) {
+	rw.mu[shard%uint(len(rw.mu))].Unlock()
+}
+
+func (rw *ShardedMutex) TryLock(shard uint) bool {
+	return rw.mu[shard%uint(len(rw.mu))].TryLock()
+}
+
+// refreshPool 固定数量协程的后台任务池, 限制后台刷新的总并发
+type refreshPool struct {
+	tasks chan func()
+}
+
+// newRefreshPool 创建任务池, 启动 workers 个常驻协程, 最多排队 queue 个任务
+func newRefreshPool(workers, queue int) *refreshPool {
+	p := &refreshPool{tasks: make(chan func(), queue)}
+	for i := 0; i < workers; i++ {
+		GO(func() {
+			for fn := range p.tasks {
+				safeCall(fn)
+			}
+		})
+	}
+	return p
+}
+
+// TrySubmit 提交任务, 没有空闲协程并且队列已满时丢弃任务并返回 false
+func (p *refreshPool) TrySubmit(fn func()) bool {
+	select {
+	case p.tasks <- fn:
+		return true
+	default:
+		return false
+	}
+}
+
+type SingleflightGroup struct {
+	singleflight.Group
+	inflight    sync.Map      // 正在执行的 key
+	forgetAfter time.Duration // 执行超过该时间后 Forget key, 之后的调用不再合并到本次执行, 0 表示不限制
+}
+
+// ctxNoWaitKey 上下文中设置时, 相同 key 正在执行则不等待, SingleflightGroup.Do 返回 ErrQueryInFlight
+type ctxNoWaitKey struct{}
+
+// Do 影子链路支持
+// 未实际执行 fn, 复用其他调用结果时, 记录到上下文中的控制器 (统计, OnShared 回调和 SharedObserver 插件)
+// 上下文由 TryWrap 设置不等待时, 相同 key 正在执行则立即返回 ErrQueryInFlight
+// # 注意正在执行的 key 在 fn 开始执行时记录, 刚加入合并还未开始执行的调用依然可能等待
+func (s *SingleflightGroup) Do(ctx context.Context, key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	if noWait, _ := ctx.Value(ctxNoWaitKey{}).(bool); noWait {
+		if _, ok := s.inflight.Load(key); ok {
+			return nil, ErrQueryInFlight, false
+		}
+	}
+	timeout, _ := ctx.Value(ctxQueryTimeoutKey{}).(time.Duration)
+	executed := false
+	v, err, shared = s.Group.Do(key, func() (interface{}, error) {
+		executed = true
+		s.inflight.Store(key, struct{}{})
+		defer s.inflight.Delete(key)
+		if s.forgetAfter > 0 {
+			timer := time.AfterFunc(s.forgetAfter, func() { s.Group.Forget(key) })
+			defer timer.Stop()
+		}
+		if timeout > 0 {
+			return s.doWithTimeout(key, timeout, fn)
+		}
+		return fn()
+	})
+	if shared && !executed {
+		if recorder, ok := ctx.Value(ctxSharedKey{}).(sharedRecorder); ok {
+			recorder.recordShared(ctx, key)
+		}
+	}
+	return v, err, shared
+}
+
+// doWithTimeout 最多等待 fn 执行 timeout, 超时后 Forget key 并返回 ErrQueryTimeout, fn 在后台继续执行, 结果不再返回给调用方
+func (s *SingleflightGroup) doWithTimeout(key string, timeout time.Duration, fn func() (interface{}, error)) (interface{}, error) {
+	type result struct {
+		value interface{}
+		err   error
+	}
+	done := make(chan result, 1)
+	GO(func() {
+		value, err := fn()
+		done <- result{value: value, err: err}
+	})
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		return res.value, res.err
+	case <-timer.C:
+		s.Group.Forget(key)
+		return nil, fmt.Errorf("%w: key %s after %s, %w", ErrQueryTimeout, key, timeout, context.DeadlineExceeded)
+	}
+}
@@ -1,143 +1,237 @@
-package modecache
-
-import (
-	"runtime"
-	"sync"
-	"testing"
-
-	"github.com/stretchr/testify/assert"
-)
-
-// SnakeStore represents a concurrent SnakeStore for testing
-type SnakeStore interface {
-	Set(int64, string)
-	Get(int64) string
-}
-
-func BenchmarkLockUnlock(b *testing.B) {
-	b.Run("mutex", func(b *testing.B) {
-		var mu sync.Mutex
-		for i := 0; i < b.N; i++ {
-			mu.Lock()
-			mu.Unlock()
-		}
-	})
-
-	b.Run("smutex", func(b *testing.B) {
-		var mu Mutex128
-		for i := 0; i < b.N; i++ {
-			mu.Lock(uint(i % Mutex128Shards))
-			mu.Unlock(uint(i % Mutex128Shards))
-		}
-	})
-}
-
-func TestMutex(t *testing.T) {
-	var mu Mutex128
-	var wg sync.WaitGroup
-	var resource, out string
-
-	// Acquire a write lock
-	mu.Lock(1)
-
-	// Concurrently, start a reader
-	wg.Add(1)
-	go func() {
-		mu.Lock(1)
-		defer mu.Unlock(1)
-		out = resource
-		wg.Done()
-	}()
-
-	// Write the resource
-	resource = "hello"
-	mu.Unlock(1)
-
-	// Wait for the reader to finish
-	wg.Wait()
-	assert.Equal(t, "hello", out)
-}
-
-// --------------------------- Locked Map ----------------------------
-
-const work = 1000
-
-// An implementation of a locked map using a mutex
-type lockedMap struct {
-	mu   sync.RWMutex
-	data map[int64]string
-}
-
-func newLocked() *lockedMap {
-	return &lockedMap{data: make(map[int64]string)}
-}
-
-// Set sets the value into a locked map
-func (l *lockedMap) Set(k int64, v string) {
-	l.mu.Lock()
-	for i := 0; i < work; i++ {
-		l.data[k] = v
-	}
-	runtime.Gosched()
-	for i := 0; i < work; i++ {
-		l.data[k] = v
-	}
-	l.mu.Unlock()
-}
-
-// Get gets a value from a locked map
-func (l *lockedMap) Get(k int64) (v string) {
-	l.mu.RLock()
-	for i := 0; i < work; i++ {
-		v, _ = l.data[k]
-	}
-	runtime.Gosched()
-	for i := 0; i < work; i++ {
-		v, _ = l.data[k]
-	}
-	l.mu.RUnlock()
-	return
-}
-
-// --------------------------- Sharded Map ----------------------------
-
-// An implementation of a locked map using a smutex
-type shardedMap struct {
-	mu   Mutex128
-	data []map[int64]string
-}
-
-func newSharded() *shardedMap {
-	m := &shardedMap{}
-	for i := 0; i < Mutex128Shards; i++ {
-		m.data = append(m.data, map[int64]string{})
-	}
-	return m
-}
-
-// Set sets the value into a locked map
-func (l *shardedMap) Set(k int64, v string) {
-	l.mu.Lock(uint(k))
-	for i := 0; i < work; i++ {
-		l.data[k%Mutex128Shards][k] = v
-	}
-	runtime.Gosched()
-	for i := 0; i < work; i++ {
-		l.data[k%Mutex128Shards][k] = v
-	}
-	l.mu.Unlock(uint(k))
-}
-
-// Get gets a value from a locked map
-func (l *shardedMap) Get(k int64) (v string) {
-	l.mu.Lock(uint(k))
-	for i := 0; i < work; i++ {
-		v, _ = l.data[k%Mutex128Shards][k]
-	}
-	runtime.Gosched()
-	for i := 0; i < work; i++ {
-		v, _ = l.data[k%Mutex128Shards][k]
-	}
-	l.mu.Unlock(uint(k))
-	return
-}
+package modecache
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// SnakeStore represents a concurrent SnakeStore for testing
+type SnakeStore interface {
+	Set(int64, string)
+	Get(int64) string
+}
+
+func BenchmarkLockUnlock(b *testing.B) {
+	b.Run("mutex", func(b *testing.B) {
+		var mu sync.Mutex
+		for i := 0; i < b.N; i++ {
+			mu.Lock()
+			mu.Unlock()
+		}
+	})
+
+	b.Run("smutex", func(b *testing.B) {
+		var mu Mutex128
+		for i := 0; i < b.N; i++ {
+			mu.Lock(uint(i % Mutex128Shards))
+			mu.Unlock(uint(i % Mutex128Shards))
+		}
+	})
+}
+
+func TestMutex(t *testing.T) {
+	var mu Mutex128
+	var wg sync.WaitGroup
+	var resource, out string
+
+	// Acquire a write lock
+	mu.Lock(1)
+
+	// Concurrently, start a reader
+	wg.Add(1)
+	go func() {
+		mu.Lock(1)
+		defer mu.Unlock(1)
+		out = resource
+		wg.Done()
+	}()
+
+	// Write the resource
+	resource = "hello"
+	mu.Unlock(1)
+
+	// Wait for the reader to finish
+	wg.Wait()
+	assert.Equal(t, "hello", out)
+}
+
+// --------------------------- Locked Map ----------------------------
+
+const work = 1000
+
+// An implementation of a locked map using a mutex
+type lockedMap struct {
+	mu   sync.RWMutex
+	data map[int64]string
+}
+
+func newLocked() *lockedMap {
+	return &lockedMap{data: make(map[int64]string)}
+}
+
+// Set sets the value into a locked map
+func (l *lockedMap) Set(k int64, v string) {
+	l.mu.Lock()
+	for i := 0; i < work; i++ {
+		l.data[k] = v
+	}
+	runtime.Gosched()
+	for i := 0; i < work; i++ {
+		l.data[k] = v
+	}
+	l.mu.Unlock()
+}
+
+// Get gets a value from a locked map
+func (l *lockedMap) Get(k int64) (v string) {
+	l.mu.RLock()
+	for i := 0; i < work; i++ {
+		v, _ = l.data[k]
+	}
+	runtime.Gosched()
+	for i := 0; i < work; i++ {
+		v, _ = l.data[k]
+	}
+	l.mu.RUnlock()
+	return
+}
+
+// --------------------------- Sharded Map ----------------------------
+
+// An implementation of a locked map using a smutex
+type shardedMap struct {
+	mu   Mutex128
+	data []map[int64]string
+}
+
+func newSharded() *shardedMap {
+	m := &shardedMap{}
+	for i := 0; i < Mutex128Shards; i++ {
+		m.data = append(m.data, map[int64]string{})
+	}
+	return m
+}
+
+// Set sets the value into a locked map
+func (l *shardedMap) Set(k int64, v string) {
+	l.mu.Lock(uint(k))
+	for i := 0; i < work; i++ {
+		l.data[k%Mutex128Shards][k] = v
+	}
+	runtime.Gosched()
+	for i := 0; i < work; i++ {
+		l.data[k%Mutex128Shards][k] = v
+	}
+	l.mu.Unlock(uint(k))
+}
+
+// Get gets a value from a locked map
+func (l *shardedMap) Get(k int64) (v string) {
+	l.mu.Lock(uint(k))
+	for i := 0; i < work; i++ {
+		v, _ = l.data[k%Mutex128Shards][k]
+	}
+	runtime.Gosched()
+	for i := 0; i < work; i++ {
+		v, _ = l.data[k%Mutex128Shards][k]
+	}
+	l.mu.Unlock(uint(k))
+	return
+}
+
+// BenchmarkShardCollision 统计同一批不同 key 同时刷新时, 因为分片冲突而无法获取刷新锁的数量
+func BenchmarkShardCollision(b *testing.B) {
+	const batch = 64
+	keys := make([]string, 0, 4096)
+	for i := 0; i < cap(keys); i++ {
+		keys = append(keys, fmt.Sprintf("tenant:%d:user:%d:profile", i%8, i*16))
+	}
+
+	cases := []struct {
+		name   string
+		hasher func(string) uint
+		shards int
+	}{
+		{name: "crc32-128", hasher: hashCrc32ToUint, shards: Mutex128Shards},
+		{name: "fnv1a-128", hasher: HashFnv1aToUint, shards: Mutex128Shards},
+		{name: "fnv1a-1024", hasher: HashFnv1aToUint, shards: 1024},
+	}
+	for _, tt := range cases {
+		b.Run(tt.name, func(b *testing.B) {
+			mu := NewShardedMutex(tt.shards)
+			collisions := 0
+			locked := make([]uint, 0, batch)
+			for i := 0; i < b.N; i++ {
+				offset := (i * batch) % len(keys)
+				for j := 0; j < batch; j++ {
+					shard := tt.hasher(keys[(offset+j)%len(keys)])
+					if !mu.TryLock(shard) {
+						collisions++
+						continue
+					}
+					locked = append(locked, shard)
+				}
+				for _, shard := range locked {
+					mu.Unlock(shard)
+				}
+				locked = locked[:0]
+			}
+			b.ReportMetric(float64(collisions)/float64(b.N), "collisions/op")
+		})
+	}
+}
+
+func TestSingleflightForgetAfter(t *testing.T) {
+	ctx := context.Background()
+	sg := SingleflightGroup{forgetAfter: 20 * time.Millisecond}
+	var calls atomic.Int64
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		calls.Add(1)
+		<-release
+		return nil, nil
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _, _ = sg.Do(ctx, "key", fn)
+	}()
+	// 超过 forgetAfter 后的调用重新执行
+	time.Sleep(50 * time.Millisecond)
+	go func() {
+		defer wg.Done()
+		_, _, _ = sg.Do(ctx, "key", fn)
+	}()
+	assert.Eventually(t, func() bool { return calls.Load() == 2 }, time.Second, 5*time.Millisecond)
+	close(release)
+	wg.Wait()
+}
+
+func TestSingleflightQueryTimeout(t *testing.T) {
+	sg := SingleflightGroup{}
+	ctx := WithQueryTimeout(context.Background(), 20*time.Millisecond)
+	release := make(chan struct{})
+	defer close(release)
+
+	// 超时返回 ErrQueryTimeout 并 Forget key
+	_, err, _ := sg.Do(ctx, "key", func() (interface{}, error) {
+		<-release
+		return 1, nil
+	})
+	assert.ErrorIs(t, err, ErrQueryTimeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// 之后的调用不再等待卡住的执行
+	v, err, _ := sg.Do(context.Background(), "key", func() (interface{}, error) { return 2, nil })
+	assert.NoError(t, err)
+	assert.Equal(t, 2, v)
+}
@@ -0,0 +1,49 @@
+package testutil
+
+import (
+	"sync"
+	"time"
+
+	"github.com/wheat-os/modecache"
+)
+
+// ManualClock 手动推进的时钟, 使用 modecache.SetClock 替换全局时钟后, 测试可以使用 Advance 精确推进时间, 不需要 sleep
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+var _ modecache.Clock = (*ManualClock)(nil)
+
+// NewManualClock 创建从 now 开始的手动时钟
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now 获取时钟的当前时间
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance 推进时钟 d
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set 设置时钟的当前时间
+func (c *ManualClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// UseManualClock 使用从当前时间开始的手动时钟替换全局时钟, 返回的 restore 恢复系统时钟, 通常配合 t.Cleanup 使用
+func UseManualClock() (clock *ManualClock, restore func()) {
+	clock = NewManualClock(time.Now())
+	modecache.SetClock(clock)
+	return clock, func() { modecache.SetClock(nil) }
+}
@@ -0,0 +1,40 @@
+package testutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wheat-os/modecache"
+)
+
+func TestManualClock(t *testing.T) {
+	clock, restore := UseManualClock()
+	t.Cleanup(restore)
+
+	ctx := context.Background()
+	ctr := modecache.NewCacheController[int]("test-manual-clock", modecache.NewMemoryStore(),
+		modecache.WithPolicy[int](modecache.ReuseCachePloyIgnoreError(time.Minute)))
+
+	calls := 0
+	query := func(ctx context.Context) (int, error) {
+		calls++
+		return calls, nil
+	}
+	res, err := ctr.Wrap(ctx, "key", query)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+
+	// 业务过期时间内使用缓存
+	clock.Advance(30 * time.Second)
+	res, err = ctr.Wrap(ctx, "key", query)
+	require.NoError(t, err)
+	require.Equal(t, 1, res)
+
+	// 推进时钟后缓存过期, 重新执行 query
+	clock.Advance(time.Minute)
+	res, err = ctr.Wrap(ctx, "key", query)
+	require.NoError(t, err)
+	require.Equal(t, 2, res)
+}
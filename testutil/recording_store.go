@@ -0,0 +1,120 @@
+// Package testutil 提供测试使用 modecache 的代码时使用的工具
+package testutil
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/wheat-os/modecache"
+)
+
+// Op 存储操作类型
+type Op string
+
+const (
+	OpGet Op = "get"
+	OpSet Op = "set"
+	OpDel Op = "del"
+)
+
+// Call 一次存储操作的记录
+type Call struct {
+	Op    Op
+	Key   string
+	Value any           // Set 写入的数据或者 Get 读取到的数据
+	TTL   time.Duration // Set 的过期时间
+	Err   error         // 操作返回的错误
+}
+
+// RecordingStore 记录调用的内存存储, 按调用顺序记录 Get/Set/Del, 便于在测试中断言缓存的访问
+// 数据保存在 map 中, 不会过期, ttl 只记录不生效; 作为直接存储使用, 控制器写入的是 *modecache.AbcBox[T]
+type RecordingStore struct {
+	mu    sync.Mutex
+	data  map[string]any
+	calls []Call
+}
+
+var _ modecache.Store = (*RecordingStore)(nil)
+
+// Get 获取缓存, 缓存不存在时返回 modecache.ErrKeyNonExistent 错误
+func (s *RecordingStore) Get(ctx context.Context, key string) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.data[key]
+	var err error
+	if !ok {
+		err = modecache.ErrKeyNonExistent
+	}
+	s.calls = append(s.calls, Call{Op: OpGet, Key: key, Value: value, Err: err})
+	return value, err
+}
+
+// Set 设置缓存
+func (s *RecordingStore) Set(ctx context.Context, key string, data any, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = data
+	s.calls = append(s.calls, Call{Op: OpSet, Key: key, Value: data, TTL: ttl})
+	return nil
+}
+
+// Del 删除缓存
+func (s *RecordingStore) Del(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+	s.calls = append(s.calls, Call{Op: OpDel, Key: key})
+	return nil
+}
+
+func (s *RecordingStore) IsDirectStore() bool {
+	return true
+}
+
+// Calls 获取全部调用记录, 按调用顺序排列
+func (s *RecordingStore) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.calls)
+}
+
+// GetCalls 获取 key 的全部调用记录, 按调用顺序排列
+func (s *RecordingStore) GetCalls(key string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var calls []Call
+	for _, call := range s.calls {
+		if call.Key == key {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// CountOps 统计 key 的 op 操作次数, key 为空时统计全部 key
+func (s *RecordingStore) CountOps(key string, op Op) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var count int
+	for _, call := range s.calls {
+		if call.Op == op && (key == "" || call.Key == key) {
+			count++
+		}
+	}
+	return count
+}
+
+// Reset 清空数据和调用记录
+func (s *RecordingStore) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = make(map[string]any)
+	s.calls = nil
+}
+
+// NewRecordingStore 创建记录调用的内存存储
+func NewRecordingStore() *RecordingStore {
+	return &RecordingStore{data: make(map[string]any)}
+}
@@ -0,0 +1,43 @@
+package testutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wheat-os/modecache"
+)
+
+func TestRecordingStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewRecordingStore()
+	ctr := modecache.NewCacheController[int]("test-recording", store,
+		modecache.WithPolicy[int](modecache.EasyPloy(time.Minute)))
+
+	query := func(ctx context.Context) (int, error) { return 1, nil }
+	for i := 0; i < 2; i++ {
+		res, err := ctr.Wrap(ctx, "key", query)
+		require.NoError(t, err)
+		require.Equal(t, 1, res)
+	}
+
+	// 未命中读取, 写入, 命中读取
+	calls := store.GetCalls("key")
+	require.Len(t, calls, 3)
+	require.Equal(t, OpGet, calls[0].Op)
+	require.ErrorIs(t, calls[0].Err, modecache.ErrKeyNonExistent)
+	require.Equal(t, OpSet, calls[1].Op)
+	require.Equal(t, time.Minute, calls[1].TTL)
+	require.Equal(t, OpGet, calls[2].Op)
+	require.NoError(t, calls[2].Err)
+	require.Equal(t, 2, store.CountOps("key", OpGet))
+
+	require.NoError(t, modecache.DeleteStore(ctx, store, "key"))
+	require.Equal(t, 1, store.CountOps("", OpDel))
+
+	store.Reset()
+	require.Empty(t, store.Calls())
+	_, err := store.Get(ctx, "key")
+	require.ErrorIs(t, err, modecache.ErrKeyNonExistent)
+}
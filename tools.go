@@ -1,43 +1,129 @@
-package modecache
-
-import (
-	"hash/crc32"
-	"reflect"
-	"time"
-)
-
-func usePrecise(dur time.Duration) bool {
-	return dur < time.Second || dur%time.Second != 0
-}
-
-// 检查是否使用 毫秒
-func formatMs(dur time.Duration) int64 {
-	if dur > 0 && dur < time.Millisecond {
-		return 1
-	}
-	return int64(dur / time.Millisecond)
-}
-
-// 检查使用秒
-func formatSec(dur time.Duration) int64 {
-	if dur > 0 && dur < time.Second {
-		return 1
-	}
-	return int64(dur / time.Second)
-}
-
-func isNil(v any) bool {
-	return v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil())
-}
-
-func hashCrc32ToUint(key string) uint {
-	crc := crc32.NewIEEE()
-	_, _ = crc.Write([]byte(key))
-	return uint(crc.Sum32())
-}
-
-func GO(fn func()) {
-	go func() {
-		fn()
-	}()
-}
+package modecache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"log"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+func usePrecise(dur time.Duration) bool {
+	return dur < time.Second || dur%time.Second != 0
+}
+
+// isInterfaceType 判断 T 是否为接口类型
+func isInterfaceType[T any]() bool {
+	return reflect.TypeFor[T]().Kind() == reflect.Interface
+}
+
+// 检查是否使用 毫秒
+func formatMs(dur time.Duration) int64 {
+	if dur > 0 && dur < time.Millisecond {
+		return 1
+	}
+	return int64(dur / time.Millisecond)
+}
+
+// 检查使用秒
+func formatSec(dur time.Duration) int64 {
+	if dur > 0 && dur < time.Second {
+		return 1
+	}
+	return int64(dur / time.Second)
+}
+
+// isNil 判断 v 是否为 nil 或者 nil 指针
+// 常见的标量类型不可能为 nil, 使用类型断言跳过反射, 其他类型使用反射判断
+func isNil(v any) bool {
+	switch v.(type) {
+	case nil:
+		return true
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64,
+		float32, float64, string, bool, []byte:
+		return false
+	}
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+func hashCrc32ToUint(key string) uint {
+	crc := crc32.NewIEEE()
+	_, _ = crc.Write([]byte(key))
+	return uint(crc.Sum32())
+}
+
+// HashFnv1aToUint 使用 FNV-1a 计算 key 的哈希, 可以配合 WithShardHasher 使用
+func HashFnv1aToUint(key string) uint {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return uint(h.Sum64())
+}
+
+// sha256Hex 计算 key 的 sha256 十六进制编码, WithMaxKeyLength 默认的哈希函数
+func sha256Hex(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// PanicHandler 后台协程 panic 的处理方法, recovered 为 recover 的值, stack 为 panic 时的调用栈
+type PanicHandler func(recovered any, stack []byte)
+
+// panicHandler 自定义的 panic 处理方法, 为空时使用 log 输出
+var panicHandler atomic.Pointer[PanicHandler]
+
+// SetPanicHandler 设置后台协程 (GO 启动的协程, 例如策略的异步刷新) panic 的处理方法, 可以在运行时调用
+// 默认使用标准库 log 输出 panic 和调用栈, fn 为空时恢复默认处理
+func SetPanicHandler(fn PanicHandler) {
+	if fn == nil {
+		panicHandler.Store(nil)
+		return
+	}
+	panicHandler.Store(&fn)
+}
+
+// safeCall 执行 fn 并捕获 panic, fn 中的 defer 依然执行, 例如释放分片锁
+func safeCall(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			if handler := panicHandler.Load(); handler != nil {
+				(*handler)(r, stack)
+				return
+			}
+			log.Printf("modecache: goroutine panic: %v\n%s", r, stack)
+		}
+	}()
+	fn()
+}
+
+// GO 启动后台协程, 捕获 panic 避免进程退出, panic 通过 SetPanicHandler 设置的方法处理
+func GO(fn func()) {
+	go safeCall(fn)
+}
+
+// NewKeyValidator 创建默认的缓存键校验, 配合 WithKeyValidator 使用, 拒绝空的以及包含空白或者控制字符的缓存键
+// warnHashTag 为 true 时, 缓存键包含 Redis Cluster 哈希标签字符 ({ 或 }) 时使用标准库 log 输出警告,
+// 每个校验方法只输出一次, 哈希标签会改变 key 的分片路由
+func NewKeyValidator(warnHashTag bool) func(key string) error {
+	var warned atomic.Bool
+	return func(key string) error {
+		if key == "" {
+			return fmt.Errorf("%w: empty key", ErrInvalidKey)
+		}
+		if i := strings.IndexFunc(key, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }); i >= 0 {
+			return fmt.Errorf("%w: %q contains whitespace or control character at %d", ErrInvalidKey, key, i)
+		}
+		if warnHashTag && strings.ContainsAny(key, "{}") && warned.CompareAndSwap(false, true) {
+			log.Printf("modecache: key %q contains redis cluster hash tag characters", key)
+		}
+		return nil
+	}
+}
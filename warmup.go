@@ -0,0 +1,61 @@
+package modecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Warmup 执行 jobs 获取预热数据并写入缓存, 使用 WithWarmupConcurrency 限制并发写入数量
+// 写入失败不会中断预热, 所有写入错误合并后返回 (nil 的任务记录为错误并跳过); ctx 取消时停止提交新的写入
+// return: 成功写入的数量, 错误
+func (c *CacheCtr[T]) Warmup(ctx context.Context, jobs TimerJobList[T], opts ...WarmupOption) (int, error) {
+	o := &warmupOptions{concurrency: 1}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	tasks, err := jobs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		g      errgroup.Group
+		warmed atomic.Int64
+		mu     sync.Mutex
+		errs   []error
+	)
+	g.SetLimit(o.concurrency)
+	for i, task := range tasks {
+		if ctx.Err() != nil {
+			break
+		}
+		if task == nil {
+			mu.Lock()
+			errs = append(errs, fmt.Errorf("modecache: warmup task %d is nil", i))
+			mu.Unlock()
+			continue
+		}
+		g.Go(func() error {
+			if err := c.SetStore(ctx, task.Key, task.T, task.TTL); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+				return nil
+			}
+			warmed.Add(1)
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return int(warmed.Load()), errors.Join(errs...)
+}
@@ -0,0 +1,42 @@
+package modecache
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarmup(t *testing.T) {
+	ctx := context.Background()
+	ctr := NewCacheController[int]("test-warmup", NewMemoryStore())
+
+	jobs := func(ctx context.Context) ([]*TaskResult[int], error) {
+		tasks := make([]*TaskResult[int], 0, 100)
+		for i := 0; i < 100; i++ {
+			tasks = append(tasks, &TaskResult[int]{Key: "key" + strconv.Itoa(i), T: i, TTL: time.Minute})
+		}
+		// 非法的过期时间写入失败, nil 的任务记录为错误
+		tasks = append(tasks, &TaskResult[int]{Key: "invalid", T: 1, TTL: 0}, nil)
+		return tasks, nil
+	}
+
+	warmed, err := ctr.Warmup(ctx, jobs, WithWarmupConcurrency(8))
+	require.ErrorIs(t, err, ErrInvalidTTL)
+	require.ErrorContains(t, err, "warmup task 101 is nil")
+	require.Equal(t, 100, warmed)
+	res, _, err := ctr.GetStore(ctx, "key99")
+	require.NoError(t, err)
+	require.Equal(t, 99, res)
+
+	// ctx 取消时停止预热
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	warmed, err = ctr.Warmup(cancelCtx, func(ctx context.Context) ([]*TaskResult[int], error) {
+		return []*TaskResult[int]{{Key: "canceled", T: 1, TTL: time.Minute}}, nil
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Zero(t, warmed)
+}